@@ -43,3 +43,56 @@ app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
 {{- end }}
 app.kubernetes.io/managed-by: {{ .Release.Service }}
 {{- end -}}
+
+{{/*
+RBAC rules for the operator, used by the ClusterRole or each Role
+*/}}
+{{- define "inlets-operator.rules" }}
+- apiGroups: ["operator.inlets.dev"]
+  resources: ["tunnels", "tunnels/finalizers", "tunnels/status"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+- apiGroups: ["operator.inlets.dev"]
+  resources: ["exitserverpools", "exitserverpools/status"]
+  verbs: ["get", "list", "watch", "update", "patch"]
+- apiGroups: ["operator.inlets.dev"]
+  resources: ["tunnelgrants"]
+  verbs: ["get", "list", "watch"]
+- apiGroups: [""]
+  resources: ["services", "services/status", "services/finalizers"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+- apiGroups: [""]
+  resources: ["secrets"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+- apiGroups: [""]
+  resources: ["configmaps"]
+  verbs: ["get", "list", "watch"]
+- apiGroups: [""]
+  resources: ["events"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+- apiGroups: ["apps"]
+  resources: ["deployments", "deployments/finalizers"]
+  verbs: ["get", "list", "watch", "create", "update", "patch", "delete"]
+{{- if .Values.gatewayAPI }}
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources: ["gateways"]
+  verbs: ["get", "list", "watch"]
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources: ["gateways/status"]
+  verbs: ["update", "patch"]
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources: ["gateways/finalizers"]
+  verbs: ["update"]
+{{- end }}
+{{- end }}
+
+{{/*
+RBAC rules for the cluster-scoped GatewayClasses
+*/}}
+{{- define "inlets-operator.gatewayClassRules" }}
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources: ["gatewayclasses"]
+  verbs: ["get", "list", "watch"]
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources: ["gatewayclasses/status"]
+  verbs: ["update", "patch"]
+{{- end }}
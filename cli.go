@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	provision "github.com/inlets/cloud-provision/provision"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	clientset "github.com/inlets/inlets-operator/pkg/generated/clientset/versioned"
+)
+
+// command is a subcommand of the inlets-operator binary, run from a
+// workstation with a kubeconfig rather than in the cluster.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+// commands is set in init, as the commands print their own usage
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"list": {
+			usage: "list [flags]: list Tunnels with their provider, host ID, IP, age and estimated cost",
+			run:   runList,
+		},
+		"status": {
+			usage: "status [flags] <tunnel>: show a Tunnel, its client pods and its exit server side by side",
+			run:   runStatus,
+		},
+		"gc": {
+			usage: "gc [flags]: find exit servers at the provider which no Tunnel uses, and delete them with -delete",
+			run:   runGC,
+		},
+	}
+}
+
+// usage prints the flags for the controller, and the subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: inlets-operator [flags]\n       inlets-operator <command> [flags]\n\nCommands:\n")
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
+	}
+
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// hostLister is implemented by the provisioners which can list the exit
+// servers they created.
+type hostLister interface {
+	List(filter provision.ListFilter) ([]*provision.ProvisionedHost, error)
+}
+
+// newCommandFlags returns a FlagSet with the flags common to the
+// subcommands.
+func newCommandFlags(name string, kubeconfig, namespace *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(kubeconfig, "kubeconfig", "", "Path to a kubeconfig, the default is $KUBECONFIG or ~/.kube/config")
+	fs.StringVar(namespace, "namespace", "", "Namespace of the Tunnels")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: inlets-operator %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// getCommandClientConfig loads the kubeconfig the same way as kubectl.
+func getCommandClientConfig(kubeconfig string) clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+}
+
+func getCommandClients(cfg *restclient.Config) (kubernetes.Interface, clientset.Interface, error) {
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building kubernetes clientset: %s", err)
+	}
+
+	operatorClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building operator clientset: %s", err)
+	}
+
+	return kubeClient, operatorClient, nil
+}
+
+// getCommandProvisioner returns the provisioner for the provider flags,
+// or nil when no provider was given.
+func getCommandProvisioner(infra *InfraConfig) (provision.Provisioner, error) {
+	if len(infra.Provider) == 0 {
+		return nil, nil
+	}
+	c := &Controller{infraConfig: newConfigStore(infra)}
+	return getProvisioner(c, infra.Region)
+}
+
+func runList(args []string) error {
+	var kubeconfig, namespace string
+	fs := newCommandFlags("list", &kubeconfig, &namespace)
+	fs.Lookup("namespace").Usage = "Namespace of the Tunnels, leave empty to list all namespaces"
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := getCommandClientConfig(kubeconfig).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("error loading kubeconfig: %s", err)
+	}
+	_, operatorClient, err := getCommandClients(cfg)
+	if err != nil {
+		return err
+	}
+
+	tunnels, err := operatorClient.OperatorV1alpha1().
+		Tunnels(namespace).
+		List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing tunnels: %s", err)
+	}
+
+	printTunnelList(os.Stdout, tunnels.Items, time.Now())
+	return nil
+}
+
+// printTunnelList writes a table of the Tunnels, sorted by namespace and
+// name.
+func printTunnelList(out io.Writer, tunnels []inletsv1alpha1.Tunnel, now time.Time) {
+	sort.Slice(tunnels, func(i, j int) bool {
+		if tunnels[i].Namespace != tunnels[j].Namespace {
+			return tunnels[i].Namespace < tunnels[j].Namespace
+		}
+		return tunnels[i].Name < tunnels[j].Name
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tSERVICE\tSTATUS\tPROVIDER\tHOST ID\tIP\tAGE\tMONTHLY COST")
+	for _, t := range tunnels {
+		service := ""
+		if t.Spec.ServiceRef != nil {
+			service = t.Spec.ServiceRef.Name
+		}
+
+		provider := ""
+		if t.Status.PoolAllocation != nil {
+			provider = "pool/" + t.Status.PoolAllocation.Pool
+		} else if t.Status.Cost != nil {
+			provider = t.Status.Cost.Provider
+		}
+
+		cost := ""
+		if t.Status.Cost != nil {
+			cost = t.Status.Cost.Monthly
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.Namespace,
+			t.Name,
+			orNone(service),
+			orNone(t.Status.HostStatus),
+			orNone(provider),
+			orNone(t.Status.HostID),
+			orNone(t.Status.HostIP),
+			duration.HumanDuration(now.Sub(t.CreationTimestamp.Time)),
+			orNone(cost))
+	}
+	w.Flush()
+}
+
+func runStatus(args []string) error {
+	var kubeconfig, namespace string
+	infra := &InfraConfig{}
+	fs := newCommandFlags("status", &kubeconfig, &namespace)
+	fs.Lookup("namespace").Usage = "Namespace of the Tunnel, the default is the kubeconfig's namespace"
+	addProviderFlags(fs, infra)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("give the name of one Tunnel")
+	}
+
+	clientConfig := getCommandClientConfig(kubeconfig)
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("error loading kubeconfig: %s", err)
+	}
+	if len(namespace) == 0 {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return err
+		}
+	}
+
+	kubeClient, operatorClient, err := getCommandClients(cfg)
+	if err != nil {
+		return err
+	}
+
+	provisioner, err := getCommandProvisioner(infra)
+	if err != nil {
+		return fmt.Errorf("error creating provisioner: %s", err)
+	}
+
+	return printTunnelStatus(os.Stdout, kubeClient, operatorClient, provisioner, namespace, fs.Arg(0), time.Now())
+}
+
+// printTunnelStatus writes the Tunnel, its client Deployment and pods, and
+// its exit server as reported by the provider. The provisioner may be nil,
+// when the exit server is not looked up.
+func printTunnelStatus(out io.Writer, kubeClient kubernetes.Interface, operatorClient clientset.Interface,
+	provisioner provision.Provisioner, namespace, name string, now time.Time) error {
+
+	tunnel, err := operatorClient.OperatorV1alpha1().
+		Tunnels(namespace).
+		Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting tunnel: %s", err)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	service := ""
+	if tunnel.Spec.ServiceRef != nil {
+		service = tunnel.Spec.ServiceRef.Name
+	}
+
+	fmt.Fprintf(w, "Tunnel:\t%s/%s\n", tunnel.Namespace, tunnel.Name)
+	fmt.Fprintf(w, "  Service:\t%s\n", orNone(service))
+	if len(tunnel.Spec.Upstream) > 0 {
+		fmt.Fprintf(w, "  Upstream:\t%s\n", tunnel.Spec.Upstream)
+	}
+	fmt.Fprintf(w, "  Status:\t%s\n", orNone(tunnel.Status.HostStatus))
+	fmt.Fprintf(w, "  Host ID:\t%s\n", orNone(tunnel.Status.HostID))
+	fmt.Fprintf(w, "  Host IP:\t%s\n", orNone(tunnel.Status.HostIP))
+	fmt.Fprintf(w, "  Region:\t%s\n", orNone(tunnel.Spec.Region))
+	if cost := tunnel.Status.Cost; cost != nil {
+		fmt.Fprintf(w, "  Cost:\t%s %s, %s monthly\n", cost.Provider, cost.Plan, orNone(cost.Monthly))
+	}
+	if conn := tunnel.Status.Connection; conn != nil {
+		lastSeen := "never"
+		if conn.LastSeen != nil {
+			lastSeen = duration.HumanDuration(now.Sub(conn.LastSeen.Time)) + " ago"
+		}
+		fmt.Fprintf(w, "  Clients:\t%d connected, last seen %s\n", conn.Clients, lastSeen)
+		if len(conn.Error) > 0 {
+			fmt.Fprintf(w, "  Connection error:\t%s\n", conn.Error)
+		}
+	}
+	for _, c := range tunnel.Status.Conditions {
+		fmt.Fprintf(w, "  %s:\t%s (%s) %s\n", c.Type, c.Status, c.Reason, c.Message)
+	}
+
+	fmt.Fprintf(w, "\nClient:\n")
+	if ref := tunnel.Status.ClientDeploymentRef; ref == nil || len(ref.Name) == 0 {
+		fmt.Fprintf(w, "  Deployment:\t%s\n", orNone(""))
+	} else {
+		printClientStatus(w, kubeClient, tunnel.Namespace, ref.Name, now)
+	}
+
+	fmt.Fprintf(w, "\nExit server:\n")
+	switch {
+	case tunnel.Status.PoolAllocation != nil:
+		fmt.Fprintf(w, "  Pool:\t%s, server %s\n", tunnel.Status.PoolAllocation.Pool, tunnel.Status.PoolAllocation.Server)
+	case len(tunnel.Status.HostID) == 0:
+		fmt.Fprintf(w, "  Status:\tnot created\n")
+	case provisioner == nil:
+		fmt.Fprintf(w, "  Status:\tunknown, give -provider and its credentials to look it up\n")
+	default:
+		host, err := provisioner.Status(tunnel.Status.HostID)
+		fmt.Fprintf(w, "  Health:\t%s\n", getHostHealth(host, err))
+		if err != nil {
+			fmt.Fprintf(w, "  Error:\t%s\n", err)
+			break
+		}
+		fmt.Fprintf(w, "  Status:\t%s\n", orNone(host.Status))
+		fmt.Fprintf(w, "  IP:\t%s\n", orNone(host.IP))
+		if len(host.IP) > 0 && host.IP != tunnel.Status.HostIP {
+			fmt.Fprintf(w, "  Warning:\tIP differs from the Tunnel's %s\n", orNone(tunnel.Status.HostIP))
+		}
+	}
+
+	return nil
+}
+
+// printClientStatus writes the client Deployment and its pods.
+func printClientStatus(w io.Writer, kubeClient kubernetes.Interface, namespace, name string, now time.Time) {
+	deployment, err := kubeClient.AppsV1().
+		Deployments(namespace).
+		Get(context.Background(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		fmt.Fprintf(w, "  Deployment:\t%s (not found)\n", name)
+		return
+	} else if err != nil {
+		fmt.Fprintf(w, "  Deployment:\t%s (%s)\n", name, err)
+		return
+	}
+
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	fmt.Fprintf(w, "  Deployment:\t%s, %d/%d ready\n", name, deployment.Status.ReadyReplicas, desired)
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		fmt.Fprintf(w, "  Pods:\t%s\n", err)
+		return
+	}
+
+	pods, err := kubeClient.CoreV1().
+		Pods(namespace).
+		List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		fmt.Fprintf(w, "  Pods:\t%s\n", err)
+		return
+	}
+
+	for _, pod := range pods.Items {
+		restarts := int32(0)
+		ready := "not ready"
+		for _, s := range pod.Status.ContainerStatuses {
+			restarts += s.RestartCount
+			if s.Ready {
+				ready = "ready"
+			}
+		}
+		fmt.Fprintf(w, "  Pod:\t%s, %s, %s, %d restarts, %s\n",
+			pod.Name,
+			pod.Status.Phase,
+			ready,
+			restarts,
+			duration.HumanDuration(now.Sub(pod.CreationTimestamp.Time)))
+	}
+}
+
+func runGC(args []string) error {
+	var kubeconfig, namespace string
+	var remove bool
+	var gracePeriod time.Duration
+	infra := &InfraConfig{}
+	fs := newCommandFlags("gc", &kubeconfig, &namespace)
+	fs.Lookup("namespace").Usage = "Unused, Tunnels in all namespaces are checked"
+	fs.BoolVar(&remove, "delete", false, "Delete the orphaned exit servers, instead of only listing them")
+	fs.DurationVar(&gracePeriod, "grace-period", time.Hour, "Skip exit servers created more recently than this, as their Tunnel may not have recorded them yet")
+	addProviderFlags(fs, infra)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(infra.Provider) == 0 {
+		return fmt.Errorf("provider flag is required")
+	}
+
+	cfg, err := getCommandClientConfig(kubeconfig).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("error loading kubeconfig: %s", err)
+	}
+	kubeClient, operatorClient, err := getCommandClients(cfg)
+	if err != nil {
+		return err
+	}
+
+	if infra.ClusterName, err = getClusterName(kubeClient, infra.ClusterName); err != nil {
+		return err
+	}
+
+	provisioner, err := getCommandProvisioner(infra)
+	if err != nil {
+		return fmt.Errorf("error creating provisioner: %s", err)
+	}
+
+	tagger := getHostTagger(infra, infra.Region)
+	if tagger == nil {
+		return errGCNotSupported(infra.Provider)
+	}
+
+	return collectGarbage(os.Stdout, operatorClient, provisioner, tagger, infra, gracePeriod, remove, time.Now())
+}
+
+// getHostListFilter returns the filter for the exit servers tagged with
+// the name of the cluster by the operator.
+func getHostListFilter(infra *InfraConfig) provision.ListFilter {
+	switch infra.Provider {
+	case "digitalocean", "scaleway":
+		return provision.ListFilter{Filter: clusterTag(infra.ClusterName)}
+	case "ec2":
+		return provision.ListFilter{Filter: "tag:" + clusterTagKey + "," + infra.ClusterName, Region: infra.Region}
+	case "gce":
+		return provision.ListFilter{Filter: "labels." + clusterTagKey + "=" + infra.ClusterName, ProjectID: infra.ProjectID, Zone: infra.Zone}
+	}
+	return provision.ListFilter{}
+}
+
+// findOrphanedHosts returns the hosts which are not the exit server of
+// any of the Tunnels, nor a server in any of the pools.
+func findOrphanedHosts(hosts []*provision.ProvisionedHost, tunnels []inletsv1alpha1.Tunnel, pools []inletsv1alpha1.ExitServerPool) []*provision.ProvisionedHost {
+	used := map[string]bool{}
+	for _, t := range tunnels {
+		if len(t.Status.HostID) > 0 {
+			used[t.Status.HostID] = true
+		}
+	}
+
+	poolIPs := map[string]bool{}
+	for _, pool := range pools {
+		for _, server := range pool.Spec.Servers {
+			poolIPs[server.IP] = true
+		}
+	}
+
+	orphans := []*provision.ProvisionedHost{}
+	for _, host := range hosts {
+		if used[host.ID] || (len(host.IP) > 0 && poolIPs[host.IP]) {
+			continue
+		}
+		orphans = append(orphans, host)
+	}
+	return orphans
+}
+
+// collectGarbage lists the exit servers tagged with the cluster's name
+// at the provider which no Tunnel or pool in the cluster uses, and
+// deletes them when remove is set. Hosts created within the grace period
+// are skipped, as their Tunnel may not have recorded them yet.
+func collectGarbage(out io.Writer, operatorClient clientset.Interface, provisioner provision.Provisioner, tagger hostTagger, infra *InfraConfig, gracePeriod time.Duration, remove bool, now time.Time) error {
+	lister, ok := provisioner.(hostLister)
+	if !ok {
+		return errGCNotSupported(infra.Provider)
+	}
+
+	tunnels, err := operatorClient.OperatorV1alpha1().
+		Tunnels("").
+		List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing tunnels: %s", err)
+	}
+
+	pools, err := operatorClient.OperatorV1alpha1().
+		ExitServerPools("").
+		List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing exit server pools: %s", err)
+	}
+
+	hosts, err := lister.List(getHostListFilter(infra))
+	if err != nil {
+		return fmt.Errorf("error listing hosts: %s", err)
+	}
+
+	orphans := []*provision.ProvisionedHost{}
+	for _, host := range findOrphanedHosts(hosts, tunnels.Items, pools.Items) {
+		created, err := tagger.Created(host.ID)
+		if err != nil {
+			fmt.Fprintf(out, "Skipping %s, cannot read when it was created: %s\n", host.ID, err)
+			continue
+		}
+		if now.Sub(created) < gracePeriod {
+			fmt.Fprintf(out, "Skipping %s, created %s ago\n", host.ID, duration.HumanDuration(now.Sub(created)))
+			continue
+		}
+		orphans = append(orphans, host)
+	}
+
+	if len(orphans) == 0 {
+		fmt.Fprintf(out, "No orphaned exit servers found in %d host(s)\n", len(hosts))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "HOST ID\tIP\tSTATUS")
+	for _, host := range orphans {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", host.ID, orNone(host.IP), orNone(host.Status))
+	}
+	w.Flush()
+
+	if !remove {
+		fmt.Fprintf(out, "\n%d orphaned exit server(s), run again with -delete to delete them\n", len(orphans))
+		return nil
+	}
+
+	failed := []string{}
+	for _, host := range orphans {
+		if err := provisioner.Delete(provision.HostDeleteRequest{
+			ID:        host.ID,
+			IP:        host.IP,
+			ProjectID: infra.ProjectID,
+			Region:    infra.Region,
+			Zone:      infra.Zone,
+		}); err != nil {
+			fmt.Fprintf(out, "Error deleting %s: %s\n", host.ID, err)
+			failed = append(failed, host.ID)
+			continue
+		}
+		fmt.Fprintf(out, "Deleted %s\n", host.ID)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not delete: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func orNone(value string) string {
+	if len(value) == 0 {
+		return "<none>"
+	}
+	return value
+}
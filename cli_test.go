@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	provision "github.com/inlets/cloud-provision/provision"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+)
+
+// fakeProvisioner reports the hosts it was given, and records deletions
+type fakeProvisioner struct {
+	hosts   []*provision.ProvisionedHost
+	deleted []string
+}
+
+func (p *fakeProvisioner) Provision(provision.BasicHost) (*provision.ProvisionedHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (p *fakeProvisioner) Status(id string) (*provision.ProvisionedHost, error) {
+	for _, h := range p.hosts {
+		if h.ID == id {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("404 not found")
+}
+
+func (p *fakeProvisioner) Delete(req provision.HostDeleteRequest) error {
+	p.deleted = append(p.deleted, req.ID)
+	return nil
+}
+
+func (p *fakeProvisioner) List(provision.ListFilter) ([]*provision.ProvisionedHost, error) {
+	return p.hosts, nil
+}
+
+// fakeTagger reports when each host was created
+type fakeTagger struct {
+	created map[string]time.Time
+}
+
+func (t *fakeTagger) Tag(hostID, cluster string) error {
+	return nil
+}
+
+func (t *fakeTagger) Created(hostID string) (time.Time, error) {
+	created, ok := t.created[hostID]
+	if !ok {
+		return time.Time{}, fmt.Errorf("404 not found")
+	}
+	return created, nil
+}
+
+func Test_printTunnelList(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tunnels := []inletsv1alpha1.Tunnel{
+		*makeTestTunnel("nginx-tunnel", "default", "nginx"),
+		*makeTestTunnel("api-tunnel", "default", "api"),
+	}
+	tunnels[0].CreationTimestamp = metav1.NewTime(now.Add(-time.Hour * 5))
+	tunnels[0].Status = inletsv1alpha1.TunnelStatus{
+		HostStatus: provision.ActiveStatus,
+		HostID:     "1234",
+		HostIP:     "192.0.2.10",
+		Cost:       &inletsv1alpha1.CostStatus{Provider: "digitalocean", Plan: "s-1vcpu-1gb", Monthly: "6.00"},
+	}
+	tunnels[1].CreationTimestamp = metav1.NewTime(now.Add(-time.Minute))
+
+	out := &bytes.Buffer{}
+	printTunnelList(out, tunnels, now)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want 3 lines, but got %d: %s", len(lines), out.String())
+	}
+
+	want := []string{"default", "nginx-tunnel", "nginx", "active", "digitalocean", "1234", "192.0.2.10", "5h", "6.00"}
+	if got := strings.Fields(lines[2]); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("want %s, but got %s", want, got)
+	}
+	if !strings.Contains(lines[1], "api-tunnel") || !strings.Contains(lines[1], "<none>") {
+		t.Errorf("want api-tunnel first with no host, but got %s", lines[1])
+	}
+}
+
+func Test_findOrphanedHosts(t *testing.T) {
+	hosts := []*provision.ProvisionedHost{
+		{ID: "1234", IP: "192.0.2.10"},
+		{ID: "5678", IP: "192.0.2.11"},
+	}
+	tunnels := []inletsv1alpha1.Tunnel{
+		*makeTestTunnel("nginx-tunnel", "default", "nginx"),
+		*makeTestTunnel("api-tunnel", "default", "api"),
+	}
+	tunnels[0].Status.HostID = "1234"
+
+	got := findOrphanedHosts(hosts, tunnels, nil)
+
+	if len(got) != 1 || got[0].ID != "5678" {
+		t.Fatalf("want host 5678, but got %v", got)
+	}
+}
+
+func Test_findOrphanedHosts_PoolServer(t *testing.T) {
+	hosts := []*provision.ProvisionedHost{
+		{ID: "1234", IP: "192.0.2.10"},
+		{ID: "5678", IP: "192.0.2.11"},
+	}
+	pool := testPool.DeepCopy()
+	pool.Spec.Servers[0].IP = "192.0.2.10"
+
+	got := findOrphanedHosts(hosts, nil, []inletsv1alpha1.ExitServerPool{*pool})
+
+	if len(got) != 1 || got[0].ID != "5678" {
+		t.Fatalf("want host 5678, but got %v", got)
+	}
+}
+
+func Test_getHostListFilter(t *testing.T) {
+	got := getHostListFilter(&InfraConfig{Provider: "gce", ProjectID: "inlets", Zone: "us-central1-a", ClusterName: "prod"})
+
+	want := provision.ListFilter{Filter: "labels.inlets-cluster=prod", ProjectID: "inlets", Zone: "us-central1-a"}
+	if got != want {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_getHostListFilter_DigitalOcean(t *testing.T) {
+	got := getHostListFilter(&InfraConfig{Provider: "digitalocean", ClusterName: "prod"})
+
+	want := provision.ListFilter{Filter: "inlets-cluster:prod"}
+	if got != want {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_getClusterName_KubeSystemUID(t *testing.T) {
+	client := kubefake.NewSimpleClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "6f1d2c4e-0b1a-4c1e-9d3f-2a7b8c9d0e1f"},
+	})
+
+	got, err := getClusterName(client, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "6f1d2c4e-0b1a-4c1e-9d3f-2a7b8c9d0e1f"; got != want {
+		t.Errorf("want %s, but got %s", want, got)
+	}
+}
+
+func Test_getClusterName_Given(t *testing.T) {
+	got, err := getClusterName(kubefake.NewSimpleClientset(), "Prod.EU-West")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "prod-eu-west"; got != want {
+		t.Errorf("want %s, but got %s", want, got)
+	}
+}
+
+func Test_collectGarbage_ListsWithoutDeleting(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Status.HostID = "1234"
+	client := fake.NewSimpleClientset(tunnel)
+	provisioner := &fakeProvisioner{hosts: []*provision.ProvisionedHost{
+		{ID: "1234", IP: "192.0.2.10", Status: provision.ActiveStatus},
+		{ID: "5678", IP: "192.0.2.11", Status: provision.ActiveStatus},
+	}}
+	tagger := &fakeTagger{created: map[string]time.Time{"5678": now.Add(-time.Hour * 2)}}
+
+	out := &bytes.Buffer{}
+	if err := collectGarbage(out, client, provisioner, tagger, &InfraConfig{Provider: "digitalocean"}, time.Hour, false, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "5678") || strings.Contains(out.String(), "1234") {
+		t.Errorf("want only 5678 listed, but got %s", out.String())
+	}
+	if len(provisioner.deleted) != 0 {
+		t.Errorf("want no hosts deleted, but got %v", provisioner.deleted)
+	}
+}
+
+func Test_collectGarbage_Deletes(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Status.HostID = "1234"
+	client := fake.NewSimpleClientset(tunnel)
+	provisioner := &fakeProvisioner{hosts: []*provision.ProvisionedHost{
+		{ID: "1234", IP: "192.0.2.10"},
+		{ID: "5678", IP: "192.0.2.11"},
+	}}
+	tagger := &fakeTagger{created: map[string]time.Time{"5678": now.Add(-time.Hour * 2)}}
+
+	out := &bytes.Buffer{}
+	if err := collectGarbage(out, client, provisioner, tagger, &InfraConfig{Provider: "digitalocean"}, time.Hour, true, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(provisioner.deleted) != 1 || provisioner.deleted[0] != "5678" {
+		t.Fatalf("want 5678 deleted, but got %v", provisioner.deleted)
+	}
+}
+
+func Test_collectGarbage_SkipsRecentHosts(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	client := fake.NewSimpleClientset()
+	provisioner := &fakeProvisioner{hosts: []*provision.ProvisionedHost{
+		{ID: "1234", IP: "192.0.2.10"},
+		{ID: "5678", IP: "192.0.2.11"},
+	}}
+	tagger := &fakeTagger{created: map[string]time.Time{
+		"1234": now.Add(-time.Minute * 5),
+		"5678": now.Add(-time.Hour * 2),
+	}}
+
+	out := &bytes.Buffer{}
+	if err := collectGarbage(out, client, provisioner, tagger, &InfraConfig{Provider: "digitalocean"}, time.Hour, true, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(provisioner.deleted) != 1 || provisioner.deleted[0] != "5678" {
+		t.Fatalf("want only 5678 deleted, but got %v", provisioner.deleted)
+	}
+}
+
+func Test_collectGarbage_SkipsPoolServers(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	pool := testPool.DeepCopy()
+	pool.Spec.Servers[0].IP = "192.0.2.10"
+	client := fake.NewSimpleClientset(pool)
+	provisioner := &fakeProvisioner{hosts: []*provision.ProvisionedHost{
+		{ID: "1234", IP: "192.0.2.10"},
+	}}
+	tagger := &fakeTagger{created: map[string]time.Time{"1234": now.Add(-time.Hour * 2)}}
+
+	out := &bytes.Buffer{}
+	if err := collectGarbage(out, client, provisioner, tagger, &InfraConfig{Provider: "digitalocean"}, time.Hour, true, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(provisioner.deleted) != 0 {
+		t.Fatalf("want no hosts deleted, but got %v", provisioner.deleted)
+	}
+}
+
+func Test_printTunnelStatus(t *testing.T) {
+	now := time.Now()
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Status = inletsv1alpha1.TunnelStatus{
+		HostStatus:          provision.ActiveStatus,
+		HostID:              "1234",
+		HostIP:              "192.0.2.10",
+		ClientDeploymentRef: &inletsv1alpha1.ResourceRef{Name: "nginx-tunnel-client", Namespace: "default"},
+	}
+
+	replicas := int32(1)
+	labels := map[string]string{"app.kubernetes.io/name": "nginx-tunnel-client"}
+	kubeClient := kubefake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel-client", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+			},
+			Status: appsv1.DeploymentStatus{ReadyReplicas: 1},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel-client-abc", Namespace: "default", Labels: labels},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{Ready: true, RestartCount: 2}},
+			},
+		},
+	)
+	provisioner := &fakeProvisioner{hosts: []*provision.ProvisionedHost{
+		{ID: "1234", IP: "192.0.2.99", Status: provision.ActiveStatus},
+	}}
+
+	out := &bytes.Buffer{}
+	if err := printTunnelStatus(out, kubeClient, fake.NewSimpleClientset(tunnel), provisioner, "default", "nginx-tunnel", now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, want := range []string{
+		"nginx-tunnel-client, 1/1 ready",
+		"nginx-tunnel-client-abc, Running, ready, 2 restarts",
+		"Health:   healthy",
+		"IP differs from the Tunnel's 192.0.2.10",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want %q in output, but got:\n%s", want, out.String())
+		}
+	}
+}
+
+func Test_printTunnelStatus_NoProvider(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Status.HostID = "1234"
+
+	out := &bytes.Buffer{}
+	if err := printTunnelStatus(out, kubefake.NewSimpleClientset(), fake.NewSimpleClientset(tunnel), nil, "default", "nginx-tunnel", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "give -provider") {
+		t.Errorf("want hint to give -provider, but got:\n%s", out.String())
+	}
+}
+
+func Test_collectGarbage_UnsupportedProvider(t *testing.T) {
+	err := collectGarbage(&bytes.Buffer{}, fake.NewSimpleClientset(), &dryRunProvisioner{}, &fakeTagger{},
+		&InfraConfig{Provider: "hetzner"}, time.Hour, true, time.Now())
+	if err == nil {
+		t.Fatalf("want an error for a provider which cannot list its hosts")
+	}
+	if !strings.Contains(err.Error(), "hetzner") || !strings.Contains(err.Error(), "digitalocean, scaleway, ec2, gce") {
+		t.Errorf("want the provider and the supported providers in the error, but got %s", err)
+	}
+}
@@ -9,29 +9,95 @@ import (
 // InfraConfig is the configuration for
 // creating Infrastructure Resources
 type InfraConfig struct {
-	Provider        string
-	Region          string
-	Zone            string
-	AccessKey       string
-	SecretKey       string
-	OrganizationID  string
-	SubscriptionID  string
-	VpcID           string
-	SubnetID        string
-	AccessKeyFile   string
-	SecretKeyFile   string
-	ProjectID       string
-	AnnotatedOnly   bool
-	MaxClientMemory string
-	Plan            string
-	ProConfig       InletsProConfig
+	Provider        string          `json:"provider,omitempty"`
+	Region          string          `json:"region,omitempty"`
+	Zone            string          `json:"zone,omitempty"`
+	AccessKey       string          `json:"accessKey,omitempty"`
+	SecretKey       string          `json:"secretKey,omitempty"`
+	OrganizationID  string          `json:"organizationID,omitempty"`
+	SubscriptionID  string          `json:"subscriptionID,omitempty"`
+	VpcID           string          `json:"vpcID,omitempty"`
+	SubnetID        string          `json:"subnetID,omitempty"`
+	AccessKeyFile   string          `json:"accessKeyFile,omitempty"`
+	SecretKeyFile   string          `json:"secretKeyFile,omitempty"`
+	ProjectID       string          `json:"projectID,omitempty"`
+	AnnotatedOnly   bool            `json:"annotatedOnly,omitempty"`
+	MaxClientMemory string          `json:"maxClientMemory,omitempty"`
+	Plan            string          `json:"plan,omitempty"`
+	ProConfig       InletsProConfig `json:"inletsPro,omitempty"`
+
+	// ClusterName is written to each exit server as a tag, when empty the
+	// UID of the kube-system namespace is used.
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// WatchNamespaces limits the operator to the given namespaces,
+	// when empty all namespaces are watched.
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// NamespaceSelector is a label selector for the namespaces to
+	// manage, i.e. "inlets.dev/tunnels=enabled"
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+
+	// UserDataTemplate is a Go template for the exit server's user-data,
+	// it takes precedence over UserDataTemplateFile.
+	UserDataTemplate string `json:"userDataTemplate,omitempty"`
+
+	// UserDataTemplateFile is the path to a Go template for the exit
+	// server's user-data, i.e. from a mounted ConfigMap.
+	UserDataTemplateFile string `json:"userDataTemplateFile,omitempty"`
+
+	// Prices are hourly prices by provider and plan, used to estimate the
+	// cost of exit servers, and override the built-in prices.
+	Prices map[string]map[string]float64 `json:"prices,omitempty"`
+
+	// Quotas limit the number and estimated spend of the exit servers
+	// created by the operator.
+	Quotas QuotaConfig `json:"quotas,omitempty"`
+}
+
+// QuotaConfig limits the exit servers created for the whole cluster, and
+// for each namespace. Servers from an ExitServerPool are not counted.
+type QuotaConfig struct {
+	Cluster QuotaLimits `json:"cluster,omitempty"`
+
+	// Namespace applies to each namespace not listed in Namespaces
+	Namespace QuotaLimits `json:"namespace,omitempty"`
+
+	// Namespaces override the Namespace limits by name
+	Namespaces map[string]QuotaLimits `json:"namespaces,omitempty"`
+}
+
+// QuotaLimits are the maximum number of exit servers, and the maximum
+// estimated monthly spend on them, zero means there is no limit.
+type QuotaLimits struct {
+	MaxTunnels      int     `json:"maxTunnels,omitempty"`
+	MaxMonthlySpend float64 `json:"maxMonthlySpend,omitempty"`
 }
 
 type InletsProConfig struct {
-	License       string
-	LicenseFile   string
-	ClientImage   string
-	InletsRelease string
+	License       string `json:"license,omitempty"`
+	LicenseFile   string `json:"licenseFile,omitempty"`
+	ClientImage   string `json:"clientImage,omitempty"`
+	InletsRelease string `json:"inletsRelease,omitempty"`
+
+	// DownloadURL is the base URL for the inlets-pro server binary and
+	// its systemd unit, which are fetched from DownloadURL/<release>/
+	DownloadURL string `json:"downloadURL,omitempty"`
+
+	// Checksums are the SHA256 checksums of the files for each release,
+	// they are verified by the exit server before installing them.
+	Checksums map[string]ReleaseChecksums `json:"checksums,omitempty"`
+
+	// SkipChecksums allows exit servers to be created for a release
+	// without checksums, which then install whatever is downloaded.
+	SkipChecksums bool `json:"skipChecksums,omitempty"`
+}
+
+// ReleaseChecksums are the SHA256 checksums of the files downloaded by
+// the exit server for a release of inlets-pro.
+type ReleaseChecksums struct {
+	Server  string `json:"inlets-pro,omitempty"`
+	Service string `json:"inlets-pro.service,omitempty"`
 }
 
 func (c InletsProConfig) GetLicenseKey() (string, error) {
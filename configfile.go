@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// configMapKey is the key within the ConfigMap that holds the YAML config
+const configMapKey = "config.yaml"
+
+const (
+	// ConfigReloaded is used as part of the Event 'reason' when the
+	// config is reloaded
+	ConfigReloaded = "ConfigReloaded"
+	// ErrConfigReload is used as part of the Event 'reason' when the
+	// config could not be reloaded, and the previous config is kept
+	ErrConfigReload = "ErrConfigReload"
+)
+
+// configStore holds the current InfraConfig, so that it can be
+// replaced while workers are reading it.
+type configStore struct {
+	lock   sync.RWMutex
+	config *InfraConfig
+}
+
+func newConfigStore(config *InfraConfig) *configStore {
+	return &configStore{config: config}
+}
+
+func (s *configStore) get() *InfraConfig {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.config
+}
+
+func (s *configStore) set(config *InfraConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.config = config
+}
+
+// config returns the current InfraConfig, which must be treated as
+// read-only.
+func (c *Controller) config() *InfraConfig {
+	return c.infraConfig.get()
+}
+
+// configLoader applies a YAML config on top of the values given
+// via flags, so that removing a key from the config returns it to the
+// value of its flag.
+type configLoader struct {
+	base InfraConfig
+}
+
+// load parses and validates the YAML config, unknown keys are rejected.
+func (l configLoader) load(data []byte) (*InfraConfig, error) {
+	config := l.base
+	config.WatchNamespaces = append([]string{}, l.base.WatchNamespaces...)
+	config.ProConfig.Checksums = map[string]ReleaseChecksums{}
+	for release, sums := range l.base.ProConfig.Checksums {
+		config.ProConfig.Checksums[release] = sums
+	}
+	config.Prices = map[string]map[string]float64{}
+	for provider, plans := range l.base.Prices {
+		config.Prices[provider] = map[string]float64{}
+		for plan, price := range plans {
+			config.Prices[provider][plan] = price
+		}
+	}
+	config.Quotas.Namespaces = map[string]QuotaLimits{}
+	for ns, limits := range l.base.Quotas.Namespaces {
+		config.Quotas.Namespaces[ns] = limits
+	}
+
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing config: %s", err.Error())
+	}
+
+	if err := validateFlags(config); err != nil {
+		return nil, err
+	}
+
+	if _, err := config.ProConfig.GetLicenseKey(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// validateReload rejects changes to settings which are only read
+// when the operator starts.
+func validateReload(current, next *InfraConfig) error {
+	if !reflect.DeepEqual(current.WatchNamespaces, next.WatchNamespaces) {
+		return fmt.Errorf("watchNamespaces cannot be changed without a restart")
+	}
+	if current.NamespaceSelector != next.NamespaceSelector {
+		return fmt.Errorf("namespaceSelector cannot be changed without a restart")
+	}
+
+	// The existing exit servers were created with these, and are checked
+	// and deleted through the provider's client, and the webhook reads the
+	// provider when it starts.
+	fixed := []struct {
+		name          string
+		current, next string
+	}{
+		{"provider", current.Provider, next.Provider},
+		{"accessKey", current.AccessKey, next.AccessKey},
+		{"accessKeyFile", current.AccessKeyFile, next.AccessKeyFile},
+		{"secretKey", current.SecretKey, next.SecretKey},
+		{"secretKeyFile", current.SecretKeyFile, next.SecretKeyFile},
+		{"projectID", current.ProjectID, next.ProjectID},
+		{"zone", current.Zone, next.Zone},
+		{"subscriptionID", current.SubscriptionID, next.SubscriptionID},
+		{"clusterName", current.ClusterName, next.ClusterName},
+	}
+	for _, f := range fixed {
+		if f.current != f.next {
+			return fmt.Errorf("%s cannot be changed without a restart", f.name)
+		}
+	}
+	return nil
+}
+
+// reloadConfig applies a new config, or keeps the current one if it
+// is invalid. The outcome is recorded as an Event against ref, and
+// in the config reload metrics.
+func (c *Controller) reloadConfig(loader configLoader, data []byte, ref runtime.Object) {
+	next, err := loader.load(data)
+	if err == nil {
+		err = validateReload(c.config(), next)
+	}
+
+	if err != nil {
+		slog.Error("Error reloading config, keeping previous config", logKeyError, err)
+		configReloadsTotal.WithLabelValues("failure").Inc()
+		configLastReloadSuccess.Set(0)
+		if ref != nil {
+			c.recorder.Event(ref, corev1.EventTypeWarning, ErrConfigReload, err.Error())
+		}
+		return
+	}
+
+	c.infraConfig.set(next)
+
+	// Settings such as annotatedOnly, clientImage and maxClientMemory
+	// apply to existing Services and clients once they are synced again
+	c.enqueueAll()
+
+	slog.Info("Reloaded config",
+		logKeyProvider, next.Provider,
+		"region", next.Region,
+		"plan", next.Plan,
+		"clientImage", next.GetInletsClientImage(),
+		"serverRelease", next.GetInletsRelease())
+	configReloadsTotal.WithLabelValues("success").Inc()
+	configLastReloadSuccess.Set(1)
+	configLastReloadTime.SetToCurrentTime()
+	if ref != nil {
+		c.recorder.Event(ref, corev1.EventTypeNormal, ConfigReloaded, "Config reloaded successfully")
+	}
+}
+
+// enqueueAll re-queues every LoadBalancer Service and every Tunnel.
+func (c *Controller) enqueueAll() {
+	if services, err := c.serviceLister.List(labels.Everything()); err == nil {
+		for _, svc := range services {
+			if checkServiceType(svc) {
+				c.enqueueService(svc)
+			}
+		}
+	}
+
+	if tunnels, err := c.tunnelsLister.List(labels.Everything()); err == nil {
+		for _, t := range tunnels {
+			c.enqueueTunnel(t)
+		}
+	}
+}
+
+// watchConfigFile polls the config file for changes, a ConfigMap mounted
+// as a volume is updated in place by the kubelet, so polling is used over
+// inotify, which does not follow the symlinks it uses.
+func (c *Controller) watchConfigFile(loader configLoader, path string, interval time.Duration, stopCh <-chan struct{}) {
+	last, _ := os.ReadFile(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				slog.Error("Error reading config file", "path", path, logKeyError, err)
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+
+			slog.Info("Config file changed, reloading", "path", path)
+			c.reloadConfig(loader, data, operatorPodRef())
+		}
+	}
+}
+
+// watchConfigMap reloads the config whenever the named ConfigMap in the
+// operator's namespace is changed.
+func (c *Controller) watchConfigMap(loader configLoader, kubeClient kubernetes.Interface, namespace, name string, stopCh <-chan struct{}) {
+	factory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30,
+		kubeinformers.WithNamespace(namespace),
+		kubeinformers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldCm := old.(*corev1.ConfigMap)
+			newCm := new.(*corev1.ConfigMap)
+			if oldCm.Data[configMapKey] == newCm.Data[configMapKey] {
+				return
+			}
+
+			slog.Info("ConfigMap changed, reloading", "configMap", name, logKeyNamespace, namespace)
+			c.reloadConfig(loader, []byte(newCm.Data[configMapKey]), newCm)
+		},
+	})
+
+	factory.Start(stopCh)
+}
+
+// readConfigMap reads the YAML config from the ConfigMap when the
+// operator starts.
+func readConfigMap(kubeClient kubernetes.Interface, namespace, name string) ([]byte, error) {
+	cm, err := kubeClient.CoreV1().
+		ConfigMaps(namespace).
+		Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading config from ConfigMap %s.%s: %s", name, namespace, err.Error())
+	}
+
+	data, ok := cm.Data[configMapKey]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s.%s has no %s key", name, namespace, configMapKey)
+	}
+
+	return []byte(data), nil
+}
+
+// operatorPodRef references the operator's own Pod for Events about
+// the config file, the name is set via the downward API.
+func operatorPodRef() runtime.Object {
+	name := os.Getenv("POD_NAME")
+	if len(name) == 0 {
+		return nil
+	}
+
+	return &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       name,
+		Namespace:  readNamespace(),
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+func Test_configLoader_OverridesFlags(t *testing.T) {
+	loader := configLoader{
+		base: InfraConfig{
+			Provider:        "digitalocean",
+			Region:          "lon1",
+			AccessKey:       "set",
+			MaxClientMemory: "128Mi",
+			ProConfig: InletsProConfig{
+				License:       "static.key.text",
+				SkipChecksums: true,
+			},
+		},
+	}
+
+	got, err := loader.load([]byte(`
+region: ams3
+plan: s-2vcpu-2gb
+inletsPro:
+  clientImage: ghcr.io/inlets/inlets-pro:0.9.41
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.Region != "ams3" {
+		t.Errorf("want region %s, got %s", "ams3", got.Region)
+	}
+	if got.Plan != "s-2vcpu-2gb" {
+		t.Errorf("want plan %s, got %s", "s-2vcpu-2gb", got.Plan)
+	}
+	if got.Provider != "digitalocean" {
+		t.Errorf("want provider from flags %s, got %s", "digitalocean", got.Provider)
+	}
+	if got.ProConfig.License != "static.key.text" {
+		t.Errorf("want license from flags, got %q", got.ProConfig.License)
+	}
+	if got.GetInletsClientImage() != "ghcr.io/inlets/inlets-pro:0.9.41" {
+		t.Errorf("want client image override, got %s", got.GetInletsClientImage())
+	}
+
+	if loader.base.Region != "lon1" {
+		t.Errorf("flag values must not be modified, got region %s", loader.base.Region)
+	}
+}
+
+func Test_configLoader_RejectsUnknownKeys(t *testing.T) {
+	loader := configLoader{
+		base: InfraConfig{
+			Provider:  "digitalocean",
+			AccessKey: "set",
+			ProConfig: InletsProConfig{License: "static.key.text"},
+		},
+	}
+
+	_, err := loader.load([]byte(`regoin: ams3`))
+	if err == nil || !strings.Contains(err.Error(), "regoin") {
+		t.Fatalf("want error for unknown key, got: %v", err)
+	}
+}
+
+func Test_configLoader_Validates(t *testing.T) {
+	loader := configLoader{
+		base: InfraConfig{
+			Provider:  "digitalocean",
+			AccessKey: "set",
+			ProConfig: InletsProConfig{License: "static.key.text"},
+		},
+	}
+
+	_, err := loader.load([]byte(`maxClientMemory: lots`))
+	want := "invalid memory value"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("want error: %s, got: %v", want, err)
+	}
+}
+
+func Test_validateReload_WatchNamespaces(t *testing.T) {
+	current := &InfraConfig{WatchNamespaces: []string{"team-a"}}
+	next := &InfraConfig{WatchNamespaces: []string{"team-a", "team-b"}}
+
+	if err := validateReload(current, next); err == nil {
+		t.Fatalf("want error when watchNamespaces changes")
+	}
+
+	if err := validateReload(current, current); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+}
+
+func Test_validateReload_Provider(t *testing.T) {
+	current := &InfraConfig{Provider: "digitalocean", AccessKey: "key-1", Zone: "us-central1-a"}
+
+	cases := []struct {
+		name string
+		next InfraConfig
+	}{
+		{name: "provider", next: InfraConfig{Provider: "linode", AccessKey: "key-1", Zone: "us-central1-a"}},
+		{name: "accessKey", next: InfraConfig{Provider: "digitalocean", AccessKey: "key-2", Zone: "us-central1-a"}},
+		{name: "zone", next: InfraConfig{Provider: "digitalocean", AccessKey: "key-1", Zone: "europe-west1-b"}},
+		{name: "clusterName", next: InfraConfig{Provider: "digitalocean", AccessKey: "key-1", Zone: "us-central1-a", ClusterName: "prod"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateReload(current, &tc.next)
+			if err == nil || !strings.HasPrefix(err.Error(), tc.name+" ") {
+				t.Fatalf("want error when %s changes, but got %v", tc.name, err)
+			}
+		})
+	}
+}
+
+func Test_clientConfigChanged(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{
+		Spec: inletsv1alpha1.TunnelSpec{ServiceRef: &inletsv1alpha1.ResourceRef{Name: "nginx"}},
+	}
+	tunnel.Name = "nginx-tunnel"
+	deployment := makeClientDeployment(tunnel, "ghcr.io/inlets/inlets-pro:0.9.40", "80", "", "128Mi")
+
+	cases := []struct {
+		name      string
+		image     string
+		maxMemory string
+		want      bool
+	}{
+		{name: "unchanged", image: "ghcr.io/inlets/inlets-pro:0.9.40", maxMemory: "128Mi", want: false},
+		{name: "image", image: "ghcr.io/inlets/inlets-pro:0.9.41", maxMemory: "128Mi", want: true},
+		{name: "memory", image: "ghcr.io/inlets/inlets-pro:0.9.40", maxMemory: "256Mi", want: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := clientConfigChanged(deployment, tc.image, tc.maxMemory); got != tc.want {
+				t.Fatalf("want %t, but got %t", tc.want, got)
+			}
+		})
+	}
+}
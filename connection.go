@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	provision "github.com/inlets/cloud-provision/provision"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// serverStatusPath is the inlets-pro server's status endpoint, served on
+// the control port to callers with the tunnel's token
+const serverStatusPath = "/status"
+
+// serverStatusTimeout bounds each query of the status endpoint, so that
+// an unreachable exit server does not hold up the others
+const serverStatusTimeout = time.Second * 10
+
+// connectionCheckWorkers is how many exit servers are queried at once
+const connectionCheckWorkers = 10
+
+// connectionStatusMaxAge is how long an unchanged connection status is
+// kept before it is written again, to refresh lastChecked and lastSeen
+const connectionStatusMaxAge = time.Minute * 10
+
+// serverStatus is the response from the inlets-pro server's status
+// endpoint, only the fields used by the operator are decoded.
+type serverStatus struct {
+	Clients []serverClient `json:"clients"`
+}
+
+// serverClient is a tunnel client connected to the inlets-pro server
+type serverClient struct {
+	ID          string    `json:"clientID"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Ports       []int32   `json:"ports"`
+}
+
+// getServerStatus queries the status endpoint of the inlets-pro server
+// at baseURL, authenticating with the tunnel's token.
+func getServerStatus(ctx context.Context, client *http.Client, baseURL, token string) (*serverStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+serverStatusPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tunnel server: %s", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading tunnel server status: %s", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from tunnel server: %d", res.StatusCode)
+	}
+
+	status := &serverStatus{}
+	if err := json.Unmarshal(body, status); err != nil {
+		return nil, fmt.Errorf("error decoding tunnel server status: %s", err)
+	}
+
+	return status, nil
+}
+
+// makeConnectionStatus summarises the server's status for the Tunnel.
+// When the query failed, the client count is unknown, so is recorded as
+// zero with the error, and the last time a client was seen is kept.
+func makeConnectionStatus(status *serverStatus, queryErr error, previous *inletsv1alpha1.ConnectionStatus, now time.Time) *inletsv1alpha1.ConnectionStatus {
+	checked := metav1.NewTime(now)
+	conn := &inletsv1alpha1.ConnectionStatus{
+		LastChecked: &checked,
+	}
+	if previous != nil {
+		conn.LastSeen = previous.LastSeen.DeepCopy()
+	}
+
+	if queryErr != nil {
+		conn.Error = queryErr.Error()
+		return conn
+	}
+
+	seen := map[int32]bool{}
+	for _, client := range status.Clients {
+		if conn.ConnectedAt == nil || client.ConnectedAt.Before(conn.ConnectedAt.Time) {
+			connectedAt := metav1.NewTime(client.ConnectedAt)
+			conn.ConnectedAt = &connectedAt
+		}
+		for _, port := range client.Ports {
+			if !seen[port] {
+				seen[port] = true
+				conn.Ports = append(conn.Ports, port)
+			}
+		}
+	}
+	sort.Slice(conn.Ports, func(i, j int) bool { return conn.Ports[i] < conn.Ports[j] })
+
+	conn.Clients = int32(len(status.Clients))
+	if conn.Clients > 0 {
+		conn.LastSeen = checked.DeepCopy()
+	}
+
+	return conn
+}
+
+// setConnectedCondition sets the Connected condition from the Tunnel's
+// connection status.
+func setConnectedCondition(tunnel *inletsv1alpha1.Tunnel) {
+	conn := tunnel.Status.Connection
+
+	condition := metav1.Condition{
+		Type:               inletsv1alpha1.TunnelConnected,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ClientConnected",
+		Message:            fmt.Sprintf("%d client(s) connected to the tunnel server", conn.Clients),
+		ObservedGeneration: tunnel.Generation,
+	}
+
+	if len(conn.Error) > 0 {
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "StatusUnavailable"
+		condition.Message = conn.Error
+	} else if conn.Clients == 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NoClients"
+		condition.Message = "No clients connected to the tunnel server"
+	}
+
+	meta.SetStatusCondition(&tunnel.Status.Conditions, condition)
+}
+
+// connectionStatusChanged is true when the connection status needs to be
+// written, because something other than its timestamps changed, or it
+// has not been written for connectionStatusMaxAge.
+func connectionStatusChanged(previous, next *inletsv1alpha1.ConnectionStatus) bool {
+	if previous == nil || previous.LastChecked == nil || next.LastChecked == nil {
+		return true
+	}
+	if next.LastChecked.Sub(previous.LastChecked.Time) >= connectionStatusMaxAge {
+		return true
+	}
+
+	previousCopy := previous.DeepCopy()
+	nextCopy := next.DeepCopy()
+	for _, conn := range []*inletsv1alpha1.ConnectionStatus{previousCopy, nextCopy} {
+		conn.LastChecked = nil
+		conn.LastSeen = nil
+	}
+
+	return !apiequality.Semantic.DeepEqual(previousCopy, nextCopy)
+}
+
+// onlyConnectionChanged is true when an update to a Tunnel changed its
+// connection status and nothing else, so it does not need to be synced.
+func onlyConnectionChanged(old, new *inletsv1alpha1.Tunnel) bool {
+	if apiequality.Semantic.DeepEqual(old.Status.Connection, new.Status.Connection) {
+		return false
+	}
+
+	oldCopy := old.DeepCopy()
+	newCopy := new.DeepCopy()
+	for _, t := range []*inletsv1alpha1.Tunnel{oldCopy, newCopy} {
+		t.ResourceVersion = ""
+		t.ManagedFields = nil
+		t.Status.Connection = nil
+		meta.RemoveStatusCondition(&t.Status.Conditions, inletsv1alpha1.TunnelConnected)
+	}
+
+	return apiequality.Semantic.DeepEqual(oldCopy, newCopy)
+}
+
+// errUnverifiedCertificate is recorded instead of querying an exit server
+// whose certificate cannot be verified, since the query sends its token
+var errUnverifiedCertificate = fmt.Errorf("the tunnel server's certificate is self-signed and cannot be verified, enable -managed-tls to check connections")
+
+// newServerStatusClient returns a HTTP client for the exit server's
+// control port, which verifies the certificate issued by the operator's
+// CA. It returns nil when the exit server has no certificate from the CA,
+// since the certificate is self-signed by the inlets-pro server, and the
+// token must not be sent to whoever holds the exit server's IP.
+func newServerStatusClient(tunnel *inletsv1alpha1.Tunnel, ca *certAuthority) *http.Client {
+	if ca == nil || tunnel.Status.TLS == nil || len(tunnel.Status.TLS.ServerName) == 0 {
+		return nil
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return &http.Client{
+		Timeout: serverStatusTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    pool,
+				ServerName: tunnel.Status.TLS.ServerName,
+			},
+		},
+	}
+}
+
+// syncConnectionStatus queries the exit server of an active Tunnel, and
+// records the tunnel client's connection in the Tunnel's status. An exit
+// server which cannot be verified is recorded once, then skipped, and
+// the status is only written when it changed.
+func (c *Controller) syncConnectionStatus(ctx context.Context, tunnel *inletsv1alpha1.Tunnel) error {
+	var status *serverStatus
+	var err error
+
+	if client := newServerStatusClient(tunnel, c.ca); client == nil {
+		if tunnel.Status.Connection != nil && tunnel.Status.Connection.Error == errUnverifiedCertificate.Error() {
+			return nil
+		}
+		err = errUnverifiedCertificate
+	} else {
+		defer client.CloseIdleConnections()
+
+		token, tokenErr := getSecretValue(c, tunnel)
+		if tokenErr != nil {
+			return tokenErr
+		}
+
+		baseURL := fmt.Sprintf("https://%s:%d", tunnel.Status.HostIP, inletsPROControlPort)
+		status, err = getServerStatus(ctx, client, baseURL, token)
+	}
+
+	conn := makeConnectionStatus(status, err, tunnel.Status.Connection, time.Now())
+	if !connectionStatusChanged(tunnel.Status.Connection, conn) {
+		return nil
+	}
+
+	copy := tunnel.DeepCopy()
+	copy.Status.Connection = conn
+	setConnectedCondition(copy)
+
+	if _, err := c.operatorclientset.OperatorV1alpha1().
+		Tunnels(tunnel.Namespace).
+		UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("tunnel %s.%s connection update error: %s", tunnel.Name, tunnel.Namespace, err)
+	}
+
+	return nil
+}
+
+// checkConnections records the connection status of each active Tunnel
+// every interval, it blocks until stopCh is closed.
+func (c *Controller) checkConnections(interval time.Duration, stopCh <-chan struct{}) {
+	if ok := cache.WaitForCacheSync(stopCh, c.tunnelsSynced); !ok {
+		return
+	}
+
+	wait.Until(func() {
+		tunnels, err := c.tunnelsLister.List(labels.Everything())
+		if err != nil {
+			slog.Error("Error listing tunnels", logKeyError, err)
+			return
+		}
+
+		c.checkConnectionsOnce(tunnels, interval)
+	}, interval, stopCh)
+}
+
+// checkConnectionsOnce queries the exit servers of the active Tunnels,
+// connectionCheckWorkers at a time. Queries still running, or waiting
+// to run, after timeout are cancelled, so that one round finishes
+// before the next begins.
+func (c *Controller) checkConnectionsOnce(tunnels []*inletsv1alpha1.Tunnel, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	workers := make(chan struct{}, connectionCheckWorkers)
+	var wg sync.WaitGroup
+
+	for _, tunnel := range tunnels {
+		// Fake exit servers from -dry-run have no server to query
+		if tunnel.Status.HostStatus != provision.ActiveStatus ||
+			len(tunnel.Status.HostIP) == 0 ||
+			isDryRunHost(tunnel.Status.HostID) ||
+			!c.namespaceAllowed(tunnel.Namespace) {
+			continue
+		}
+
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			slog.Info("Connection checks did not finish in time", logKeyError, ctx.Err())
+			return
+		}
+
+		wg.Add(1)
+		go func(tunnel *inletsv1alpha1.Tunnel) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			if err := c.syncConnectionStatus(ctx, tunnel); err != nil {
+				c.tunnelLogger(tunnel).Info("Error checking connection", logKeyError, err)
+			}
+		}(tunnel)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+)
+
+// newStandInServer serves a status endpoint like the inlets-pro server's
+// on its control port, for the given token.
+func newStandInServer(token string, status serverStatus) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(serverStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+	return httptest.NewTLSServer(mux)
+}
+
+func Test_getServerStatus_Clients(t *testing.T) {
+	connectedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := newStandInServer("token", serverStatus{
+		Clients: []serverClient{
+			{ID: "one", RemoteAddr: "192.0.2.1:50000", ConnectedAt: connectedAt, Ports: []int32{80, 443}},
+		},
+	})
+	defer server.Close()
+
+	got, err := getServerStatus(context.Background(), server.Client(), server.URL, "token")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(got.Clients) != 1 {
+		t.Fatalf("want 1 client, but got %d", len(got.Clients))
+	}
+	if !got.Clients[0].ConnectedAt.Equal(connectedAt) {
+		t.Errorf("want %s, but got %s", connectedAt, got.Clients[0].ConnectedAt)
+	}
+	if !reflect.DeepEqual(got.Clients[0].Ports, []int32{80, 443}) {
+		t.Errorf("want %v, but got %v", []int32{80, 443}, got.Clients[0].Ports)
+	}
+}
+
+func Test_getServerStatus_WrongToken(t *testing.T) {
+	server := newStandInServer("token", serverStatus{})
+	defer server.Close()
+
+	if _, err := getServerStatus(context.Background(), server.Client(), server.URL, "wrong"); err == nil {
+		t.Fatalf("want error for wrong token, but got nil")
+	}
+}
+
+func Test_newServerStatusClient_SelfSignedCertificate(t *testing.T) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Without a certificate from the CA, the token would be sent to
+	// whoever holds the exit server's IP
+	tunnel := &inletsv1alpha1.Tunnel{}
+	if client := newServerStatusClient(tunnel, nil); client != nil {
+		t.Fatalf("want no client without managed TLS")
+	}
+	if client := newServerStatusClient(tunnel, ca); client != nil {
+		t.Fatalf("want no client for an exit server without a certificate from the CA")
+	}
+}
+
+func Test_newServerStatusClient_ManagedTLS(t *testing.T) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	server := newStandInServer("token", serverStatus{})
+	defer server.Close()
+
+	// The stand-in's certificate is not from the operator's CA
+	tunnel := &inletsv1alpha1.Tunnel{
+		Status: inletsv1alpha1.TunnelStatus{
+			TLS: &inletsv1alpha1.TLSStatus{ServerName: "nginx-tunnel.default.tunnels.operator.inlets.dev"},
+		},
+	}
+	if _, err := getServerStatus(context.Background(), newServerStatusClient(tunnel, ca), server.URL, "token"); err == nil {
+		t.Fatalf("want error for certificate not issued by the CA, but got nil")
+	}
+}
+
+func Test_makeConnectionStatus_Clients(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	got := makeConnectionStatus(&serverStatus{
+		Clients: []serverClient{
+			{ID: "two", ConnectedAt: first.Add(time.Hour), Ports: []int32{443, 80}},
+			{ID: "one", ConnectedAt: first, Ports: []int32{80}},
+		},
+	}, nil, nil, now)
+
+	if got.Clients != 2 {
+		t.Errorf("clients, want %d, but got %d", 2, got.Clients)
+	}
+	if got.ConnectedAt == nil || !got.ConnectedAt.Time.Equal(first) {
+		t.Errorf("connectedAt, want %s, but got %v", first, got.ConnectedAt)
+	}
+	if got.LastSeen == nil || !got.LastSeen.Time.Equal(now) {
+		t.Errorf("lastSeen, want %s, but got %v", now, got.LastSeen)
+	}
+	if !reflect.DeepEqual(got.Ports, []int32{80, 443}) {
+		t.Errorf("ports, want %v, but got %v", []int32{80, 443}, got.Ports)
+	}
+	if len(got.Error) > 0 {
+		t.Errorf("error, want empty, but got %s", got.Error)
+	}
+}
+
+func Test_makeConnectionStatus_NoClientsKeepsLastSeen(t *testing.T) {
+	lastSeen := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	previous := &inletsv1alpha1.ConnectionStatus{Clients: 1, LastSeen: &lastSeen}
+
+	got := makeConnectionStatus(&serverStatus{}, nil, previous, time.Now())
+
+	if got.Clients != 0 {
+		t.Errorf("clients, want %d, but got %d", 0, got.Clients)
+	}
+	if got.LastSeen == nil || !got.LastSeen.Equal(&lastSeen) {
+		t.Errorf("lastSeen, want %s, but got %v", lastSeen, got.LastSeen)
+	}
+}
+
+func Test_makeConnectionStatus_Error(t *testing.T) {
+	got := makeConnectionStatus(nil, fmt.Errorf("connection refused"), nil, time.Now())
+
+	if got.Error != "connection refused" {
+		t.Errorf("want %s, but got %s", "connection refused", got.Error)
+	}
+	if got.LastChecked == nil {
+		t.Errorf("want lastChecked to be set, but got nil")
+	}
+}
+
+func Test_setConnectedCondition(t *testing.T) {
+	cases := []struct {
+		name string
+		conn inletsv1alpha1.ConnectionStatus
+		want metav1.ConditionStatus
+	}{
+		{name: "connected", conn: inletsv1alpha1.ConnectionStatus{Clients: 1}, want: metav1.ConditionTrue},
+		{name: "no clients", conn: inletsv1alpha1.ConnectionStatus{}, want: metav1.ConditionFalse},
+		{name: "error", conn: inletsv1alpha1.ConnectionStatus{Error: "timeout"}, want: metav1.ConditionUnknown},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tunnel := &inletsv1alpha1.Tunnel{}
+			tunnel.Status.Connection = &tc.conn
+
+			setConnectedCondition(tunnel)
+
+			got := meta.FindStatusCondition(tunnel.Status.Conditions, inletsv1alpha1.TunnelConnected)
+			if got == nil {
+				t.Fatalf("want %s condition, but got none", inletsv1alpha1.TunnelConnected)
+			}
+			if got.Status != tc.want {
+				t.Fatalf("want %s, but got %s", tc.want, got.Status)
+			}
+		})
+	}
+}
+
+func Test_onlyConnectionChanged(t *testing.T) {
+	old := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", ResourceVersion: "1"},
+		Status:     inletsv1alpha1.TunnelStatus{HostStatus: "active"},
+	}
+
+	connected := old.DeepCopy()
+	connected.ResourceVersion = "2"
+	connected.Status.Connection = &inletsv1alpha1.ConnectionStatus{Clients: 1}
+	setConnectedCondition(connected)
+	if !onlyConnectionChanged(old, connected) {
+		t.Errorf("want true for a connection update, but got false")
+	}
+
+	resync := old.DeepCopy()
+	if onlyConnectionChanged(old, resync) {
+		t.Errorf("want false for a resync, but got true")
+	}
+
+	both := connected.DeepCopy()
+	both.Status.HostIP = "192.0.2.10"
+	if onlyConnectionChanged(old, both) {
+		t.Errorf("want false when other fields changed, but got true")
+	}
+}
+
+func Test_connectionStatusChanged(t *testing.T) {
+	checked := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	soon := metav1.NewTime(checked.Add(time.Minute))
+	later := metav1.NewTime(checked.Add(connectionStatusMaxAge))
+
+	previous := &inletsv1alpha1.ConnectionStatus{Clients: 1, LastChecked: &checked, LastSeen: &checked}
+
+	cases := []struct {
+		name string
+		next *inletsv1alpha1.ConnectionStatus
+		want bool
+	}{
+		{"only timestamps", &inletsv1alpha1.ConnectionStatus{Clients: 1, LastChecked: &soon, LastSeen: &soon}, false},
+		{"clients", &inletsv1alpha1.ConnectionStatus{Clients: 2, LastChecked: &soon, LastSeen: &soon}, true},
+		{"error", &inletsv1alpha1.ConnectionStatus{Error: "connection refused", LastChecked: &soon, LastSeen: &checked}, true},
+		{"max age", &inletsv1alpha1.ConnectionStatus{Clients: 1, LastChecked: &later, LastSeen: &later}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := connectionStatusChanged(previous, c.next); got != c.want {
+				t.Errorf("want %t, but got %t", c.want, got)
+			}
+		})
+	}
+
+	if !connectionStatusChanged(nil, cases[0].next) {
+		t.Errorf("want a first status to be written, but it was not")
+	}
+}
+
+func Test_syncConnectionStatus_UnverifiedRecordedOnce(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Status.HostIP = "192.0.2.1"
+
+	client := fake.NewSimpleClientset(tunnel)
+	c := &Controller{operatorclientset: client}
+
+	if err := c.syncConnectionStatus(context.Background(), tunnel); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(client.Actions()) != 1 {
+		t.Fatalf("want 1 status update, but got %d actions", len(client.Actions()))
+	}
+
+	updated, err := client.OperatorV1alpha1().Tunnels("default").Get(context.Background(), "nginx-tunnel", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	client.ClearActions()
+	if err := c.syncConnectionStatus(context.Background(), updated); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("want no further updates, but got %d actions", len(client.Actions()))
+	}
+}
@@ -7,10 +7,11 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
@@ -22,6 +23,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -30,12 +32,10 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
-	appslisters "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
 
 	provision "github.com/inlets/cloud-provision/provision"
 
@@ -49,13 +49,17 @@ import (
 const controllerAgentName = "inlets-operator"
 const inletsPROControlPort = 8123
 const inletsPortsAnnotation = "inlets.dev/ports"
+
+// inletsHostAnnotation records the exit server's IP on the client
+// Deployment, which is updated when the exit server is replaced
+const inletsHostAnnotation = "inlets.dev/host"
 const licenseSecretName = "inlets-license"
 
 const (
 	// SuccessSynced is used as part of the Event 'reason' when a Tunnel is synced
 	SuccessSynced = "Synced"
 	// ErrResourceExists is used as part of the Event 'reason' when a Tunnel fails
-	// to sync due to a Deployment of the same name already existing.
+	// to sync due to a Deployment or Secret of the same name already existing.
 	ErrResourceExists = "ErrResourceExists"
 
 	// MessageResourceExists is the message used for Events when a resource
@@ -64,6 +68,14 @@ const (
 	// MessageResourceSynced is the message used for an Event fired when a Tunnel
 	// is synced successfully
 	MessageResourceSynced = "Tunnel synced successfully"
+
+	// ErrInvalidPlacement is used as part of the Event 'reason' when the
+	// regions or replicas requested for a Service cannot be used
+	ErrInvalidPlacement = "ErrInvalidPlacement"
+
+	// ErrInvalidTunnel is used as part of the Event 'reason' when the
+	// Tunnel for a Service is rejected by the admission webhook
+	ErrInvalidTunnel = "ErrInvalidTunnel"
 )
 
 // Controller is the controller implementation for Tunnel resources
@@ -73,12 +85,48 @@ type Controller struct {
 	// sampleclientset is a clientset for our own API group
 	operatorclientset clientset.Interface
 
-	deploymentsLister appslisters.DeploymentLister
 	deploymentsSynced cache.InformerSynced
 	tunnelsLister     listers.TunnelLister
 	tunnelsSynced     cache.InformerSynced
 	serviceLister     corelisters.ServiceLister
-	infraConfig       *InfraConfig
+	grantsLister      listers.TunnelGrantLister
+
+	// infraConfig is replaced when the config file is reloaded, so should
+	// be read through config()
+	infraConfig *configStore
+
+	// namespaceSelector is set when only namespaces with matching
+	// labels are to be managed.
+	namespaceSelector labels.Selector
+	namespaceLister   corelisters.NamespaceLister
+	namespacesSynced  cache.InformerSynced
+
+	// ca issues certificates for exit servers when -managed-tls is set,
+	// otherwise it is nil and they use a self-signed certificate.
+	ca *certAuthority
+
+	// provisioningPool creates exit servers, so that the calls to the
+	// provider do not hold up the workers. When nil they are created by
+	// the workers.
+	provisioningPool *provisioningPool
+
+	// providerGuards rate limit the calls to each provider, and pause them
+	// when it is failing, it is nil when calls are not limited.
+	providerGuards *providerGuards
+
+	// provisioners holds a client for each provider and region, so that
+	// one is not created for every call. When nil, a new one is created
+	// each time.
+	provisioners *provisionerCache
+
+	// dryRun is set by -dry-run, exit servers are logged and given a fake
+	// host instead of being created, and clients are scaled to zero.
+	dryRun bool
+
+	// clusterName is written to each exit server as a tag, so that gc
+	// only deletes the cluster's own hosts. When empty, hosts are not
+	// tagged.
+	clusterName string
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -91,80 +139,172 @@ type Controller struct {
 	recorder record.EventRecorder
 }
 
-// NewController returns a new controller
+// NewController returns a new controller, watching each of the given
+// namespaces. When namespaceInformer is set, only namespaces matching
+// the InfraConfig's NamespaceSelector are managed.
 func NewController(
 	kubeclientset kubernetes.Interface,
 	operatorClient clientset.Interface,
-	deploymentInformer appsinformers.DeploymentInformer,
-	tunnelInformer informers.TunnelInformer,
-	serviceInformer coreinformers.ServiceInformer,
+	namespaced []namespaceInformers,
+	namespaceInformer coreinformers.NamespaceInformer,
 	infra *InfraConfig,
 ) *Controller {
 
 	utilruntime.Must(inletsscheme.AddToScheme(scheme.Scheme))
-	klog.V(4).Info("Creating event broadcaster")
+	slog.Debug("Creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(klog.V(4).Infof)
+	eventBroadcaster.StartLogging(func(format string, args ...interface{}) {
+		slog.Debug(fmt.Sprintf(format, args...))
+	})
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
+	tunnelsLister := multiNamespaceTunnelLister{}
+	serviceLister := multiNamespaceServiceLister{}
+	grantsLister := multiNamespaceTunnelGrantLister{}
+	deploymentsSynced := []cache.InformerSynced{}
+	tunnelsSynced := []cache.InformerSynced{}
+	for _, n := range namespaced {
+		tunnelsLister[n.Namespace] = n.Tunnels.Lister()
+		serviceLister[n.Namespace] = n.Services.Lister()
+		grantsLister[n.Namespace] = n.Grants.Lister()
+		deploymentsSynced = append(deploymentsSynced, n.Deployments.Informer().HasSynced)
+		tunnelsSynced = append(tunnelsSynced, n.Tunnels.Informer().HasSynced, n.Services.Informer().HasSynced, n.Grants.Informer().HasSynced)
+	}
+
 	controller := &Controller{
 		kubeclientset:     kubeclientset,
 		operatorclientset: operatorClient,
-		deploymentsLister: deploymentInformer.Lister(),
-		deploymentsSynced: deploymentInformer.Informer().HasSynced,
-		tunnelsLister:     tunnelInformer.Lister(),
-		tunnelsSynced:     tunnelInformer.Informer().HasSynced,
-		serviceLister:     serviceInformer.Lister(),
+		deploymentsSynced: allSynced(deploymentsSynced),
+		tunnelsLister:     tunnelsLister,
+		tunnelsSynced:     allSynced(tunnelsSynced),
+		serviceLister:     serviceLister,
+		grantsLister:      grantsLister,
 		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Tunnels"),
 		recorder:          recorder,
-		infraConfig:       infra,
+		infraConfig:       newConfigStore(infra),
+		provisioners:      newProvisionerCache(),
+	}
+
+	if namespaceInformer != nil && len(infra.NamespaceSelector) > 0 {
+		selector, err := labels.Parse(infra.NamespaceSelector)
+		utilruntime.Must(err)
+
+		controller.namespaceSelector = selector
+		controller.namespaceLister = namespaceInformer.Lister()
+		controller.namespacesSynced = namespaceInformer.Informer().HasSynced
+
+		namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.enqueueNamespace,
+			UpdateFunc: func(old, new interface{}) {
+				oldNs := old.(*corev1.Namespace)
+				newNs := new.(*corev1.Namespace)
+				if cmp.Diff(oldNs.Labels, newNs.Labels) != "" {
+					controller.enqueueNamespace(new)
+				}
+			},
+		})
 	}
 
-	klog.Info("Setting up event handlers")
+	slog.Info("Setting up event handlers")
+	for _, n := range namespaced {
+		controller.addEventHandlers(n.Deployments, n.Tunnels, n.Services, n.Grants)
+	}
+
+	return controller
+}
+
+// addEventHandlers registers the event handlers for the informers of one
+// watched namespace.
+func (c *Controller) addEventHandlers(
+	deploymentInformer appsinformers.DeploymentInformer,
+	tunnelInformer informers.TunnelInformer,
+	serviceInformer coreinformers.ServiceInformer,
+	grantInformer informers.TunnelGrantInformer) {
 	// Set up an event handler for when Tunnel resources change
 	tunnelInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(new interface{}) {
-			controller.enqueueTunnel(new)
+			c.enqueueTunnel(new)
 		},
 		UpdateFunc: func(old, new interface{}) {
-			controller.enqueueTunnel(new)
+			oldTunnel, oldOk := old.(*inletsv1alpha1.Tunnel)
+			newTunnel, newOk := new.(*inletsv1alpha1.Tunnel)
+			if oldOk && newOk && onlyConnectionChanged(oldTunnel, newTunnel) {
+				// Written every interval by checkConnections
+				return
+			}
+			c.enqueueTunnel(new)
 		},
 		DeleteFunc: func(old interface{}) {
 			r, ok := checkCustomResourceType(old)
+			if !ok {
+				slog.Error("Failed to retrieve resource status")
+				return
+			}
 
-			klog.Infof("Deleting Tunnel: %s.%s, references: %v", r.Name, r.Namespace, r.OwnerReferences)
+			logger := c.tunnelLogger(&r)
+			logger.Info("Deleting Tunnel", "references", r.OwnerReferences)
 
-			if !ok {
-				log.Println("Failed to retrieve resource status")
+			if r.Status.PoolAllocation != nil {
+				logger.Info("Returning server to ExitServerPool",
+					"server", r.Status.PoolAllocation.Server, "pool", r.Status.PoolAllocation.Pool)
+				if err := releaseToPool(&r, c); err != nil {
+					logger.Error("Error returning server to ExitServerPool", "pool", r.Status.PoolAllocation.Pool, logKeyError, err)
+					c.recordEvent(&r, corev1.EventTypeWarning, ErrDeleting,
+						"Error returning server %s to ExitServerPool %s: %s", r.Status.PoolAllocation.Server, r.Status.PoolAllocation.Pool, err)
+					return
+				}
+				c.enqueuePoolTunnels(r.Namespace, r.Status.PoolAllocation.Pool)
+
+				// This will fail if the service was deleted first
+				c.updateService(&r, "")
 				return
 			}
 			if len(r.Status.HostID) == 0 {
-				log.Println("Status.HostID is empty")
+				logger.Info("No tunnel server to delete, hostID is empty")
 				return
 			}
-			provisioner, err := getProvisioner(controller)
+			if n := countHostTunnels(c, &r); n > 0 {
+				logger.Info("Keeping tunnel server, still used by other Tunnels", "tunnels", n)
+				c.recordEvent(&r, corev1.EventTypeNormal, HostKept,
+					"Keeping tunnel server %s, still used by %d other Tunnel(s)", r.Status.HostID, n)
+
+				// This will fail if the service was deleted first
+				c.updateService(&r, "")
+				return
+			}
+			region := getTunnelRegion(c, &r)
+			provisioner, err := getHostProvisioner(c, region, r.Status.HostID)
 			if err != nil {
-				klog.Infof("Error creating provisioner: %s", err.Error())
+				logger.Error("Error creating provisioner", logKeyError, err)
+				c.recordEvent(&r, corev1.EventTypeWarning, ErrDeleting, "Error deleting tunnel server %s: %s", r.Status.HostID, err)
 				return
 			}
 
+			if err := deleteFirewall(&r, c); err != nil {
+				logger.Error("Error deleting firewall", logKeyError, err)
+				c.recordEvent(&r, corev1.EventTypeWarning, ErrFirewall, "Error deleting firewall: %s", err)
+			}
+
 			if provisioner != nil {
-				klog.Infof("Deleting tunnel server for: %s.%s, HostID: %s, IP: %s",
-					r.Name, r.Namespace, r.Status.HostID, r.Status.HostIP)
+				logger.Info("Deleting tunnel server", "ip", r.Status.HostIP)
+				c.recordEvent(&r, corev1.EventTypeNormal, Deleting, "Deleting tunnel server %s (%s)", r.Status.HostID, r.Status.HostIP)
 
 				if err := provisioner.Delete(provision.HostDeleteRequest{
 					ID:     r.Status.HostID,
 					IP:     r.Status.HostIP,
-					Region: infra.Region,
-					Zone:   infra.Zone,
+					Region: region,
+					Zone:   c.config().Zone,
 				}); err != nil {
-					klog.Infof("Error deleting tunnel server %s", err)
+					logger.Error("Error deleting tunnel server", logKeyError, err)
+					c.recordEvent(&r, corev1.EventTypeWarning, ErrDeleting, "Error deleting tunnel server %s: %s", r.Status.HostID, err)
 					return
 				}
+				c.recordEvent(&r, corev1.EventTypeNormal, HostDeleted, "Deleted tunnel server %s", r.Status.HostID)
+				c.enqueueQuotaTunnels()
 
 				// This will fail if the service was deleted first
-				controller.updateService(&r, "")
+				c.updateService(&r, "")
 			}
 		},
 	})
@@ -176,7 +316,7 @@ func NewController(
 	// handling Deployment resources. More info on this pattern:
 	// https://github.com/kubernetes/community/blob/8cafef897a22026d42f5e5bb3f104febe7e29830/contributors/devel/controllers.md
 	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.handleObject,
+		AddFunc: c.handleObject,
 		UpdateFunc: func(old, new interface{}) {
 			newDepl := new.(*appsv1.Deployment)
 			oldDepl := old.(*appsv1.Deployment)
@@ -185,9 +325,9 @@ func NewController(
 				// Two different versions of the same Deployment will always have different RVs.
 				return
 			}
-			controller.handleObject(new)
+			c.handleObject(new)
 		},
-		DeleteFunc: controller.handleObject,
+		DeleteFunc: c.handleObject,
 	})
 
 	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -196,7 +336,7 @@ func NewController(
 				return
 			}
 
-			controller.enqueueService(new)
+			c.enqueueService(new)
 		},
 		UpdateFunc: func(old, new interface{}) {
 			if ok := checkServiceType(new); !ok {
@@ -206,12 +346,25 @@ func NewController(
 			newSvc := new.(*corev1.Service)
 			oldSvc := old.(*corev1.Service)
 			if !apiequality.Semantic.DeepEqual(oldSvc.Spec, newSvc.Spec) || cmp.Diff(oldSvc.Annotations, newSvc.Annotations) != "" {
-				controller.enqueueService(new)
+				c.enqueueService(new)
 			}
 		},
 	})
 
-	return controller
+	// Tunnels referencing a Service in another namespace are enqueued when
+	// a TunnelGrant there changes, so that access follows the grants.
+	grantInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueGrantTunnels,
+		UpdateFunc: func(old, new interface{}) {
+			newGrant := new.(*inletsv1alpha1.TunnelGrant)
+			oldGrant := old.(*inletsv1alpha1.TunnelGrant)
+			if newGrant.ResourceVersion == oldGrant.ResourceVersion {
+				return
+			}
+			c.enqueueGrantTunnels(new)
+		},
+		DeleteFunc: c.enqueueGrantTunnels,
+	})
 }
 
 func checkServiceType(obj interface{}) bool {
@@ -254,23 +407,32 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer c.workqueue.ShutDown()
 
 	// Start the informer factories to begin populating the informer caches
-	klog.Info("Starting Tunnel controller")
+	slog.Info("Starting Tunnel controller")
 
 	// Wait for the caches to be synced before starting workers
-	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.tunnelsSynced); !ok {
+	slog.Info("Waiting for informer caches to sync")
+	synced := []cache.InformerSynced{c.deploymentsSynced, c.tunnelsSynced}
+	if c.namespacesSynced != nil {
+		synced = append(synced, c.namespacesSynced)
+	}
+
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
-	klog.Info("Starting workers")
-	// Launch two workers to process Tunnel resources
+	slog.Info("Starting workers", "workers", threadiness)
+	if c.provisioningPool != nil {
+		c.provisioningPool.start(stopCh)
+	}
+
+	// Launch the workers to process Tunnel resources
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
-	klog.Info("Started workers")
+	slog.Info("Started workers")
 	<-stopCh
-	klog.Info("Shutting down workers")
+	slog.Info("Shutting down workers")
 
 	return nil
 }
@@ -326,7 +488,7 @@ func (c *Controller) processNextWorkItem() bool {
 		// Finally, if no error occurs we Forget this item so it does not
 		// get queued again until another change happens.
 		c.workqueue.Forget(obj)
-		klog.Infof("Successfully synced '%s'", key)
+		slog.Debug("Successfully synced", "key", key)
 		return nil
 	}(obj)
 
@@ -360,6 +522,10 @@ func (c *Controller) syncHandler(key string) error {
 			return fmt.Errorf("error creating tunnel: %s", err)
 		}
 
+		// Existing tunnels may need their client or firewall updated
+		// to match the Service's ports and source ranges
+		c.enqueueServiceTunnels(service)
+
 		return nil
 	}
 
@@ -373,69 +539,76 @@ func (c *Controller) syncHandler(key string) error {
 		return err
 	}
 
-	// The tunnel CR is invalid without a service reference
-	if tunnel.Spec.ServiceRef == nil {
-		return fmt.Errorf("tunnel %s.%s has no service reference", tunnel.Name, tunnel.Namespace)
+	if !c.namespaceAllowed(tunnel.Namespace) {
+		return nil
+	}
+
+	// The tunnel CR is invalid without a service reference or upstream
+	if getUpstream(tunnel) == "" {
+		return fmt.Errorf("tunnel %s.%s has no service reference or upstream", tunnel.Name, tunnel.Namespace)
+	}
+
+	// A Service in another namespace needs a TunnelGrant there, Tunnels
+	// waiting for one are enqueued again when the grants change
+	if granted, err := syncReferenceGrant(tunnel, c); err != nil {
+		return err
+	} else if !granted {
+		return nil
+	}
+
+	// Fake exit servers are never sent to the provider, so are replaced
+	// once -dry-run is turned off
+	if !c.dryRun && isDryRunHost(tunnel.Status.HostID) && len(tunnel.Status.HostStatus) > 0 {
+		return c.replaceHost([]*inletsv1alpha1.Tunnel{tunnel}, hostFake)
 	}
 
 	switch tunnel.Status.HostStatus {
 	case "":
 
+		// Servers from a pool already exist, and have their own token
+		if len(tunnel.Spec.ExitServerPool) > 0 {
+			return syncPoolAllocation(tunnel, c)
+		}
+
+		if tunnel.Spec.ShareExitServer && tunnel.Spec.ServiceRef != nil {
+			svc, err := c.lookupService(tunnel)
+			if err != nil {
+				return fmt.Errorf("error getting service: %s", err)
+			}
+			if canShareExitServer(tunnel, svc) {
+				joined, err := joinSharedHost(tunnel, svc, c)
+				if err != nil {
+					return err
+				}
+				if joined {
+					return nil
+				}
+			}
+		}
+
 		// No pre-created secret ref, and no generated secret name either
 		// so create one.
 		if getSecretName(tunnel) == "" {
 			_, err = createTunnelAuthTokenSecret(tunnel, c)
 			if err != nil {
-				klog.Infof("Error creating tunnel auth token: %s", err)
+				c.tunnelLogger(tunnel).Error("Error creating tunnel auth token", logKeyError, err)
+				c.recordEvent(tunnel, corev1.EventTypeWarning, ErrAuthToken, "Error creating auth token: %s", err)
 				return fmt.Errorf("error creating tunnel auth token: %s", err)
 			}
 
-			klog.Infof("Created tunnel auth token for %s.%s", tunnel.Name, tunnel.Namespace)
+			c.tunnelLogger(tunnel).Info("Created tunnel auth token")
+			c.recordEvent(tunnel, corev1.EventTypeNormal, AuthTokenCreated, "Created auth token for the tunnel server")
 			// The status will be updated by the call to create an auth secret, therefore
 			// return, and let that event handle the provisioning
 			return nil
 		}
 
-		provisioner, err := getProvisioner(c)
-		if err != nil {
-			return err
-		}
-
-		if tunnel.Spec.ServiceRef == nil {
-			return fmt.Errorf("tunnel %s.%s has no service reference", tunnel.Name, tunnel.Namespace)
-		}
-
-		if service == nil {
-			svc, err := c.serviceLister.Services(tunnel.Namespace).Get(tunnel.Spec.ServiceRef.Name)
-			if err != nil {
-				return fmt.Errorf("error getting service: %s", err)
-			}
-			service = svc
-		}
-
-		start := time.Now()
-		hostConfig, err := getHostConfig(c,
-			tunnel,
-			service,
-			c.infraConfig.Plan,
-			c.infraConfig.GetInletsRelease())
-		if err != nil {
-			return fmt.Errorf("error building host config: %s", err)
-		}
-
-		res, err := provisioner.Provision(hostConfig)
-		if err != nil {
-			return err
+		if c.provisioningPool != nil {
+			c.submitExitServer(tunnel)
+			return nil
 		}
 
-		klog.Infof("Provisioning for %s.%s took: %fs\n", tunnel.Name, tunnel.Namespace, time.Since(start).Seconds())
-
-		copy := tunnel.DeepCopy()
-
-		// Update Status
-		if _, err := c.updateTunnelProvisioningStatus(copy, "provisioning", res.ID, ""); err != nil {
-			return fmt.Errorf("tunnel %s.%s (%s) update error: %s", tunnel.Name, tunnel.Namespace, "provisioning", err)
-		}
+		return c.createExitServer(tunnel, service)
 
 	case "provisioning":
 
@@ -443,7 +616,7 @@ func (c *Controller) syncHandler(key string) error {
 		// attempt processing again later. THis could have been caused by a
 		// temporary network failure, or any other transient reason.
 		if err := syncProvisioningHostStatus(tunnel, c); err != nil {
-			return err
+			return c.setProviderUnavailable(tunnel, err)
 		}
 
 	case provision.ActiveStatus:
@@ -457,6 +630,26 @@ func (c *Controller) syncHandler(key string) error {
 		if err != nil {
 			return fmt.Errorf("error creating client deployment: %s", err)
 		}
+
+		// Publish the IP again in case the update failed when the exit
+		// server became active, i.e. on a conflict with the Tunnel of
+		// another region for the same Service. Nothing is written when
+		// the Service already has it.
+		if err := c.updateService(tunnel, tunnel.Status.HostIP); err != nil {
+			return fmt.Errorf("error updating service: %s", err)
+		}
+
+		// A Tunnel to an upstream has no Service for source ranges
+		svc, err := c.lookupService(tunnel)
+		if err != nil {
+			return fmt.Errorf("error getting service: %s", err)
+		}
+
+		if tunnel.Status.PoolAllocation == nil {
+			if err := syncFirewall(tunnel, svc, c); err != nil {
+				return err
+			}
+		}
 	}
 
 	c.recorder.Event(tunnel, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
@@ -552,7 +745,7 @@ func createTunnelAuthTokenSecret(tunnel *inletsv1alpha1.Tunnel, c *Controller) (
 			Create(context.Background(), authSecret, metav1.CreateOptions{})
 
 		if err != nil && !errors.IsAlreadyExists(err) {
-			klog.Infof("Error creating secret: %s", err.Error())
+			c.tunnelLogger(tunnel).Error("Error creating secret", logKeyError, err)
 			return tunnel, fmt.Errorf("unable to create secret: %s", err.Error())
 		}
 
@@ -576,7 +769,32 @@ func createTunnelAuthTokenSecret(tunnel *inletsv1alpha1.Tunnel, c *Controller) (
 }
 
 func createTunnelResource(service *corev1.Service, c *Controller) error {
-	name := service.Name + "-tunnel"
+	if !manageService(*c, *service) {
+		return pruneServiceTunnels(c, service, nil)
+	}
+
+	placements, err := getTunnelPlacements(service)
+	if err != nil {
+		c.recorder.Event(service, corev1.EventTypeWarning, ErrInvalidPlacement, err.Error())
+		return nil
+	}
+	if provider := c.config().Provider; providerIgnoresRegion(provider) && len(placements[0].Region) > 0 {
+		c.recorder.Eventf(service, corev1.EventTypeWarning, ErrInvalidPlacement,
+			"%s is not supported by provider %s, exit servers are created in the operator's zone", regionsAnnotation, provider)
+		return nil
+	}
+
+	for _, placement := range placements {
+		if err := createTunnelPlacement(service, placement, c); err != nil {
+			return err
+		}
+	}
+
+	return pruneServiceTunnels(c, service, placements)
+}
+
+func createTunnelPlacement(service *corev1.Service, placement tunnelPlacement, c *Controller) error {
+	name := placement.Name
 	namespace := service.Namespace
 
 	tunnels := c.operatorclientset.OperatorV1alpha1().
@@ -584,62 +802,54 @@ func createTunnelResource(service *corev1.Service, c *Controller) error {
 
 	ops := metav1.GetOptions{}
 
-	found, err := tunnels.Get(context.Background(), name, ops)
+	_, err := tunnels.Get(context.Background(), name, ops)
+	if err == nil || !errors.IsNotFound(err) {
+		return nil
+	}
 
 	// Create Tunnel CR
-	if errors.IsNotFound(err) {
-
-		if !manageService(*c, *service) {
-			return nil
-		}
+	slog.Info("Creating Tunnel", logKeyTunnel, name, logKeyNamespace, namespace, logKeyService, service.Name)
 
-		klog.Infof("Creating Tunnel: %s.%s\n", name, namespace)
-
-		tunnel := &inletsv1alpha1.Tunnel{
-			Spec: inletsv1alpha1.TunnelSpec{
-				ServiceRef: &inletsv1alpha1.ResourceRef{
-					Name:      service.Name,
-					Namespace: service.Namespace,
-				},
-				UpdateServiceIP: true,
+	tunnel := &inletsv1alpha1.Tunnel{
+		Spec: inletsv1alpha1.TunnelSpec{
+			ServiceRef: &inletsv1alpha1.ResourceRef{
+				Name:      service.Name,
+				Namespace: service.Namespace,
 			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      name,
-				Namespace: service.ObjectMeta.Namespace,
-				OwnerReferences: []metav1.OwnerReference{
-					*metav1.NewControllerRef(service, schema.GroupVersionKind{
-						Group:   "",
-						Version: "v1",
-						Kind:    "Service",
-					}),
-				},
+			UpdateServiceIP: true,
+			Region:          placement.Region,
+			ExitServerPool:  service.Annotations[exitServerPoolAnnotation],
+			ShareExitServer: wantsSharedExitServer(service),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: service.ObjectMeta.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(service, schema.GroupVersionKind{
+					Group:   "",
+					Version: "v1",
+					Kind:    "Service",
+				}),
 			},
-		}
-
-		ops := metav1.CreateOptions{}
-		created, err := tunnels.Create(context.Background(), tunnel, ops)
-		if err != nil {
-			klog.Infof("Error creating Tunnel: %s.%s", err.Error(), tunnel.Namespace)
-		}
-
-		copy := created.DeepCopy()
-		copy.Status.Generated = true
-		if _, err := tunnels.UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
-			klog.Infof("Error updating Tunnel status: %s.%s", err.Error(), copy.Namespace)
-		}
+		},
+	}
 
+	created, err := tunnels.Create(context.Background(), tunnel, metav1.CreateOptions{})
+	if errors.IsInvalid(err) {
+		// Rejected by the admission webhook, i.e. for a UDP port, the
+		// Tunnel is created again when the Service is changed
+		c.recorder.Event(service, corev1.EventTypeWarning, ErrInvalidTunnel, err.Error())
 		return nil
-	} else {
-		// klog.Infof("Event for existing Tunnel: %s.%s\n", found.Name, found.Namespace)
-		// c.enqueueTunnel(found)
-
-		if !manageService(*c, *service) {
-			klog.Infof("Removing Tunnel: %s.%s, no longer managed by controller", found.Name, found.Namespace)
+	}
+	if err != nil {
+		slog.Error("Error creating Tunnel", logKeyTunnel, name, logKeyNamespace, namespace, logKeyService, service.Name, logKeyError, err)
+		return fmt.Errorf("error creating tunnel %s.%s: %s", name, namespace, err)
+	}
 
-			if err := tunnels.Delete(context.Background(), found.Name, metav1.DeleteOptions{}); err != nil {
-				klog.Infof("Error deleting Tunnel: %s", err.Error())
-			}
-		}
+	copy := created.DeepCopy()
+	copy.Status.Generated = true
+	if _, err := tunnels.UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
+		c.tunnelLogger(copy).Error("Error updating Tunnel status", logKeyError, err)
 	}
 
 	return nil
@@ -650,30 +860,39 @@ func createClientDeployment(tunnel *inletsv1alpha1.Tunnel, c *Controller) error
 		return updateClientDeploymentRef(tunnel, c)
 	}
 
-	get := metav1.GetOptions{}
-	service, err := c.kubeclientset.CoreV1().
-		Services(tunnel.Namespace).
-		Get(context.Background(), tunnel.Spec.ServiceRef.Name, get)
+	service, err := getTunnelService(tunnel, c)
 	if err != nil {
 		return err
 	}
 
-	licenseKey, _ := c.infraConfig.ProConfig.GetLicenseKey()
+	licenseKey, _ := c.config().ProConfig.GetLicenseKey()
 
-	ports := getPortsString(service)
+	ports := getPortsString(tunnel, service)
 
 	client := makeClientDeployment(tunnel,
-		c.infraConfig.GetInletsClientImage(),
+		c.config().GetInletsClientImage(),
 		ports,
 		licenseKey,
-		c.infraConfig.MaxClientMemory)
+		c.config().MaxClientMemory)
+	if c.isDryRunTunnel(tunnel) {
+		scaleToZero(client)
+	}
 
 	deployment, err := c.kubeclientset.AppsV1().
 		Deployments(tunnel.Namespace).
 		Create(context.Background(), client, metav1.CreateOptions{})
 
-	if err != nil {
-		klog.Infof("Failed creating deployment: %s.%s, error: %s", tunnel.Name, tunnel.Namespace, err)
+	if errors.IsAlreadyExists(err) {
+		// Left behind by an earlier sync which failed to record it
+		deployment, err = client, nil
+		deployment.Namespace = tunnel.Namespace
+	} else if err != nil {
+		c.tunnelLogger(tunnel).Error("Failed creating client deployment", logKeyError, err)
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ErrClient, "Error creating tunnel client Deployment %s: %s", client.Name, err)
+		return fmt.Errorf("error creating client deployment: %s", err)
+	} else {
+		c.recordEvent(tunnel, corev1.EventTypeNormal, ClientCreated,
+			"Created tunnel client Deployment %s for ports %s", deployment.Name, ports)
 	}
 
 	tunnel.Status.ClientDeploymentRef = &inletsv1alpha1.ResourceRef{
@@ -686,13 +905,12 @@ func createClientDeployment(tunnel *inletsv1alpha1.Tunnel, c *Controller) error
 	if _, err := c.operatorclientset.OperatorV1alpha1().
 		Tunnels(tunnel.Namespace).
 		UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
-		klog.Infof("Failed updating tunnel: %s.%s, error: %s",
-			tunnel.Name, tunnel.Namespace, err)
+		c.tunnelLogger(tunnel).Error("Failed updating Tunnel status", logKeyError, err)
 
 		return fmt.Errorf("tunnel update error %s", err)
 	}
 
-	klog.Infof("Created tunnel client deployment: %s.%s", deployment.ObjectMeta.Name, deployment.ObjectMeta.Namespace)
+	c.tunnelLogger(tunnel).Info("Created tunnel client deployment", "deployment", deployment.ObjectMeta.Name)
 
 	return nil
 
@@ -711,58 +929,88 @@ func updateClientDeploymentRef(tunnel *inletsv1alpha1.Tunnel, c *Controller) err
 		return err
 	}
 
-	service, err := c.kubeclientset.CoreV1().
-		Services(tunnel.Namespace).
-		Get(context.Background(), tunnel.Spec.ServiceRef.Name, get)
+	service, err := getTunnelService(tunnel, c)
 	if err != nil {
 		return err
 	}
 
-	if deployment.ObjectMeta.Annotations != nil &&
-		deployment.ObjectMeta.Annotations[inletsPortsAnnotation] != getPortsString(service) {
+	// The image and memory limit change when the config is reloaded
+	if deployment.ObjectMeta.Annotations[inletsPortsAnnotation] != getPortsString(tunnel, service) ||
+		deployment.ObjectMeta.Annotations[inletsHostAnnotation] != tunnel.Status.HostIP ||
+		clientConfigChanged(deployment, c.config().GetInletsClientImage(), c.config().MaxClientMemory) {
 
-		licenseKey, _ := c.infraConfig.ProConfig.GetLicenseKey()
+		licenseKey, _ := c.config().ProConfig.GetLicenseKey()
 
-		ports := getPortsString(service)
+		ports := getPortsString(tunnel, service)
 		clientDeployment := makeClientDeployment(tunnel,
-			c.infraConfig.GetInletsClientImage(),
+			c.config().GetInletsClientImage(),
 			ports,
 			licenseKey,
-			c.infraConfig.MaxClientMemory)
+			c.config().MaxClientMemory)
+		if c.isDryRunTunnel(tunnel) {
+			scaleToZero(clientDeployment)
+		}
 
 		if _, err = c.kubeclientset.AppsV1().
 			Deployments(tunnel.Namespace).
 			Update(context.Background(), clientDeployment, metav1.UpdateOptions{}); err != nil {
-			klog.Infof("Failed to update deployment %s.%s, error: %s",
-				tunnel.Name, tunnel.Namespace, err)
+			c.tunnelLogger(tunnel).Error("Failed to update client deployment", "deployment", name, logKeyError, err)
+			c.recordEvent(tunnel, corev1.EventTypeWarning, ErrClient, "Error updating tunnel client Deployment %s: %s", name, err)
+		} else {
+			c.recordEvent(tunnel, corev1.EventTypeNormal, ClientUpdated,
+				"Updated tunnel client Deployment %s for ports %s and tunnel server %s", name, ports, tunnel.Status.HostIP)
 		}
 	}
 
 	return nil
 }
 
+// clientConfigChanged returns whether the client Deployment was made with
+// a different image or memory limit to those configured.
+func clientConfigChanged(deployment *appsv1.Deployment, image, maxMemory string) bool {
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return true
+	}
+	if containers[0].Image != image {
+		return true
+	}
+
+	want, err := resource.ParseQuantity(maxMemory)
+	if err != nil {
+		return false
+	}
+	got, ok := containers[0].Resources.Limits[corev1.ResourceMemory]
+	return !ok || got.Cmp(want) != 0
+}
+
 func getHostConfig(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1.Service, planOverride, inletsVersion string) (provision.BasicHost, error) {
 
 	tokenValue, err := getSecretValue(c, tunnel)
 	if err != nil {
-		klog.Infof("Error getting secret value: %s", err.Error())
+		c.tunnelLogger(tunnel).Error("Error getting secret value", logKeyError, err)
 		return provision.BasicHost{}, err
 	}
 
-	userData := provision.MakeExitServerUserdata(tokenValue, inletsVersion)
+	userData, err := makeUserData(c, tunnel, service, tokenValue, inletsVersion)
+	if err != nil {
+		return provision.BasicHost{}, err
+	}
 
 	var host provision.BasicHost
 
+	region := getTunnelRegion(c, tunnel)
+
 	inletsPort := inletsPROControlPort
 
-	switch c.infraConfig.Provider {
+	switch c.config().Provider {
 
 	case "digitalocean":
 		host = provision.BasicHost{
 			Name:       tunnel.Name,
 			OS:         "ubuntu-22-04-x64",
 			Plan:       "s-1vcpu-1gb",
-			Region:     c.infraConfig.Region,
+			Region:     region,
 			UserData:   userData,
 			Additional: map[string]string{},
 		}
@@ -772,7 +1020,7 @@ func getHostConfig(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1
 			Name:       tunnel.Name,
 			OS:         "ubuntu-focal",
 			Plan:       "DEV1-S",
-			Region:     c.infraConfig.Region,
+			Region:     region,
 			UserData:   userData,
 			Additional: map[string]string{},
 		}
@@ -784,11 +1032,11 @@ func getHostConfig(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1
 			Name:     tunnel.Name,
 			OS:       "projects/ubuntu-os-cloud/global/images/ubuntu-minimal-2204-jammy-v20240606",
 			Plan:     "f1-micro",
-			Region:   c.infraConfig.Region,
+			Region:   region,
 			UserData: userData,
 			Additional: map[string]string{
-				"projectid":     c.infraConfig.ProjectID,
-				"zone":          c.infraConfig.Zone,
+				"projectid":     c.config().ProjectID,
+				"zone":          c.config().Zone,
 				"firewall-name": firewallRuleName,
 				"firewall-port": strconv.Itoa(inletsPort),
 			},
@@ -796,19 +1044,19 @@ func getHostConfig(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1
 
 	case "ec2":
 
-		ports := getPortsString(service)
+		ports := getPortsString(tunnel, service)
 
 		var additional = map[string]string{
 			"inlets-port": strconv.Itoa(inletsPort),
 			"ports":       ports,
 		}
 
-		if len(c.infraConfig.VpcID) > 0 {
-			additional["vpc-id"] = c.infraConfig.VpcID
+		if len(c.config().VpcID) > 0 {
+			additional["vpc-id"] = c.config().VpcID
 		}
 
-		if len(c.infraConfig.SubnetID) > 0 {
-			additional["subnet-id"] = c.infraConfig.SubnetID
+		if len(c.config().SubnetID) > 0 {
+			additional["subnet-id"] = c.config().SubnetID
 		}
 
 		host = provision.BasicHost{
@@ -824,7 +1072,7 @@ func getHostConfig(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1
 			Name:       tunnel.Name,
 			OS:         "linode/ubuntu22.04", // https://api.linode.com/v4/images
 			Plan:       "g6-nanode-1",        // https://api.linode.com/v4/linode/types
-			Region:     c.infraConfig.Region,
+			Region:     region,
 			UserData:   userData,
 			Additional: map[string]string{},
 		}
@@ -839,7 +1087,7 @@ func getHostConfig(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1
 			Name:     tunnel.Name,
 			OS:       "Additional.imageOffer",
 			Plan:     "Standard_B1ls",
-			Region:   c.infraConfig.Region,
+			Region:   region,
 			UserData: userData,
 			Additional: map[string]string{
 				"inlets-port":    strconv.Itoa(inletsPort),
@@ -856,15 +1104,15 @@ func getHostConfig(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1
 			Name:       tunnel.Name,
 			OS:         "ubuntu-22.04", // https://docs.hetzner.cloud/#images-get-all-images
 			Plan:       "cx22",         // https://docs.hetzner.cloud/#server-types-get-a-server-type
-			Region:     c.infraConfig.Region,
+			Region:     region,
 			UserData:   userData,
 			Additional: map[string]string{},
 		}
 	}
 
 	// override default plan/size when provided
-	if len(c.infraConfig.Plan) > 0 {
-		host.Plan = c.infraConfig.Plan
+	if len(c.config().Plan) > 0 {
+		host.Plan = c.config().Plan
 	}
 	return host, nil
 }
@@ -890,41 +1138,188 @@ func getSecretValue(c *Controller, tunnel *inletsv1alpha1.Tunnel) (string, error
 
 }
 
-func getProvisioner(c *Controller) (provision.Provisioner, error) {
+// getProvisioner returns a provisioner to create exit servers with, the
+// region is only used by providers which bind a client to a region.
+func getProvisioner(c *Controller, region string) (provision.Provisioner, error) {
+	if c.dryRun {
+		return &dryRunProvisioner{}, nil
+	}
+	return newProvisioner(c, region)
+}
+
+// getHostProvisioner returns a provisioner for an existing exit server,
+// fake hosts from -dry-run are only ever given to the dryRunProvisioner,
+// and real hosts to the provider's, whether or not -dry-run is set.
+func getHostProvisioner(c *Controller, region, hostID string) (provision.Provisioner, error) {
+	if isDryRunHost(hostID) {
+		return &dryRunProvisioner{}, nil
+	}
+	return newProvisioner(c, region)
+}
+
+// provisionerCache holds the provisioner for each provider and region.
+// The provider and its credentials cannot be changed by a reload, so the
+// clients never need to be replaced.
+type provisionerCache struct {
+	lock         sync.Mutex
+	provisioners map[string]provision.Provisioner
+}
+
+func newProvisionerCache() *provisionerCache {
+	return &provisionerCache{provisioners: map[string]provision.Provisioner{}}
+}
+
+// newProvisioner returns the provisioner for the configured provider,
+// from the Controller's cache when it has one for the region.
+func newProvisioner(c *Controller, region string) (provision.Provisioner, error) {
+	if c.provisioners == nil {
+		return createProvisioner(c, region)
+	}
+
+	key := c.config().Provider + "/" + region
+
+	c.provisioners.lock.Lock()
+	defer c.provisioners.lock.Unlock()
+
+	if provisioner, ok := c.provisioners.provisioners[key]; ok {
+		return provisioner, nil
+	}
+
+	provisioner, err := createProvisioner(c, region)
+	if err != nil {
+		return nil, err
+	}
+	c.provisioners.provisioners[key] = provisioner
+	return provisioner, nil
+}
+
+// createProvisioner creates a client for the configured provider.
+func createProvisioner(c *Controller, region string) (provision.Provisioner, error) {
 	var err error
 	var provisioner provision.Provisioner
 
-	switch c.infraConfig.Provider {
+	switch c.config().Provider {
 	case "digitalocean":
-		provisioner, err = provision.NewDigitalOceanProvisioner(c.infraConfig.GetAccessKey())
+		provisioner, err = provision.NewDigitalOceanProvisioner(c.config().GetAccessKey())
 	case "scaleway":
-		provisioner, err = provision.NewScalewayProvisioner(c.infraConfig.GetAccessKey(), c.infraConfig.GetSecretKey(), c.infraConfig.OrganizationID, c.infraConfig.Region)
+		provisioner, err = provision.NewScalewayProvisioner(c.config().GetAccessKey(), c.config().GetSecretKey(), c.config().OrganizationID, region)
 	case "gce":
-		provisioner, err = provision.NewGCEProvisioner(c.infraConfig.GetAccessKey())
+		provisioner, err = provision.NewGCEProvisioner(c.config().GetAccessKey())
 	case "ec2":
 		// No STS Token can be made available when running in-cluster as a service.
 		emptySTSToken := ""
-		provisioner, err = provision.NewEC2Provisioner(c.infraConfig.Region, c.infraConfig.GetAccessKey(), c.infraConfig.GetSecretKey(), emptySTSToken)
+		provisioner, err = provision.NewEC2Provisioner(region, c.config().GetAccessKey(), c.config().GetSecretKey(), emptySTSToken)
 	case "linode":
-		provisioner, err = provision.NewLinodeProvisioner(c.infraConfig.GetAccessKey())
+		provisioner, err = provision.NewLinodeProvisioner(c.config().GetAccessKey())
 	case "azure":
-		provisioner, err = provision.NewAzureProvisioner(c.infraConfig.SubscriptionID, c.infraConfig.GetAccessKey())
+		provisioner, err = provision.NewAzureProvisioner(c.config().SubscriptionID, c.config().GetAccessKey())
 	case "hetzner":
-		provisioner, err = provision.NewHetznerProvisioner(c.infraConfig.GetAccessKey())
+		provisioner, err = provision.NewHetznerProvisioner(c.config().GetAccessKey())
 	default:
-		return nil, fmt.Errorf("unsupported provider: %s", c.infraConfig.Provider)
+		return nil, fmt.Errorf("unsupported provider: %s", c.config().Provider)
+	}
+
+	if err == nil && c.providerGuards != nil {
+		provisioner = c.providerGuards.get(c.config().Provider).wrapProvisioner(provisioner)
 	}
 	return provisioner, err
 }
 
+// createExitServer creates an exit server for a Tunnel with a token, and
+// records it in the Tunnel's status. service is looked up when nil.
+func (c *Controller) createExitServer(tunnel *inletsv1alpha1.Tunnel, service *corev1.Service) error {
+	provisioner, err := getProvisioner(c, getTunnelRegion(c, tunnel))
+	if err != nil {
+		return err
+	}
+
+	if getUpstream(tunnel) == "" {
+		return fmt.Errorf("tunnel %s.%s has no service reference or upstream", tunnel.Name, tunnel.Namespace)
+	}
+
+	if service == nil {
+		svc, err := c.lookupService(tunnel)
+		if err != nil {
+			return fmt.Errorf("error getting service: %s", err)
+		}
+		service = svc
+	}
+
+	var tlsStatus *inletsv1alpha1.TLSStatus
+	if c.ca != nil {
+		if tlsStatus, err = createTunnelCASecret(tunnel, c); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	hostConfig, err := getHostConfig(c,
+		tunnel,
+		service,
+		c.config().Plan,
+		c.config().GetInletsRelease())
+	if err != nil {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ErrProvisioning, "Error building tunnel server config: %s", err)
+		return fmt.Errorf("error building host config: %s", err)
+	}
+
+	cost, ok := estimateCost(c.config(), c.config().Provider, hostConfig.Plan)
+	if !ok {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, UnknownPrice,
+			"No price for %s plan %s, add it to prices in the config to estimate its cost", c.config().Provider, hostConfig.Plan)
+	}
+
+	if err := enforceQuota(c, tunnel, cost); err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		token, _ := getSecretValue(c, tunnel)
+		logDryRunHost(c.tunnelLogger(tunnel), redactHost(hostConfig, token))
+		c.recordEvent(tunnel, corev1.EventTypeNormal, DryRun,
+			"Dry run: no tunnel server was created, see the operator's logs for its config")
+	}
+
+	c.recordEvent(tunnel, corev1.EventTypeNormal, Provisioning,
+		"Creating tunnel server on %s in %s, plan %s", c.config().Provider, getTunnelRegion(c, tunnel), hostConfig.Plan)
+
+	res, err := provisioner.Provision(hostConfig)
+	if err != nil {
+		if _, ok := err.(errProviderUnavailable); !ok {
+			c.recordEvent(tunnel, corev1.EventTypeWarning, ErrProvisioning, "Error creating tunnel server: %s", err)
+		}
+		return c.setProviderUnavailable(tunnel, err)
+	}
+
+	c.tunnelLogger(tunnel).Info("Provisioned tunnel server", "seconds", time.Since(start).Seconds())
+	c.tagHost(tunnel, res.ID)
+
+	copy := tunnel.DeepCopy()
+	copy.Status.Firewall = getHostFirewallStatus(c, tunnel, service)
+	copy.Status.TLS = tlsStatus
+	copy.Status.Cost = cost
+	clearQuotaExceeded(copy)
+
+	// Update Status
+	if _, err := c.updateTunnelProvisioningStatus(copy, "provisioning", res.ID, ""); err != nil {
+		return fmt.Errorf("tunnel %s.%s (%s) update error: %s", tunnel.Name, tunnel.Namespace, "provisioning", err)
+	}
+
+	return nil
+}
+
 func syncProvisioningHostStatus(tunnel *inletsv1alpha1.Tunnel, c *Controller) error {
-	provisioner, err := getProvisioner(c)
+	provisioner, err := getHostProvisioner(c, getTunnelRegion(c, tunnel), tunnel.Status.HostID)
 	if err != nil {
 		return err
 	}
 
 	host, err := provisioner.Status(tunnel.Status.HostID)
 	if err != nil {
+		if _, ok := err.(errProviderUnavailable); !ok {
+			c.recordEvent(tunnel, corev1.EventTypeWarning, ErrHostStatus,
+				"Error getting status of tunnel server %s: %s", tunnel.Status.HostID, err)
+		}
 		return err
 	}
 
@@ -936,9 +1331,10 @@ func syncProvisioningHostStatus(tunnel *inletsv1alpha1.Tunnel, c *Controller) er
 	if err != nil {
 		return err
 	}
+	c.recordEvent(tunnel, corev1.EventTypeNormal, HostActive, "Tunnel server %s is active at %s", host.ID, host.IP)
 
 	if err := c.updateService(tunnel, host.IP); err != nil {
-		klog.Infof("Failed updating service %s.%s, error: %s", tunnel.Spec.ServiceRef.Name, tunnel.Namespace, err)
+		c.tunnelLogger(tunnel).Error("Failed updating service", logKeyError, err)
 		return fmt.Errorf("tunnel update error %s", err)
 	}
 
@@ -953,6 +1349,16 @@ func makeClientDeployment(tunnel *inletsv1alpha1.Tunnel, clientImage string, por
 		name = tunnel.Status.ClientDeploymentRef.Name
 	}
 
+	// When the exit server has a certificate from the operator's CA, the
+	// client connects via the certificate's name and only trusts the CA.
+	host := tunnel.Status.HostIP
+	tls := tunnel.Status.TLS
+	if tls != nil && len(tls.ServerName) > 0 && tls.CASecretRef != nil {
+		host = tls.ServerName
+	} else {
+		tls = nil
+	}
+
 	container := corev1.Container{
 		Name:            "inlets-client",
 		Image:           clientImage,
@@ -961,9 +1367,9 @@ func makeClientDeployment(tunnel *inletsv1alpha1.Tunnel, clientImage string, por
 		Args: []string{
 			"tcp",
 			"client",
-			"--url=" + fmt.Sprintf("wss://%s:%d/connect", tunnel.Status.HostIP, inletsPROControlPort),
+			"--url=" + fmt.Sprintf("wss://%s:%d/connect", host, inletsPROControlPort),
 			"--token-file=/var/inlets/auth-token/token",
-			"--upstream=" + tunnel.Spec.ServiceRef.Name,
+			"--upstream=" + getUpstream(tunnel),
 			"--ports=" + ports,
 			"--license-file=/var/inlets/license/license",
 		},
@@ -991,13 +1397,25 @@ func makeClientDeployment(tunnel *inletsv1alpha1.Tunnel, clientImage string, por
 		},
 	}
 
+	if tls != nil {
+		container.Args = append(container.Args, "--tls-ca=/var/inlets/ca/"+caCertKey)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "ca-volume",
+			MountPath: "/var/inlets/ca",
+			ReadOnly:  true,
+		})
+	}
+
 	secretRef := getSecretName(tunnel)
 
 	deployment := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        name,
-			Namespace:   tunnel.Namespace,
-			Annotations: map[string]string{inletsPortsAnnotation: ports},
+			Name:      name,
+			Namespace: tunnel.Namespace,
+			Annotations: map[string]string{
+				inletsPortsAnnotation: ports,
+				inletsHostAnnotation:  tunnel.Status.HostIP,
+			},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(tunnel, schema.GroupVersionKind{
 					Group:   inletsv1alpha1.SchemeGroupVersion.Group,
@@ -1046,6 +1464,21 @@ func makeClientDeployment(tunnel *inletsv1alpha1.Tunnel, clientImage string, por
 		},
 	}
 
+	if tls != nil {
+		podSpec := &deployment.Spec.Template.Spec
+		podSpec.HostAliases = []corev1.HostAlias{
+			{IP: tunnel.Status.HostIP, Hostnames: []string{tls.ServerName}},
+		}
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "ca-volume",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: tls.CASecretRef.Name,
+				},
+			},
+		})
+	}
+
 	return &deployment
 }
 
@@ -1063,20 +1496,40 @@ func getSecretName(tunnel *inletsv1alpha1.Tunnel) string {
 // updateService updates the service with the IP address of the tunnel server
 func (c *Controller) updateService(tunnel *inletsv1alpha1.Tunnel, ip string) error {
 
-	if !ownsService(tunnel) {
+	if !c.mayUpdateService(tunnel, ip) {
 		return nil
 	}
 
-	get := metav1.GetOptions{}
-	res, err := c.kubeclientset.CoreV1().
-		Services(tunnel.Namespace).
-		Get(context.Background(), tunnel.Spec.ServiceRef.Name, get)
+	res, err := getTunnelService(tunnel, c)
 	if err != nil {
 		return err
 	}
+	if res == nil {
+		return nil
+	}
+
+	if err := c.updateServiceIPs(tunnel, res, ip); err != nil {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ErrIngress, "Error updating Service %s: %s", res.Name, err)
+		return err
+	}
 
-	// Update Spec.ExternalIPs
+	switch {
+	case ip != "" && !containsString(res.Spec.ExternalIPs, ip):
+		c.recordEvent(tunnel, corev1.EventTypeNormal, IngressPublished, "Published %s on Service %s", ip, res.Name)
+	case ip == "" && containsString(res.Spec.ExternalIPs, tunnel.Status.HostIP):
+		c.recordEvent(tunnel, corev1.EventTypeNormal, IngressRemoved, "Removed %s from Service %s", tunnel.Status.HostIP, res.Name)
+	}
+
+	return nil
+}
+
+// updateServiceIPs adds or removes ip in the Service's externalIPs, and
+// sets its status.loadBalancer.ingress to match.
+func (c *Controller) updateServiceIPs(tunnel *inletsv1alpha1.Tunnel, res *corev1.Service, ip string) error {
+	// Update Spec.ExternalIPs, each Tunnel for the Service adds or removes
+	// only its own IP, so the others continue to serve traffic.
 	copy := res.DeepCopy()
+	changed := false
 	if ip == "" {
 		ips := []string{}
 		for _, v := range copy.Spec.ExternalIPs {
@@ -1084,28 +1537,39 @@ func (c *Controller) updateService(tunnel *inletsv1alpha1.Tunnel, ip string) err
 				ips = append(ips, v)
 			}
 		}
+		changed = len(ips) != len(copy.Spec.ExternalIPs)
 		copy.Spec.ExternalIPs = ips
-	} else {
+	} else if !containsString(copy.Spec.ExternalIPs, ip) {
 		copy.Spec.ExternalIPs = append(copy.Spec.ExternalIPs, ip)
+		changed = true
 	}
 
-	res, err = c.kubeclientset.CoreV1().
-		Services(tunnel.Namespace).
-		Update(context.Background(), copy, metav1.UpdateOptions{})
-
-	if err != nil {
-		return err
+	// Nothing is written when the Service is already up to date, so that
+	// the IP can be published again on each sync
+	if changed {
+		updated, err := c.kubeclientset.CoreV1().
+			Services(res.Namespace).
+			Update(context.Background(), copy, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		res = updated
 	}
 
 	// Update Status.LoadBalancer.Ingress
-	copy = res.DeepCopy()
-	copy.Status.LoadBalancer.Ingress = make([]corev1.LoadBalancerIngress, len(copy.Spec.ExternalIPs))
-	for i, ip := range copy.Spec.ExternalIPs {
-		copy.Status.LoadBalancer.Ingress[i] = corev1.LoadBalancerIngress{IP: ip}
+	ingress := make([]corev1.LoadBalancerIngress, len(res.Spec.ExternalIPs))
+	for i, ip := range res.Spec.ExternalIPs {
+		ingress[i] = corev1.LoadBalancerIngress{IP: ip}
 	}
+	if equalIngress(ingress, res.Status.LoadBalancer.Ingress) {
+		return nil
+	}
+
+	copy = res.DeepCopy()
+	copy.Status.LoadBalancer.Ingress = ingress
 
-	if _, err = c.kubeclientset.CoreV1().
-		Services(tunnel.Namespace).
+	if _, err := c.kubeclientset.CoreV1().
+		Services(res.Namespace).
 		UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
 		return err
 	}
@@ -1113,6 +1577,19 @@ func (c *Controller) updateService(tunnel *inletsv1alpha1.Tunnel, ip string) err
 	return nil
 }
 
+// equalIngress compares the IPs of two ingress lists, in order.
+func equalIngress(a, b []corev1.LoadBalancerIngress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].IP != b[i].IP || a[i].Hostname != b[i].Hostname {
+			return false
+		}
+	}
+	return true
+}
+
 // ownsService returns true if the tunnel owns the service
 // in which case, the service's IP will also be updated.
 //
@@ -1128,18 +1605,43 @@ func ownsService(tunnel *inletsv1alpha1.Tunnel) bool {
 	return false
 }
 
-func (c *Controller) updateTunnelProvisioningStatus(tunnel *inletsv1alpha1.Tunnel, status, id, ip string) (*inletsv1alpha1.Tunnel, error) {
-	if tunnel.Spec.ServiceRef == nil {
-		return tunnel, fmt.Errorf("tunnel %s.%s has no service reference", tunnel.Name, tunnel.Namespace)
+// mayUpdateService returns true if the Tunnel's IP is written to its
+// Service. A Service cannot own a Tunnel in another namespace, so those
+// Tunnels opt in with spec.updateServiceIP instead, and need a TunnelGrant
+// to publish their IP. Removing the IP needs no grant, so that it is
+// cleaned up after the grant is deleted.
+func (c *Controller) mayUpdateService(tunnel *inletsv1alpha1.Tunnel, ip string) bool {
+	if ownsService(tunnel) {
+		return true
+	}
+	if !tunnel.Spec.UpdateServiceIP || getServiceNamespace(tunnel) == tunnel.Namespace {
+		return false
+	}
+	if ip == "" {
+		return true
+	}
+
+	granted, err := referenceGranted(c.grantsLister, tunnel)
+	if err != nil {
+		c.tunnelLogger(tunnel).Error("Error checking TunnelGrants", logKeyError, err)
+		return false
 	}
+	return granted
+}
 
-	klog.Infof("Status (%s.%s): %s, ID: %s, IP: %s\n", tunnel.Spec.ServiceRef.Name, tunnel.Namespace, status, id, ip)
+func (c *Controller) updateTunnelProvisioningStatus(tunnel *inletsv1alpha1.Tunnel, status, id, ip string) (*inletsv1alpha1.Tunnel, error) {
+	if getUpstream(tunnel) == "" {
+		return tunnel, fmt.Errorf("tunnel %s.%s has no service reference or upstream", tunnel.Name, tunnel.Namespace)
+	}
 
 	tunnelCopy := tunnel.DeepCopy()
 	tunnelCopy.Status.HostStatus = status
 	tunnelCopy.Status.HostID = id
 	tunnelCopy.Status.HostIP = ip
 
+	c.tunnelLogger(tunnelCopy).Info("Updating status", "ip", ip)
+	clearProviderUnavailable(tunnelCopy)
+
 	tunnel, err := c.operatorclientset.OperatorV1alpha1().
 		Tunnels(tunnel.Namespace).
 		UpdateStatus(context.Background(), tunnelCopy, metav1.UpdateOptions{})
@@ -1147,6 +1649,23 @@ func (c *Controller) updateTunnelProvisioningStatus(tunnel *inletsv1alpha1.Tunne
 	return tunnel, err
 }
 
+// enqueueServiceTunnels enqueues each Tunnel which references the Service.
+func (c *Controller) enqueueServiceTunnels(service *corev1.Service) {
+	// Tunnels in other namespaces may reference the Service too
+	tunnels, err := c.tunnelsLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, t := range tunnels {
+		if t.Spec.ServiceRef != nil && t.Spec.ServiceRef.Name == service.Name &&
+			getServiceNamespace(t) == service.Namespace {
+			c.enqueueTunnel(t)
+		}
+	}
+}
+
 // enqueueTunnel takes a Tunnel resource and converts it into a namespace/name
 // string which is then put onto the work queue. This method should *not* be
 // passed resources of any type other than Tunnel.
@@ -1179,10 +1698,10 @@ func (c *Controller) handleObject(obj interface{}) {
 			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
 			return
 		}
-		klog.V(4).Infof("Recovered deleted object '%s' from tombstone", object.GetName())
+		slog.Debug("Recovered deleted object from tombstone", "name", object.GetName())
 	}
 
-	klog.V(4).Infof("Processing object: %s", object.GetName())
+	slog.Debug("Processing object", "name", object.GetName())
 	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
 		// If this object is not owned by a Tunnel, we should not do anything more
 		// with it.
@@ -1192,7 +1711,7 @@ func (c *Controller) handleObject(obj interface{}) {
 
 		tunnel, err := c.tunnelsLister.Tunnels(object.GetNamespace()).Get(ownerRef.Name)
 		if err != nil {
-			klog.V(4).Infof("ignoring orphaned object '%s' of tunnel '%s'", object.GetSelfLink(), ownerRef.Name)
+			slog.Debug("Ignoring orphaned object", "name", object.GetName(), logKeyTunnel, ownerRef.Name, logKeyNamespace, object.GetNamespace())
 			return
 		}
 
@@ -1202,6 +1721,10 @@ func (c *Controller) handleObject(obj interface{}) {
 }
 
 func manageService(controller Controller, service corev1.Service) bool {
+	if !controller.namespaceAllowed(service.Namespace) {
+		return false
+	}
+
 	annotations := service.Annotations
 
 	// If the service has the annotation, use that value
@@ -1212,18 +1735,78 @@ func manageService(controller Controller, service corev1.Service) bool {
 	}
 
 	// Else only manage if AnnotationOnly is false
-	return controller.infraConfig.AnnotatedOnly == false
+	return controller.config().AnnotatedOnly == false
+}
+
+// getUpstream returns the host the Tunnel's client forwards to, its
+// Upstream when set, otherwise the name of its Service, qualified with
+// the namespace when the Service is in another namespace to the client.
+func getUpstream(tunnel *inletsv1alpha1.Tunnel) string {
+	if len(tunnel.Spec.Upstream) > 0 {
+		return tunnel.Spec.Upstream
+	}
+	if tunnel.Spec.ServiceRef != nil {
+		if namespace := getServiceNamespace(tunnel); namespace != tunnel.Namespace {
+			return tunnel.Spec.ServiceRef.Name + "." + namespace
+		}
+		return tunnel.Spec.ServiceRef.Name
+	}
+	return ""
 }
 
-func getPortsString(service *corev1.Service) string {
+// getTunnelService gets the Tunnel's Service from the API server, or nil
+// for a Tunnel to an upstream.
+func getTunnelService(tunnel *inletsv1alpha1.Tunnel, c *Controller) (*corev1.Service, error) {
+	if tunnel.Spec.ServiceRef == nil {
+		return nil, nil
+	}
+
+	return c.kubeclientset.CoreV1().
+		Services(getServiceNamespace(tunnel)).
+		Get(context.Background(), tunnel.Spec.ServiceRef.Name, metav1.GetOptions{})
+}
+
+// lookupService gets the Tunnel's Service from the cache, or nil for a
+// Tunnel to an upstream.
+func (c *Controller) lookupService(tunnel *inletsv1alpha1.Tunnel) (*corev1.Service, error) {
+	if tunnel.Spec.ServiceRef == nil {
+		return nil, nil
+	}
+
+	return c.serviceLister.Services(getServiceNamespace(tunnel)).Get(tunnel.Spec.ServiceRef.Name)
+}
+
+// getTunnelPorts returns the Tunnel's ports when it has them, otherwise
+// the Service's ports.
+func getTunnelPorts(tunnel *inletsv1alpha1.Tunnel, service *corev1.Service) []int32 {
+	if tunnel != nil && len(tunnel.Spec.Ports) > 0 {
+		return tunnel.Spec.Ports
+	}
 	if service == nil {
-		return ""
+		return nil
 	}
 
-	ports := ""
+	ports := []int32{}
 	for _, p := range service.Spec.Ports {
-		ports = ports + fmt.Sprintf("%d,", p.Port)
+		ports = append(ports, p.Port)
+	}
+	return ports
+}
+
+func getPortsString(tunnel *inletsv1alpha1.Tunnel, service *corev1.Service) string {
+	ports := ""
+	for _, p := range getTunnelPorts(tunnel, service) {
+		ports = ports + fmt.Sprintf("%d,", p)
 	}
 
 	return strings.TrimRight(ports, ",")
 }
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	inletsv1beta1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1beta1"
+)
+
+// convertTunnelPath is the path of the conversion webhook for Tunnels
+const convertTunnelPath = "/convert"
+
+// tunnelsCRDName is the name of the Tunnel CustomResourceDefinition
+const tunnelsCRDName = "tunnels.operator.inlets.dev"
+
+// licenseRefAnnotation keeps v1alpha1's spec.licenseRef, which has no
+// field in v1beta1, so that it survives a round trip through v1beta1.
+const licenseRefAnnotation = "operator.inlets.dev/v1alpha1-license-ref"
+
+// conversionReview mirrors apiextensions.k8s.io/v1 ConversionReview, to
+// avoid depending on k8s.io/apiextensions-apiserver for three structs.
+type conversionReview struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Request  *conversionRequest  `json:"request,omitempty"`
+	Response *conversionResponse `json:"response,omitempty"`
+}
+
+type conversionRequest struct {
+	UID               types.UID              `json:"uid"`
+	DesiredAPIVersion string                 `json:"desiredAPIVersion"`
+	Objects           []runtime.RawExtension `json:"objects"`
+}
+
+type conversionResponse struct {
+	UID              types.UID              `json:"uid"`
+	ConvertedObjects []runtime.RawExtension `json:"convertedObjects"`
+	Result           metav1.Status          `json:"result"`
+}
+
+// tunnelPhases maps v1alpha1's status.hostStatus to v1beta1's
+// status.phase, other values are copied as they are.
+var tunnelPhases = map[string]inletsv1beta1.TunnelPhase{
+	"":             inletsv1beta1.TunnelPhasePending,
+	"provisioning": inletsv1beta1.TunnelPhaseProvisioning,
+	"active":       inletsv1beta1.TunnelPhaseActive,
+}
+
+func toV1beta1Ref(ref *inletsv1alpha1.ResourceRef) *inletsv1beta1.ObjectReference {
+	if ref == nil {
+		return nil
+	}
+	return &inletsv1beta1.ObjectReference{Name: ref.Name, Namespace: ref.Namespace}
+}
+
+func toV1alpha1Ref(ref *inletsv1beta1.ObjectReference) *inletsv1alpha1.ResourceRef {
+	if ref == nil {
+		return nil
+	}
+	return &inletsv1alpha1.ResourceRef{Name: ref.Name, Namespace: ref.Namespace}
+}
+
+func toV1beta1Connection(in *inletsv1alpha1.ConnectionStatus) *inletsv1beta1.ConnectionStatus {
+	if in == nil {
+		return nil
+	}
+	return &inletsv1beta1.ConnectionStatus{
+		Clients:     in.Clients,
+		ConnectedAt: in.ConnectedAt.DeepCopy(),
+		LastSeen:    in.LastSeen.DeepCopy(),
+		Ports:       append([]int32(nil), in.Ports...),
+		LastChecked: in.LastChecked.DeepCopy(),
+		Error:       in.Error,
+	}
+}
+
+func toV1alpha1Connection(in *inletsv1beta1.ConnectionStatus) *inletsv1alpha1.ConnectionStatus {
+	if in == nil {
+		return nil
+	}
+	return &inletsv1alpha1.ConnectionStatus{
+		Clients:     in.Clients,
+		ConnectedAt: in.ConnectedAt.DeepCopy(),
+		LastSeen:    in.LastSeen.DeepCopy(),
+		Ports:       append([]int32(nil), in.Ports...),
+		LastChecked: in.LastChecked.DeepCopy(),
+		Error:       in.Error,
+	}
+}
+
+// convertTunnelToV1beta1 converts a stored v1alpha1 Tunnel for clients of
+// v1beta1.
+func convertTunnelToV1beta1(in *inletsv1alpha1.Tunnel) (*inletsv1beta1.Tunnel, error) {
+	out := &inletsv1beta1.Tunnel{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: inletsv1beta1.SchemeGroupVersion.String(),
+			Kind:       "Tunnel",
+		},
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+	}
+
+	if in.Spec.LicenseRef != nil {
+		data, err := json.Marshal(in.Spec.LicenseRef)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding licenseRef: %s", err)
+		}
+		if out.Annotations == nil {
+			out.Annotations = map[string]string{}
+		}
+		out.Annotations[licenseRefAnnotation] = string(data)
+	}
+
+	out.Spec.ServiceRef = toV1beta1Ref(in.Spec.ServiceRef)
+	out.Spec.AuthTokenRef = toV1beta1Ref(in.Spec.AuthTokenRef)
+	out.Spec.ServiceIPPolicy = inletsv1beta1.ServiceIPPolicyNone
+	if in.Spec.UpdateServiceIP {
+		out.Spec.ServiceIPPolicy = inletsv1beta1.ServiceIPPolicyUpdate
+	}
+	out.Spec.ExitServer = inletsv1beta1.ExitServerSpec{
+		Region: in.Spec.Region,
+		Pool:   in.Spec.ExitServerPool,
+		Shared: in.Spec.ShareExitServer,
+	}
+	out.Spec.Ports = append([]int32(nil), in.Spec.Ports...)
+	out.Spec.Upstream = in.Spec.Upstream
+
+	phase, ok := tunnelPhases[in.Status.HostStatus]
+	if !ok {
+		phase = inletsv1beta1.TunnelPhase(in.Status.HostStatus)
+	}
+
+	out.Status = inletsv1beta1.TunnelStatus{
+		Phase:     phase,
+		Generated: in.Status.Generated,
+		ExitServer: inletsv1beta1.ExitServerStatus{
+			ID: in.Status.HostID,
+			IP: in.Status.HostIP,
+		},
+		AuthTokenRef:        toV1beta1Ref(in.Status.AuthTokenRef),
+		ClientDeploymentRef: toV1beta1Ref(in.Status.ClientDeploymentRef),
+		Connection:          toV1beta1Connection(in.Status.Connection),
+	}
+
+	if fw := in.Status.Firewall; fw != nil {
+		out.Status.ExitServer.Firewall = &inletsv1beta1.FirewallStatus{
+			ID:           fw.ID,
+			Ports:        append([]int32(nil), fw.Ports...),
+			SourceRanges: append([]string(nil), fw.SourceRanges...),
+		}
+	}
+	if tls := in.Status.TLS; tls != nil {
+		out.Status.ExitServer.TLS = &inletsv1beta1.TLSStatus{
+			ServerName:  tls.ServerName,
+			CASecretRef: toV1beta1Ref(tls.CASecretRef),
+		}
+	}
+	if pa := in.Status.PoolAllocation; pa != nil {
+		out.Status.ExitServer.PoolAllocation = &inletsv1beta1.PoolAllocationStatus{Pool: pa.Pool, Server: pa.Server}
+	}
+	if cost := in.Status.Cost; cost != nil {
+		out.Status.ExitServer.Cost = &inletsv1beta1.CostStatus{
+			Provider: cost.Provider,
+			Plan:     cost.Plan,
+			Hourly:   cost.Hourly,
+			Monthly:  cost.Monthly,
+		}
+	}
+	for _, c := range in.Status.Conditions {
+		out.Status.Conditions = append(out.Status.Conditions, *c.DeepCopy())
+	}
+
+	return out, nil
+}
+
+// convertTunnelToV1alpha1 converts a v1beta1 Tunnel to v1alpha1, which is
+// the version stored and used by the controller.
+func convertTunnelToV1alpha1(in *inletsv1beta1.Tunnel) (*inletsv1alpha1.Tunnel, error) {
+	out := &inletsv1alpha1.Tunnel{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: inletsv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "Tunnel",
+		},
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+	}
+
+	if v, ok := out.Annotations[licenseRefAnnotation]; ok {
+		ref := &inletsv1alpha1.ResourceRef{}
+		if err := json.Unmarshal([]byte(v), ref); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %s", licenseRefAnnotation, err)
+		}
+		out.Spec.LicenseRef = ref
+
+		delete(out.Annotations, licenseRefAnnotation)
+		if len(out.Annotations) == 0 {
+			out.Annotations = nil
+		}
+	}
+
+	out.Spec.ServiceRef = toV1alpha1Ref(in.Spec.ServiceRef)
+	out.Spec.AuthTokenRef = toV1alpha1Ref(in.Spec.AuthTokenRef)
+	out.Spec.UpdateServiceIP = in.Spec.ServiceIPPolicy == inletsv1beta1.ServiceIPPolicyUpdate
+	out.Spec.Region = in.Spec.ExitServer.Region
+	out.Spec.ExitServerPool = in.Spec.ExitServer.Pool
+	out.Spec.ShareExitServer = in.Spec.ExitServer.Shared
+	out.Spec.Ports = append([]int32(nil), in.Spec.Ports...)
+	out.Spec.Upstream = in.Spec.Upstream
+
+	hostStatus := string(in.Status.Phase)
+	for status, phase := range tunnelPhases {
+		if phase == in.Status.Phase {
+			hostStatus = status
+		}
+	}
+
+	out.Status = inletsv1alpha1.TunnelStatus{
+		Generated:           in.Status.Generated,
+		HostStatus:          hostStatus,
+		HostIP:              in.Status.ExitServer.IP,
+		HostID:              in.Status.ExitServer.ID,
+		AuthTokenRef:        toV1alpha1Ref(in.Status.AuthTokenRef),
+		ClientDeploymentRef: toV1alpha1Ref(in.Status.ClientDeploymentRef),
+		Connection:          toV1alpha1Connection(in.Status.Connection),
+	}
+
+	if fw := in.Status.ExitServer.Firewall; fw != nil {
+		out.Status.Firewall = &inletsv1alpha1.FirewallStatus{
+			ID:           fw.ID,
+			Ports:        append([]int32(nil), fw.Ports...),
+			SourceRanges: append([]string(nil), fw.SourceRanges...),
+		}
+	}
+	if tls := in.Status.ExitServer.TLS; tls != nil {
+		out.Status.TLS = &inletsv1alpha1.TLSStatus{
+			ServerName:  tls.ServerName,
+			CASecretRef: toV1alpha1Ref(tls.CASecretRef),
+		}
+	}
+	if pa := in.Status.ExitServer.PoolAllocation; pa != nil {
+		out.Status.PoolAllocation = &inletsv1alpha1.PoolAllocationStatus{Pool: pa.Pool, Server: pa.Server}
+	}
+	if cost := in.Status.ExitServer.Cost; cost != nil {
+		out.Status.Cost = &inletsv1alpha1.CostStatus{
+			Provider: cost.Provider,
+			Plan:     cost.Plan,
+			Hourly:   cost.Hourly,
+			Monthly:  cost.Monthly,
+		}
+	}
+	for _, c := range in.Status.Conditions {
+		out.Status.Conditions = append(out.Status.Conditions, *c.DeepCopy())
+	}
+
+	return out, nil
+}
+
+// convertTunnel converts a Tunnel encoded as JSON to the desired version.
+func convertTunnel(raw []byte, desiredAPIVersion string) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, fmt.Errorf("error decoding object: %s", err)
+	}
+
+	if typeMeta.Kind != "Tunnel" {
+		return nil, fmt.Errorf("unsupported kind: %s", typeMeta.Kind)
+	}
+
+	var converted interface{}
+	var err error
+
+	switch {
+	case typeMeta.APIVersion == desiredAPIVersion:
+		return raw, nil
+
+	case typeMeta.APIVersion == inletsv1alpha1.SchemeGroupVersion.String() &&
+		desiredAPIVersion == inletsv1beta1.SchemeGroupVersion.String():
+		tunnel := &inletsv1alpha1.Tunnel{}
+		if err := json.Unmarshal(raw, tunnel); err != nil {
+			return nil, fmt.Errorf("error decoding tunnel: %s", err)
+		}
+		converted, err = convertTunnelToV1beta1(tunnel)
+
+	case typeMeta.APIVersion == inletsv1beta1.SchemeGroupVersion.String() &&
+		desiredAPIVersion == inletsv1alpha1.SchemeGroupVersion.String():
+		tunnel := &inletsv1beta1.Tunnel{}
+		if err := json.Unmarshal(raw, tunnel); err != nil {
+			return nil, fmt.Errorf("error decoding tunnel: %s", err)
+		}
+		converted, err = convertTunnelToV1alpha1(tunnel)
+
+	default:
+		return nil, fmt.Errorf("unsupported conversion from %s to %s", typeMeta.APIVersion, desiredAPIVersion)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
+
+// convert converts each object in the request, and fails the whole
+// request if any one of them cannot be converted.
+func convert(req *conversionRequest) *conversionResponse {
+	res := &conversionResponse{
+		UID:              req.UID,
+		ConvertedObjects: []runtime.RawExtension{},
+		Result:           metav1.Status{Status: metav1.StatusSuccess},
+	}
+
+	for _, obj := range req.Objects {
+		raw, err := convertTunnel(obj.Raw, req.DesiredAPIVersion)
+		if err != nil {
+			slog.Error("Error converting Tunnel", "apiVersion", req.DesiredAPIVersion, logKeyError, err)
+			return &conversionResponse{
+				UID: req.UID,
+				Result: metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: err.Error(),
+				},
+			}
+		}
+		res.ConvertedObjects = append(res.ConvertedObjects, runtime.RawExtension{Raw: raw})
+	}
+
+	return res
+}
+
+// serveConversion is the handler for the conversion webhook.
+func serveConversion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdmissionReviewSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := conversionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid ConversionReview", http.StatusBadRequest)
+		return
+	}
+
+	out, err := json.Marshal(conversionReview{
+		TypeMeta: review.TypeMeta,
+		Response: convert(review.Request),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// makeTunnelConversionPatch returns a JSON patch which sets the CRD's
+// conversion webhook, and serves v1beta1, which can only be converted by
+// the webhook. The CRD is read first to find v1beta1's index, which is
+// tested by the patch in case the CRD changes in between.
+func makeTunnelConversionPatch(crd *unstructured.Unstructured, namespace, service string, ca []byte) ([]jsonPatchOp, error) {
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return nil, fmt.Errorf("error reading versions of %s: %s", tunnelsCRDName, err)
+	}
+
+	index := -1
+	for i, v := range versions {
+		if version, ok := v.(map[string]interface{}); ok && version["name"] == inletsv1beta1.SchemeGroupVersion.Version {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%s has no version %s", tunnelsCRDName, inletsv1beta1.SchemeGroupVersion.Version)
+	}
+
+	return []jsonPatchOp{
+		{
+			Op:   "replace",
+			Path: "/spec/conversion",
+			Value: map[string]interface{}{
+				"strategy": "Webhook",
+				"webhook": map[string]interface{}{
+					"conversionReviewVersions": []string{"v1"},
+					"clientConfig": map[string]interface{}{
+						"caBundle": base64.StdEncoding.EncodeToString(ca),
+						"service": map[string]interface{}{
+							"name":      service,
+							"namespace": namespace,
+							"path":      convertTunnelPath,
+							"port":      443,
+						},
+					},
+				},
+			},
+		},
+		{Op: "test", Path: fmt.Sprintf("/spec/versions/%d/name", index), Value: inletsv1beta1.SchemeGroupVersion.Version},
+		{Op: "replace", Path: fmt.Sprintf("/spec/versions/%d/served", index), Value: true},
+	}, nil
+}
+
+// configureTunnelConversion points the Tunnel CRD's conversion webhook
+// at the operator's Service, with the CA for its certificate, and serves
+// v1beta1. The CRD is installed from static YAML with no conversion, and
+// v1beta1 not served, since the webhook is not always enabled.
+func configureTunnelConversion(client dynamic.Interface, namespace, service, caFile string) error {
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("error reading webhook CA: %s", err)
+	}
+
+	crds := client.Resource(schema.GroupVersionResource{
+		Group:    "apiextensions.k8s.io",
+		Version:  "v1",
+		Resource: "customresourcedefinitions",
+	})
+
+	crd, err := crds.Get(context.Background(), tunnelsCRDName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting %s: %s", tunnelsCRDName, err)
+	}
+
+	patch, err := makeTunnelConversionPatch(crd, namespace, service, ca)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	if _, err := crds.Patch(context.Background(), tunnelsCRDName, types.JSONPatchType, data, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("error configuring conversion webhook for %s: %s", tunnelsCRDName, err)
+	}
+
+	slog.Info("Configured conversion webhook", "crd", tunnelsCRDName, logKeyService, service, logKeyNamespace, namespace)
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	inletsv1beta1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1beta1"
+)
+
+// conversionTunnel sets every field, so that none are lost when converted
+var conversionTunnel = inletsv1alpha1.Tunnel{
+	TypeMeta: metav1.TypeMeta{
+		APIVersion: inletsv1alpha1.SchemeGroupVersion.String(),
+		Kind:       "Tunnel",
+	},
+	ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", Namespace: "default"},
+	Spec: inletsv1alpha1.TunnelSpec{
+		ServiceRef:      &inletsv1alpha1.ResourceRef{Name: "nginx", Namespace: "default"},
+		AuthTokenRef:    &inletsv1alpha1.ResourceRef{Name: "nginx-token", Namespace: "default"},
+		LicenseRef:      &inletsv1alpha1.ResourceRef{Name: "license", Namespace: "default"},
+		UpdateServiceIP: true,
+		Region:          "lon1",
+		ShareExitServer: true,
+		Ports:           []int32{80, 443},
+	},
+	Status: inletsv1alpha1.TunnelStatus{
+		Generated:  true,
+		HostStatus: "active",
+		HostIP:     "192.0.2.10",
+		HostID:     "1234",
+		Firewall: &inletsv1alpha1.FirewallStatus{
+			ID:           "fw-1",
+			Ports:        []int32{80, 8123},
+			SourceRanges: []string{"0.0.0.0/0"},
+		},
+		Cost: &inletsv1alpha1.CostStatus{Provider: "digitalocean", Plan: "s-1vcpu-1gb", Monthly: "5.00"},
+		Connection: &inletsv1alpha1.ConnectionStatus{
+			Clients:     1,
+			ConnectedAt: &metav1.Time{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			Ports:       []int32{80},
+		},
+		Conditions: []metav1.Condition{{
+			Type:   inletsv1alpha1.TunnelQuotaExceeded,
+			Status: metav1.ConditionFalse,
+			Reason: "WithinQuota",
+		}},
+	},
+}
+
+func Test_convertTunnelToV1beta1_Fields(t *testing.T) {
+	got, err := convertTunnelToV1beta1(conversionTunnel.DeepCopy())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.Spec.ServiceRef.Name != "nginx" {
+		t.Errorf("serviceRef, want %s, but got %s", "nginx", got.Spec.ServiceRef.Name)
+	}
+	if got.Spec.ServiceIPPolicy != inletsv1beta1.ServiceIPPolicyUpdate {
+		t.Errorf("serviceIPPolicy, want %s, but got %s", inletsv1beta1.ServiceIPPolicyUpdate, got.Spec.ServiceIPPolicy)
+	}
+	if got.Spec.ExitServer.Region != "lon1" || !got.Spec.ExitServer.Shared {
+		t.Errorf("exitServer, want region lon1 and shared, but got %v", got.Spec.ExitServer)
+	}
+	if got.Status.Phase != inletsv1beta1.TunnelPhaseActive {
+		t.Errorf("phase, want %s, but got %s", inletsv1beta1.TunnelPhaseActive, got.Status.Phase)
+	}
+	if got.Status.ExitServer.IP != "192.0.2.10" || got.Status.ExitServer.ID != "1234" {
+		t.Errorf("exitServer, want IP 192.0.2.10 and ID 1234, but got %s and %s", got.Status.ExitServer.IP, got.Status.ExitServer.ID)
+	}
+	if _, ok := got.Annotations[licenseRefAnnotation]; !ok {
+		t.Errorf("want %s annotation, but got none", licenseRefAnnotation)
+	}
+}
+
+func Test_convertTunnel_RoundTripV1alpha1(t *testing.T) {
+	want := conversionTunnel.DeepCopy()
+
+	beta, err := convertTunnelToV1beta1(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := convertTunnelToV1alpha1(beta)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %+v, but got %+v", want, got)
+	}
+}
+
+func Test_convertTunnel_RoundTripV1beta1(t *testing.T) {
+	want := &inletsv1beta1.Tunnel{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: inletsv1beta1.SchemeGroupVersion.String(),
+			Kind:       "Tunnel",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", Namespace: "default"},
+		Spec: inletsv1beta1.TunnelSpec{
+			ServiceRef:      &inletsv1beta1.ObjectReference{Name: "nginx"},
+			ServiceIPPolicy: inletsv1beta1.ServiceIPPolicyNone,
+			ExitServer:      inletsv1beta1.ExitServerSpec{Pool: "static"},
+		},
+		Status: inletsv1beta1.TunnelStatus{
+			Phase: inletsv1beta1.TunnelPhaseProvisioning,
+			ExitServer: inletsv1beta1.ExitServerStatus{
+				ID: "static/one",
+				PoolAllocation: &inletsv1beta1.PoolAllocationStatus{
+					Pool:   "static",
+					Server: "one",
+				},
+			},
+		},
+	}
+
+	alpha, err := convertTunnelToV1alpha1(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if alpha.Status.HostStatus != "provisioning" {
+		t.Errorf("hostStatus, want %s, but got %s", "provisioning", alpha.Status.HostStatus)
+	}
+
+	got, err := convertTunnelToV1beta1(alpha)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %+v, but got %+v", want, got)
+	}
+}
+
+func Test_convertTunnel_RoundTripUpstream(t *testing.T) {
+	want := conversionTunnel.DeepCopy()
+	want.Spec.ServiceRef = nil
+	want.Spec.Upstream = "192.168.0.10"
+
+	beta, err := convertTunnelToV1beta1(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if beta.Spec.ServiceRef != nil || beta.Spec.Upstream != "192.168.0.10" {
+		t.Errorf("want upstream 192.168.0.10 without serviceRef, but got %s and %v", beta.Spec.Upstream, beta.Spec.ServiceRef)
+	}
+
+	got, err := convertTunnelToV1alpha1(beta)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %+v, but got %+v", want, got)
+	}
+}
+
+func Test_convertTunnelToV1beta1_PendingPhase(t *testing.T) {
+	tunnel := conversionTunnel.DeepCopy()
+	tunnel.Status = inletsv1alpha1.TunnelStatus{}
+
+	got, err := convertTunnelToV1beta1(tunnel)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.Status.Phase != inletsv1beta1.TunnelPhasePending {
+		t.Fatalf("want %s, but got %s", inletsv1beta1.TunnelPhasePending, got.Status.Phase)
+	}
+}
+
+func Test_convertTunnelToV1alpha1_InvalidLicenseRef(t *testing.T) {
+	tunnel := &inletsv1beta1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nginx-tunnel",
+			Annotations: map[string]string{licenseRefAnnotation: "{"},
+		},
+	}
+
+	if _, err := convertTunnelToV1alpha1(tunnel); err == nil {
+		t.Fatalf("want error for invalid %s annotation, but got nil", licenseRefAnnotation)
+	}
+}
+
+func Test_convertTunnel_UnsupportedVersion(t *testing.T) {
+	raw, _ := json.Marshal(conversionTunnel.DeepCopy())
+
+	if _, err := convertTunnel(raw, "operator.inlets.dev/v2"); err == nil {
+		t.Fatalf("want error for unsupported version, but got nil")
+	}
+}
+
+func Test_serveConversion_ToV1beta1(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(serveConversion))
+	defer server.Close()
+
+	raw, _ := json.Marshal(conversionTunnel.DeepCopy())
+	review := conversionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+		Request: &conversionRequest{
+			UID:               "1234",
+			DesiredAPIVersion: inletsv1beta1.SchemeGroupVersion.String(),
+			Objects:           []runtime.RawExtension{{Raw: raw}},
+		},
+	}
+	body, _ := json.Marshal(review)
+
+	res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+
+	got := conversionReview{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.Response == nil || got.Response.UID != "1234" {
+		t.Fatalf("want response for UID 1234, but got %+v", got.Response)
+	}
+	if got.Response.Result.Status != metav1.StatusSuccess {
+		t.Fatalf("want %s, but got %s: %s", metav1.StatusSuccess, got.Response.Result.Status, got.Response.Result.Message)
+	}
+	if len(got.Response.ConvertedObjects) != 1 {
+		t.Fatalf("want 1 converted object, but got %d", len(got.Response.ConvertedObjects))
+	}
+
+	tunnel := inletsv1beta1.Tunnel{}
+	if err := json.Unmarshal(got.Response.ConvertedObjects[0].Raw, &tunnel); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tunnel.APIVersion != inletsv1beta1.SchemeGroupVersion.String() {
+		t.Fatalf("want %s, but got %s", inletsv1beta1.SchemeGroupVersion.String(), tunnel.APIVersion)
+	}
+}
+
+func Test_makeTunnelConversionPatch(t *testing.T) {
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"conversion": map[string]interface{}{"strategy": "None"},
+			"versions": []interface{}{
+				map[string]interface{}{"name": "v1alpha1", "served": true},
+				map[string]interface{}{"name": "v1beta1", "served": false},
+			},
+		},
+	}}
+
+	patch, err := makeTunnelConversionPatch(crd, "inlets", "inlets-operator-webhook", []byte("ca"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(patch) != 3 || patch[0].Path != "/spec/conversion" {
+		t.Fatalf("want the conversion replaced first, but got %+v", patch)
+	}
+	if got := patch[0].Value.(map[string]interface{})["strategy"]; got != "Webhook" {
+		t.Errorf("want strategy Webhook, but got %v", got)
+	}
+	if patch[1].Op != "test" || patch[1].Path != "/spec/versions/1/name" || patch[1].Value != "v1beta1" {
+		t.Errorf("want a test of v1beta1's index, but got %+v", patch[1])
+	}
+	if patch[2].Path != "/spec/versions/1/served" || patch[2].Value != true {
+		t.Errorf("want v1beta1 served, but got %+v", patch[2])
+	}
+}
+
+func Test_makeTunnelConversionPatch_NoV1beta1(t *testing.T) {
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"versions": []interface{}{
+				map[string]interface{}{"name": "v1alpha1", "served": true},
+			},
+		},
+	}}
+
+	_, err := makeTunnelConversionPatch(crd, "inlets", "inlets-operator-webhook", []byte("ca"))
+	want := "tunnels.operator.inlets.dev has no version v1beta1"
+	if err == nil || err.Error() != want {
+		t.Fatalf("want error %q, but got %v", want, err)
+	}
+}
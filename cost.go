@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	listers "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1alpha1"
+)
+
+// UnknownPrice is used as part of the Event 'reason' when there is no
+// price for the exit server's plan, so its cost cannot be estimated
+const UnknownPrice = "UnknownPrice"
+
+// hoursPerMonth is the average number of hours in a month
+const hoursPerMonth = 730
+
+// defaultPrices are approximate hourly list prices in USD for the plans
+// used by default for each provider, they can be overridden or extended
+// with "prices" in the config.
+var defaultPrices = map[string]map[string]float64{
+	"digitalocean": {"s-1vcpu-1gb": 0.00893},
+	"scaleway":     {"DEV1-S": 0.0088},
+	"gce":          {"f1-micro": 0.0076},
+	"ec2":          {"t3.micro": 0.0104},
+	"linode":       {"g6-nanode-1": 0.0075},
+	"azure":        {"Standard_B1ls": 0.0052},
+	"hetzner":      {"cx22": 0.0065},
+}
+
+// getHourlyPrice returns the price of a plan from the config, or from the
+// defaults.
+func getHourlyPrice(config *InfraConfig, provider, plan string) (float64, bool) {
+	if price, ok := config.Prices[provider][plan]; ok {
+		return price, true
+	}
+	price, ok := defaultPrices[provider][plan]
+	return price, ok
+}
+
+// estimateCost returns the cost of an exit server to record in the Tunnel's
+// status, the prices are left empty when the plan has no price.
+func estimateCost(config *InfraConfig, provider, plan string) (*inletsv1alpha1.CostStatus, bool) {
+	cost := &inletsv1alpha1.CostStatus{
+		Provider: provider,
+		Plan:     plan,
+	}
+
+	hourly, ok := getHourlyPrice(config, provider, plan)
+	if !ok {
+		return cost, false
+	}
+
+	cost.Hourly = strconv.FormatFloat(hourly, 'f', 4, 64)
+	cost.Monthly = strconv.FormatFloat(hourly*hoursPerMonth, 'f', 2, 64)
+	return cost, true
+}
+
+// validatePrices rejects negative prices in the config.
+func validatePrices(c InfraConfig) error {
+	for provider, plans := range c.Prices {
+		for plan, price := range plans {
+			if price < 0 {
+				return fmt.Errorf("invalid price for %s plan %s: %f", provider, plan, price)
+			}
+		}
+	}
+	return nil
+}
+
+// costCollector sums the estimated cost of the Tunnels' exit servers by
+// namespace and provider each time the metrics are scraped.
+type costCollector struct {
+	tunnelsLister listers.TunnelLister
+
+	hourly  *prometheus.Desc
+	monthly *prometheus.Desc
+	hosts   *prometheus.Desc
+}
+
+func newCostCollector(tunnelsLister listers.TunnelLister) *costCollector {
+	labels := []string{"namespace", "provider"}
+	return &costCollector{
+		tunnelsLister: tunnelsLister,
+		hourly: prometheus.NewDesc("inlets_operator_exit_servers_hourly_cost",
+			"Estimated hourly cost of exit servers created by the operator", labels, nil),
+		monthly: prometheus.NewDesc("inlets_operator_exit_servers_monthly_cost",
+			"Estimated monthly cost of exit servers created by the operator", labels, nil),
+		hosts: prometheus.NewDesc("inlets_operator_exit_servers",
+			"Number of exit servers created by the operator", labels, nil),
+	}
+}
+
+func (c *costCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hourly
+	ch <- c.monthly
+	ch <- c.hosts
+}
+
+type costKey struct {
+	namespace string
+	provider  string
+}
+
+func (c *costCollector) Collect(ch chan<- prometheus.Metric) {
+	tunnels, err := c.tunnelsLister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+
+	hourly := map[costKey]float64{}
+	monthly := map[costKey]float64{}
+	hosts := map[costKey]float64{}
+
+	for _, t := range tunnels {
+		cost := t.Status.Cost
+		if cost == nil {
+			continue
+		}
+
+		key := costKey{namespace: t.Namespace, provider: cost.Provider}
+		hosts[key]++
+
+		if v, err := strconv.ParseFloat(cost.Hourly, 64); err == nil {
+			hourly[key] += v
+		}
+		if v, err := strconv.ParseFloat(cost.Monthly, 64); err == nil {
+			monthly[key] += v
+		}
+	}
+
+	for key, n := range hosts {
+		ch <- prometheus.MustNewConstMetric(c.hosts, prometheus.GaugeValue, n, key.namespace, key.provider)
+		ch <- prometheus.MustNewConstMetric(c.hourly, prometheus.GaugeValue, hourly[key], key.namespace, key.provider)
+		ch <- prometheus.MustNewConstMetric(c.monthly, prometheus.GaugeValue, monthly[key], key.namespace, key.provider)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	listers "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1alpha1"
+)
+
+func Test_estimateCost_Default(t *testing.T) {
+	cost, ok := estimateCost(&InfraConfig{}, "ec2", "t3.micro")
+	if !ok {
+		t.Fatalf("want a price for t3.micro")
+	}
+
+	if cost.Hourly != "0.0104" || cost.Monthly != "7.59" {
+		t.Fatalf("want hourly 0.0104 and monthly 7.59, but got %s and %s", cost.Hourly, cost.Monthly)
+	}
+}
+
+func Test_estimateCost_Override(t *testing.T) {
+	c := &InfraConfig{
+		Prices: map[string]map[string]float64{
+			"ec2": {"t3.micro": 0.02, "t3.small": 0.0208},
+		},
+	}
+
+	cost, ok := estimateCost(c, "ec2", "t3.micro")
+	if !ok || cost.Hourly != "0.0200" {
+		t.Fatalf("want overridden hourly price 0.0200, but got %s", cost.Hourly)
+	}
+
+	cost, ok = estimateCost(c, "ec2", "t3.small")
+	if !ok || cost.Monthly != "15.18" {
+		t.Fatalf("want monthly price 15.18, but got %s", cost.Monthly)
+	}
+}
+
+func Test_estimateCost_Unknown(t *testing.T) {
+	cost, ok := estimateCost(&InfraConfig{}, "digitalocean", "s-8vcpu-16gb")
+	if ok {
+		t.Fatalf("want no price for s-8vcpu-16gb")
+	}
+
+	if cost.Plan != "s-8vcpu-16gb" || cost.Hourly != "" || cost.Monthly != "" {
+		t.Fatalf("want plan without prices, but got %+v", cost)
+	}
+}
+
+func Test_validatePrices_Negative(t *testing.T) {
+	c := InfraConfig{
+		Prices: map[string]map[string]float64{"ec2": {"t3.micro": -1}},
+	}
+
+	if err := validatePrices(c); err == nil {
+		t.Fatalf("want error for a negative price")
+	}
+}
+
+func Test_costCollector(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, tunnel := range []*inletsv1alpha1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-tunnel", Namespace: "team-a"},
+			Status: inletsv1alpha1.TunnelStatus{
+				Cost: &inletsv1alpha1.CostStatus{Provider: "ec2", Plan: "t3.micro", Hourly: "0.0104", Monthly: "7.59"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "b-tunnel", Namespace: "team-a"},
+			Status: inletsv1alpha1.TunnelStatus{
+				Cost: &inletsv1alpha1.CostStatus{Provider: "ec2", Plan: "t3.micro", Hourly: "0.0104", Monthly: "7.59"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool-tunnel", Namespace: "team-a"},
+		},
+	} {
+		indexer.Add(tunnel)
+	}
+
+	collector := newCostCollector(listers.NewTunnelLister(indexer))
+
+	want := `
+# HELP inlets_operator_exit_servers Number of exit servers created by the operator
+# TYPE inlets_operator_exit_servers gauge
+inlets_operator_exit_servers{namespace="team-a",provider="ec2"} 2
+# HELP inlets_operator_exit_servers_monthly_cost Estimated monthly cost of exit servers created by the operator
+# TYPE inlets_operator_exit_servers_monthly_cost gauge
+inlets_operator_exit_servers_monthly_cost{namespace="team-a",provider="ec2"} 15.18
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want),
+		"inlets_operator_exit_servers", "inlets_operator_exit_servers_monthly_cost"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+
+	provision "github.com/inlets/cloud-provision/provision"
+	appsv1 "k8s.io/api/apps/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+const (
+	// DryRun is used as part of the Event 'reason' when a Tunnel is given
+	// a fake exit server because the operator is running with -dry-run
+	DryRun = "DryRun"
+
+	// dryRunHostPrefix starts the ID of each fake exit server
+	dryRunHostPrefix = "dry-run-"
+
+	// redactedToken replaces the tunnel's token when a host is logged
+	redactedToken = "<redacted>"
+)
+
+// dryRunProvisioner stands in for the provider's provisioner under
+// -dry-run, no hosts are created or deleted. Hosts are reported as active
+// straight away, with an IP from the documentation range 192.0.2.0/24.
+type dryRunProvisioner struct {
+}
+
+func (p *dryRunProvisioner) Provision(host provision.BasicHost) (*provision.ProvisionedHost, error) {
+	return &provision.ProvisionedHost{
+		ID:     dryRunHostPrefix + host.Name,
+		Status: "provisioning",
+	}, nil
+}
+
+func (p *dryRunProvisioner) Status(id string) (*provision.ProvisionedHost, error) {
+	return &provision.ProvisionedHost{
+		ID:     id,
+		IP:     getDryRunIP(id),
+		Status: provision.ActiveStatus,
+	}, nil
+}
+
+func (p *dryRunProvisioner) Delete(req provision.HostDeleteRequest) error {
+	slog.Info("Dry run: not deleting tunnel server", logKeyHostID, req.ID, "ip", req.IP)
+	return nil
+}
+
+// isDryRunHost returns whether the host was faked under -dry-run, these
+// are never sent to the provider, even once -dry-run is turned off.
+func isDryRunHost(hostID string) bool {
+	return strings.HasPrefix(hostID, dryRunHostPrefix)
+}
+
+// isDryRunTunnel returns whether the Tunnel's exit server is fake, or
+// would be faked when it is created. Tunnels with a real exit server are
+// managed as usual under -dry-run, so that their hosts, firewalls and
+// clients are not left behind or scaled down.
+func (c *Controller) isDryRunTunnel(tunnel *inletsv1alpha1.Tunnel) bool {
+	if len(tunnel.Status.HostID) > 0 {
+		return isDryRunHost(tunnel.Status.HostID)
+	}
+	return c.dryRun
+}
+
+// getDryRunIP returns a stable IP for a fake host from 192.0.2.0/24,
+// which is reserved for documentation and never routed.
+func getDryRunIP(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return fmt.Sprintf("192.0.2.%d", h.Sum32()%254+1)
+}
+
+// redactHost returns a copy of host with the token removed from its
+// user-data and additional fields. EC2's user-data is base64 encoded, so
+// is decoded to be redacted and printed.
+func redactHost(host provision.BasicHost, token string) provision.BasicHost {
+	redact := func(s string) string {
+		if len(token) == 0 {
+			return s
+		}
+		return strings.ReplaceAll(s, token, redactedToken)
+	}
+
+	copy := host
+	copy.UserData = redact(host.UserData)
+	if decoded, err := base64.StdEncoding.DecodeString(host.UserData); err == nil &&
+		len(token) > 0 && strings.Contains(string(decoded), token) {
+		copy.UserData = redact(string(decoded))
+	}
+
+	copy.Additional = map[string]string{}
+	for k, v := range host.Additional {
+		copy.Additional[k] = redact(v)
+	}
+
+	return copy
+}
+
+// logDryRunHost logs the host that would be sent to the provider.
+func logDryRunHost(logger *slog.Logger, host provision.BasicHost) {
+	logger.Info("Dry run: would create tunnel server",
+		"name", host.Name,
+		"region", host.Region,
+		"plan", host.Plan,
+		"os", host.OS,
+		"additional", host.Additional,
+		"userData", host.UserData)
+}
+
+// scaleToZero sets a client Deployment to no replicas, as there is no
+// exit server for it to connect to.
+func scaleToZero(deployment *appsv1.Deployment) {
+	replicas := int32(0)
+	deployment.Spec.Replicas = &replicas
+}
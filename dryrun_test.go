@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	provision "github.com/inlets/cloud-provision/provision"
+)
+
+func Test_redactHost_UserData(t *testing.T) {
+	host := provision.BasicHost{
+		Name:       "nginx-tunnel",
+		UserData:   "export AUTHTOKEN=\"secret-token\"",
+		Additional: map[string]string{"ports": "80"},
+	}
+
+	got := redactHost(host, "secret-token")
+
+	if strings.Contains(got.UserData, "secret-token") {
+		t.Fatalf("want token redacted, but got %s", got.UserData)
+	}
+	if !strings.Contains(got.UserData, redactedToken) {
+		t.Errorf("want %s in user-data, but got %s", redactedToken, got.UserData)
+	}
+	if !strings.Contains(host.UserData, "secret-token") {
+		t.Errorf("want original host unchanged, but got %s", host.UserData)
+	}
+}
+
+func Test_redactHost_Base64UserData(t *testing.T) {
+	host := provision.BasicHost{
+		UserData: base64.StdEncoding.EncodeToString([]byte("export AUTHTOKEN=\"secret-token\"")),
+	}
+
+	got := redactHost(host, "secret-token")
+
+	want := "export AUTHTOKEN=\"" + redactedToken + "\""
+	if got.UserData != want {
+		t.Fatalf("want %s, but got %s", want, got.UserData)
+	}
+}
+
+func Test_dryRunProvisioner_Status(t *testing.T) {
+	p := &dryRunProvisioner{}
+
+	res, err := p.Provision(provision.BasicHost{Name: "nginx-tunnel"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := p.Status(res.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.Status != provision.ActiveStatus {
+		t.Errorf("want %s, but got %s", provision.ActiveStatus, got.Status)
+	}
+	if !strings.HasPrefix(got.IP, "192.0.2.") || got.IP != getDryRunIP(res.ID) {
+		t.Errorf("want a stable IP in 192.0.2.0/24, but got %s", got.IP)
+	}
+}
+
+func Test_getProvisioner_DryRun(t *testing.T) {
+	c := &Controller{infraConfig: newConfigStore(&InfraConfig{Provider: "digitalocean"}), dryRun: true}
+
+	got, err := getProvisioner(c, "lon1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := got.(*dryRunProvisioner); !ok {
+		t.Fatalf("want dryRunProvisioner, but got %T", got)
+	}
+	if getFirewaller(c, makeTestTunnel("nginx-tunnel", "default", "nginx")) != nil {
+		t.Errorf("want no firewaller under dry-run")
+	}
+}
+
+func Test_getHostProvisioner_DryRun(t *testing.T) {
+	c := &Controller{infraConfig: newConfigStore(&InfraConfig{Provider: "digitalocean", AccessKey: "token"}), dryRun: true}
+
+	got, err := getHostProvisioner(c, "lon1", "1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := got.(*dryRunProvisioner); ok {
+		t.Fatalf("want the provider's provisioner for a real host under dry-run")
+	}
+
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Status.HostID = "1234"
+	if getFirewaller(c, tunnel) == nil {
+		t.Errorf("want a firewaller for a real host under dry-run")
+	}
+}
+
+func Test_getHostProvisioner_DryRunHostAfterDryRun(t *testing.T) {
+	c := &Controller{infraConfig: newConfigStore(&InfraConfig{Provider: "digitalocean", AccessKey: "token"})}
+
+	got, err := getHostProvisioner(c, "lon1", dryRunHostPrefix+"nginx-tunnel")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := got.(*dryRunProvisioner); !ok {
+		t.Fatalf("want dryRunProvisioner for a fake host, but got %T", got)
+	}
+}
+
+func Test_isDryRunTunnel(t *testing.T) {
+	c := &Controller{dryRun: true}
+
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	if !c.isDryRunTunnel(tunnel) {
+		t.Errorf("want a new Tunnel to be faked under dry-run")
+	}
+
+	tunnel.Status.HostID = "1234"
+	if c.isDryRunTunnel(tunnel) {
+		t.Errorf("want a Tunnel with a real host not to be faked under dry-run")
+	}
+
+	c.dryRun = false
+	tunnel.Status.HostID = dryRunHostPrefix + "nginx-tunnel"
+	if !c.isDryRunTunnel(tunnel) {
+		t.Errorf("want a Tunnel with a fake host to stay faked after dry-run")
+	}
+}
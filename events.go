@@ -0,0 +1,78 @@
+package main
+
+import (
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+const (
+	// AuthTokenCreated is used as part of the Event 'reason' when the
+	// Secret with the tunnel's token is created
+	AuthTokenCreated = "AuthTokenCreated"
+	// ErrAuthToken is used as part of the Event 'reason' when the Secret
+	// with the tunnel's token cannot be created
+	ErrAuthToken = "ErrAuthToken"
+
+	// Provisioning is used as part of the Event 'reason' when the
+	// provider is asked to create an exit server
+	Provisioning = "Provisioning"
+	// ErrProvisioning is used as part of the Event 'reason' when an exit
+	// server cannot be created
+	ErrProvisioning = "ErrProvisioning"
+
+	// HostActive is used as part of the Event 'reason' when the exit
+	// server has booted and has an IP
+	HostActive = "HostActive"
+	// ErrHostStatus is used as part of the Event 'reason' when the status
+	// of an exit server being created cannot be read
+	ErrHostStatus = "ErrHostStatus"
+
+	// ClientCreated is used as part of the Event 'reason' when the
+	// tunnel client's Deployment is created
+	ClientCreated = "ClientCreated"
+	// ClientUpdated is used as part of the Event 'reason' when the tunnel
+	// client's Deployment is updated for new ports or a new exit server
+	ClientUpdated = "ClientUpdated"
+	// ErrClient is used as part of the Event 'reason' when the tunnel
+	// client's Deployment cannot be created or updated
+	ErrClient = "ErrClient"
+
+	// IngressPublished is used as part of the Event 'reason' when the exit
+	// server's IP is added to the Service
+	IngressPublished = "IngressPublished"
+	// IngressRemoved is used as part of the Event 'reason' when the exit
+	// server's IP is removed from the Service
+	IngressRemoved = "IngressRemoved"
+	// ErrIngress is used as part of the Event 'reason' when the Service
+	// cannot be updated with the exit server's IP
+	ErrIngress = "ErrIngress"
+
+	// Deleting is used as part of the Event 'reason' when the exit server
+	// of a deleted Tunnel is being deleted
+	Deleting = "Deleting"
+	// HostDeleted is used as part of the Event 'reason' when the exit
+	// server of a deleted Tunnel has been deleted
+	HostDeleted = "HostDeleted"
+	// HostKept is used as part of the Event 'reason' when the exit server
+	// of a deleted Tunnel is kept for the other Tunnels sharing it
+	HostKept = "HostKept"
+	// ErrDeleting is used as part of the Event 'reason' when the exit
+	// server of a deleted Tunnel cannot be deleted
+	ErrDeleting = "ErrDeleting"
+
+	// GatewayProgrammed is used as part of the Event 'reason' when the exit
+	// server's IP is written to a Gateway's addresses
+	GatewayProgrammed = "GatewayProgrammed"
+)
+
+// recordEvent records an Event on the Tunnel, and on its Service, so that
+// the Tunnel's progress shows up when either is described.
+func (c *Controller) recordEvent(tunnel *inletsv1alpha1.Tunnel, eventtype, reason, messageFmt string, args ...interface{}) {
+	c.recorder.Eventf(tunnel, eventtype, reason, messageFmt, args...)
+
+	if tunnel.Spec.ServiceRef == nil || c.serviceLister == nil {
+		return
+	}
+	if service, err := c.lookupService(tunnel); err == nil {
+		c.recorder.Eventf(service, eventtype, reason, messageFmt, args...)
+	}
+}
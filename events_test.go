@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func makeEventsController(service *corev1.Service) (*Controller, *record.FakeRecorder) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(service)
+
+	recorder := record.NewFakeRecorder(10)
+	return &Controller{
+		kubeclientset: kubefake.NewSimpleClientset(service),
+		serviceLister: corelisters.NewServiceLister(indexer),
+		recorder:      recorder,
+	}, recorder
+}
+
+func readEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func Test_recordEvent_TunnelAndService(t *testing.T) {
+	c, recorder := makeEventsController(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"}})
+
+	c.recordEvent(makeTestTunnel("nginx-tunnel", "default", "nginx"), corev1.EventTypeNormal, HostActive, "Tunnel server %s is active at %s", "1234", "192.0.2.10")
+
+	got := readEvents(recorder)
+	want := "Normal HostActive Tunnel server 1234 is active at 192.0.2.10"
+	if len(got) != 2 || got[0] != want || got[1] != want {
+		t.Fatalf("want %q on the Tunnel and Service, but got %q", want, got)
+	}
+}
+
+func Test_recordEvent_NoService(t *testing.T) {
+	c, recorder := makeEventsController(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}})
+
+	c.recordEvent(makeTestTunnel("nginx-tunnel", "default", "nginx"), corev1.EventTypeWarning, ErrDeleting, "Error deleting tunnel server")
+
+	if got := readEvents(recorder); len(got) != 1 {
+		t.Fatalf("want 1 event on the Tunnel only, but got %q", got)
+	}
+}
+
+func Test_updateService_IngressEvents(t *testing.T) {
+	c, recorder := makeEventsController(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"}})
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.OwnerReferences = []metav1.OwnerReference{{Kind: "Service", Name: "nginx"}}
+
+	if err := c.updateService(tunnel, "192.0.2.10"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	service, _ := c.kubeclientset.CoreV1().Services("default").Get(context.Background(), "nginx", metav1.GetOptions{})
+	if len(service.Status.LoadBalancer.Ingress) != 1 || service.Status.LoadBalancer.Ingress[0].IP != "192.0.2.10" {
+		t.Fatalf("want ingress 192.0.2.10, but got %v", service.Status.LoadBalancer.Ingress)
+	}
+
+	got := readEvents(recorder)
+	if len(got) == 0 || !strings.HasPrefix(got[0], "Normal "+IngressPublished) {
+		t.Fatalf("want %s event, but got %q", IngressPublished, got)
+	}
+
+	// Publishing the same IP again is not a transition
+	if err := c.updateService(tunnel, "192.0.2.10"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := readEvents(recorder); len(got) != 0 {
+		t.Errorf("want no events, but got %q", got)
+	}
+}
+
+func Test_updateService_NoWritesWhenPublished(t *testing.T) {
+	c, _ := makeEventsController(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{ExternalIPs: []string{"192.0.2.10"}},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "192.0.2.10"}},
+		}},
+	})
+	client := c.kubeclientset.(*kubefake.Clientset)
+	client.ClearActions()
+
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.OwnerReferences = []metav1.OwnerReference{{Kind: "Service", Name: "nginx"}}
+
+	// The ActiveStatus branch publishes the IP on every sync
+	if err := c.updateService(tunnel, "192.0.2.10"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "update" {
+			t.Errorf("want no updates to the Service, but got %s %s", a.GetVerb(), a.GetSubresource())
+		}
+	}
+}
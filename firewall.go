@@ -0,0 +1,872 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/digitalocean/godo"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// sourceRangesAnnotation is the legacy way to set source ranges on a
+// Service, spec.loadBalancerSourceRanges takes precedence.
+const sourceRangesAnnotation = "service.beta.kubernetes.io/load-balancer-source-ranges"
+
+const (
+	// ErrFirewall is used as part of the Event 'reason' when the exit
+	// server's firewall cannot be applied
+	ErrFirewall = "ErrFirewall"
+	// FirewallApplied is used as part of the Event 'reason' when the exit
+	// server's firewall is created or updated
+	FirewallApplied = "FirewallApplied"
+)
+
+// firewallRules are the ports of the Service and the ranges allowed to
+// connect to them. The control port is always left open, so that the
+// client can connect from the cluster.
+type firewallRules struct {
+	Ports        []int32
+	SourceRanges []string
+}
+
+// equal compares the rules with those recorded in the Tunnel's status.
+func (r firewallRules) equal(status *inletsv1alpha1.FirewallStatus) bool {
+	if status == nil {
+		return false
+	}
+	return reflect.DeepEqual(r.Ports, status.Ports) &&
+		reflect.DeepEqual(r.SourceRanges, status.SourceRanges)
+}
+
+// firewaller manages a cloud firewall for a single exit server.
+type firewaller interface {
+	// Apply creates the firewall for the Tunnel in namespace when id is
+	// empty, or replaces its rules, and returns the firewall's ID.
+	Apply(namespace, name, id, hostID string, rules firewallRules) (string, error)
+
+	// Delete removes the firewall.
+	Delete(id string) error
+
+	// OpensPorts is true when the exit server's ports are only reachable
+	// through the firewall, so it is kept even when no source ranges are
+	// set.
+	OpensPorts() bool
+}
+
+// getFirewaller returns a firewaller for providers with cloud firewalls,
+// for all other providers, rules are written to the host's firewall
+// via user-data when the exit server is created.
+func getFirewaller(c *Controller, tunnel *inletsv1alpha1.Tunnel) firewaller {
+	if c.isDryRunTunnel(tunnel) {
+		return nil
+	}
+
+	fw := getProviderFirewaller(c, getTunnelRegion(c, tunnel))
+	if fw == nil || c.providerGuards == nil {
+		return fw
+	}
+	return &guardedFirewaller{firewaller: fw, guard: c.providerGuards.get(c.config().Provider)}
+}
+
+func getProviderFirewaller(c *Controller, region string) firewaller {
+	switch c.config().Provider {
+	case "digitalocean":
+		return &digitalOceanFirewaller{client: godo.NewFromToken(c.config().GetAccessKey())}
+	case "hetzner":
+		return &hetznerFirewaller{client: hcloud.NewClient(hcloud.WithToken(c.config().GetAccessKey()))}
+	case "ec2":
+		sess, err := session.NewSession(&aws.Config{
+			Region:      aws.String(region),
+			Credentials: credentials.NewStaticCredentials(c.config().GetAccessKey(), c.config().GetSecretKey(), ""),
+		})
+		if err != nil {
+			slog.Error("Error creating AWS session", logKeyProvider, "ec2", logKeyError, err)
+			return nil
+		}
+		return &ec2Firewaller{client: ec2.New(sess)}
+	case "gce":
+		service, err := compute.NewService(context.Background(), option.WithCredentialsJSON([]byte(c.config().GetAccessKey())))
+		if err != nil {
+			slog.Error("Error creating GCE client", logKeyProvider, "gce", logKeyError, err)
+			return nil
+		}
+		return &gceFirewaller{client: service, projectID: c.config().ProjectID}
+	}
+	return nil
+}
+
+// getSourceRanges returns the CIDRs allowed to connect to the Service,
+// an empty list means the Service is open to all.
+func getSourceRanges(service *corev1.Service) ([]string, error) {
+	if service == nil {
+		return nil, nil
+	}
+
+	ranges := service.Spec.LoadBalancerSourceRanges
+	if len(ranges) == 0 {
+		if v, ok := service.Annotations[sourceRangesAnnotation]; ok {
+			ranges = strings.Split(v, ",")
+		}
+	}
+
+	res := []string{}
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+
+		_, cidr, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source range %q: %s", r, err.Error())
+		}
+		res = append(res, cidr.String())
+	}
+
+	sort.Strings(res)
+	return res, nil
+}
+
+// getFirewallRules builds the rules for the Tunnel's exit server.
+func getFirewallRules(tunnel *inletsv1alpha1.Tunnel, service *corev1.Service) (firewallRules, error) {
+	ranges, err := getSourceRanges(service)
+	if err != nil {
+		return firewallRules{}, err
+	}
+
+	ports := append([]int32{}, getTunnelPorts(tunnel, service)...)
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	return firewallRules{
+		Ports:        ports,
+		SourceRanges: ranges,
+	}, nil
+}
+
+// makeHostFirewallUserdata returns a script to restrict the Service's
+// ports to the source ranges with iptables, for providers without a
+// cloud firewall.
+func makeHostFirewallUserdata(rules firewallRules) string {
+	if len(rules.SourceRanges) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n# Restrict the tunnel's ports to the Service's loadBalancerSourceRanges\n")
+	for _, port := range rules.Ports {
+		for _, r := range rules.SourceRanges {
+			cmd := "iptables"
+			if strings.Contains(r, ":") {
+				cmd = "ip6tables"
+			}
+			sb.WriteString(fmt.Sprintf("%s -A INPUT -p tcp --dport %d -s %s -j ACCEPT\n", cmd, port, r))
+		}
+		sb.WriteString(fmt.Sprintf("iptables -A INPUT -p tcp --dport %d -j DROP\n", port))
+		sb.WriteString(fmt.Sprintf("ip6tables -A INPUT -p tcp --dport %d -j DROP\n", port))
+	}
+
+	return sb.String()
+}
+
+// hostFirewallRequired returns whether the source ranges are written to
+// the host's firewall. On GCE the provisioner attaches a shared "inlets"
+// rule which opens the control port, 80 and 443 to all, and since GCE
+// rules only allow traffic, the per-instance rule can not narrow it.
+func hostFirewallRequired(c *Controller, tunnel *inletsv1alpha1.Tunnel) bool {
+	return c.config().Provider == "gce" || getFirewaller(c, tunnel) == nil
+}
+
+// getHostFirewallStatus records the source ranges written to the host's
+// firewall when the exit server is created.
+func getHostFirewallStatus(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1.Service) *inletsv1alpha1.FirewallStatus {
+	if getFirewaller(c, tunnel) != nil {
+		return nil
+	}
+
+	rules, err := getFirewallRules(tunnel, service)
+	if err != nil || len(rules.SourceRanges) == 0 {
+		return nil
+	}
+
+	return &inletsv1alpha1.FirewallStatus{
+		Ports:        rules.Ports,
+		SourceRanges: rules.SourceRanges,
+	}
+}
+
+// syncFirewall applies the Service's source ranges to the exit server's
+// cloud firewall, creating, updating or removing it as needed.
+func syncFirewall(tunnel *inletsv1alpha1.Tunnel, service *corev1.Service, c *Controller) error {
+	rules, err := getFirewallRules(tunnel, service)
+	if err != nil {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ErrFirewall, "%s", err)
+		return nil
+	}
+
+	// The exit server may be shared with other Services
+	rules.Ports = getSharedPorts(c, tunnel, rules)
+
+	current := tunnel.Status.Firewall
+
+	fw := getFirewaller(c, tunnel)
+	if fw == nil {
+		// The status records the rules written when the exit server was
+		// created, and is never updated, since they cannot be changed
+		outOfDate := !rules.equal(current)
+		if current == nil {
+			outOfDate = len(rules.SourceRanges) > 0
+		}
+		_, err := c.setFirewallOutOfDate(tunnel, outOfDate)
+		return err
+	}
+
+	if hostFirewallRequired(c, tunnel) {
+		outOfDate := current != nil && formatSourceRanges(rules.SourceRanges) != formatSourceRanges(current.SourceRanges)
+		if tunnel, err = c.setFirewallOutOfDate(tunnel, outOfDate); err != nil {
+			return err
+		}
+	}
+
+	if len(rules.SourceRanges) == 0 && !fw.OpensPorts() {
+		if current == nil {
+			return nil
+		}
+
+		if len(current.ID) > 0 {
+			c.tunnelLogger(tunnel).Info("Removing firewall, no source ranges set", "firewall", current.ID)
+			if err := fw.Delete(current.ID); err != nil {
+				return fmt.Errorf("error deleting firewall %s: %s", current.ID, err)
+			}
+		}
+
+		return c.updateTunnelFirewallStatus(tunnel, nil)
+	}
+
+	if rules.equal(current) {
+		return nil
+	}
+
+	id := ""
+	if current != nil {
+		id = current.ID
+	}
+
+	id, err = fw.Apply(tunnel.Namespace, tunnel.Name, id, tunnel.Status.HostID, rules)
+	if err != nil {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ErrFirewall, "%s", err)
+		return fmt.Errorf("error applying firewall for %s.%s: %s", tunnel.Name, tunnel.Namespace, err)
+	}
+
+	msg := fmt.Sprintf("Firewall %s opens ports %s to: %s", id, formatPorts(rules.Ports), formatSourceRanges(rules.SourceRanges))
+	c.tunnelLogger(tunnel).Info("Applied firewall",
+		"firewall", id,
+		"ports", formatPorts(rules.Ports),
+		"sourceRanges", formatSourceRanges(rules.SourceRanges))
+	c.recordEvent(tunnel, corev1.EventTypeNormal, FirewallApplied, "%s", msg)
+
+	return c.updateTunnelFirewallStatus(tunnel, &inletsv1alpha1.FirewallStatus{
+		ID:           id,
+		Ports:        rules.Ports,
+		SourceRanges: rules.SourceRanges,
+	})
+}
+
+// setFirewallOutOfDate sets the FirewallOutOfDate condition when the
+// source ranges written to the exit server's own firewall no longer match
+// the Service's, and clears it once they match again. It returns the
+// updated Tunnel.
+func (c *Controller) setFirewallOutOfDate(tunnel *inletsv1alpha1.Tunnel, outOfDate bool) (*inletsv1alpha1.Tunnel, error) {
+	condition := metav1.Condition{
+		Type:               inletsv1alpha1.TunnelFirewallOutOfDate,
+		Status:             metav1.ConditionFalse,
+		Reason:             "FirewallUpToDate",
+		Message:            "The exit server's firewall matches the Service's source ranges",
+		ObservedGeneration: tunnel.Generation,
+	}
+	if outOfDate {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "RecreateRequired"
+		condition.Message = fmt.Sprintf("Source ranges for provider %s are written to the exit server when it is created, delete the Tunnel to apply changes",
+			c.config().Provider)
+	} else if meta.FindStatusCondition(tunnel.Status.Conditions, inletsv1alpha1.TunnelFirewallOutOfDate) == nil {
+		return tunnel, nil
+	}
+
+	copy := tunnel.DeepCopy()
+	if !meta.SetStatusCondition(&copy.Status.Conditions, condition) {
+		return tunnel, nil
+	}
+	if outOfDate {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ErrFirewall, "%s", condition.Message)
+	}
+
+	updated, err := c.operatorclientset.OperatorV1alpha1().
+		Tunnels(tunnel.Namespace).
+		UpdateStatus(context.Background(), copy, metav1.UpdateOptions{})
+	if err != nil {
+		return tunnel, fmt.Errorf("tunnel %s.%s condition update error: %s", tunnel.Name, tunnel.Namespace, err)
+	}
+	return updated, nil
+}
+
+// deleteFirewall removes the cloud firewall for a Tunnel that is being
+// deleted.
+func deleteFirewall(tunnel *inletsv1alpha1.Tunnel, c *Controller) error {
+	if tunnel.Status.Firewall == nil || len(tunnel.Status.Firewall.ID) == 0 {
+		return nil
+	}
+
+	fw := getFirewaller(c, tunnel)
+	if fw == nil {
+		return nil
+	}
+
+	return fw.Delete(tunnel.Status.Firewall.ID)
+}
+
+func formatPorts(ports []int32) string {
+	res := []string{}
+	for _, p := range ports {
+		res = append(res, strconv.Itoa(int(p)))
+	}
+	return strings.Join(res, ", ")
+}
+
+func formatSourceRanges(ranges []string) string {
+	if len(ranges) == 0 {
+		return "all"
+	}
+	return strings.Join(ranges, ", ")
+}
+
+func (c *Controller) updateTunnelFirewallStatus(tunnel *inletsv1alpha1.Tunnel, status *inletsv1alpha1.FirewallStatus) error {
+	tunnelCopy := tunnel.DeepCopy()
+	tunnelCopy.Status.Firewall = status
+
+	_, err := c.operatorclientset.OperatorV1alpha1().
+		Tunnels(tunnel.Namespace).
+		UpdateStatus(context.Background(), tunnelCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// digitalOceanFirewaller manages DigitalOcean Cloud Firewalls
+type digitalOceanFirewaller struct {
+	client *godo.Client
+}
+
+func (f *digitalOceanFirewaller) Apply(namespace, name, id, hostID string, rules firewallRules) (string, error) {
+	dropletID, err := strconv.Atoi(hostID)
+	if err != nil {
+		return "", fmt.Errorf("invalid droplet ID: %s", hostID)
+	}
+
+	all := []string{"0.0.0.0/0", "::/0"}
+
+	req := &godo.FirewallRequest{
+		Name:       name,
+		DropletIDs: []int{dropletID},
+		InboundRules: []godo.InboundRule{
+			{
+				Protocol:  "tcp",
+				PortRange: strconv.Itoa(inletsPROControlPort),
+				Sources:   &godo.Sources{Addresses: all},
+			},
+		},
+		OutboundRules: []godo.OutboundRule{
+			{Protocol: "tcp", PortRange: "all", Destinations: &godo.Destinations{Addresses: all}},
+			{Protocol: "udp", PortRange: "all", Destinations: &godo.Destinations{Addresses: all}},
+			{Protocol: "icmp", Destinations: &godo.Destinations{Addresses: all}},
+		},
+	}
+
+	for _, port := range rules.Ports {
+		req.InboundRules = append(req.InboundRules, godo.InboundRule{
+			Protocol:  "tcp",
+			PortRange: strconv.Itoa(int(port)),
+			Sources:   &godo.Sources{Addresses: rules.SourceRanges},
+		})
+	}
+
+	if len(id) > 0 {
+		res, _, err := f.client.Firewalls.Update(context.Background(), id, req)
+		if err != nil {
+			return "", err
+		}
+		return res.ID, nil
+	}
+
+	res, _, err := f.client.Firewalls.Create(context.Background(), req)
+	if err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+func (f *digitalOceanFirewaller) Delete(id string) error {
+	_, err := f.client.Firewalls.Delete(context.Background(), id)
+	return err
+}
+
+func (f *digitalOceanFirewaller) OpensPorts() bool {
+	return false
+}
+
+// hetznerFirewaller manages Hetzner Cloud Firewalls
+type hetznerFirewaller struct {
+	client *hcloud.Client
+}
+
+func (f *hetznerFirewaller) Apply(namespace, name, id, hostID string, rules firewallRules) (string, error) {
+	serverID, err := strconv.Atoi(hostID)
+	if err != nil {
+		return "", fmt.Errorf("invalid server ID: %s", hostID)
+	}
+
+	fwRules, err := makeHetznerFirewallRules(rules)
+	if err != nil {
+		return "", err
+	}
+
+	if len(id) > 0 {
+		fw, err := f.getFirewall(id)
+		if err != nil {
+			return "", err
+		}
+
+		if _, _, err := f.client.Firewall.SetRules(context.Background(), fw, hcloud.FirewallSetRulesOpts{
+			Rules: fwRules,
+		}); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	res, _, err := f.client.Firewall.Create(context.Background(), hcloud.FirewallCreateOpts{
+		Name:  name,
+		Rules: fwRules,
+		ApplyTo: []hcloud.FirewallResource{
+			{
+				Type:   hcloud.FirewallResourceTypeServer,
+				Server: &hcloud.FirewallResourceServer{ID: serverID},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(res.Firewall.ID), nil
+}
+
+func (f *hetznerFirewaller) Delete(id string) error {
+	fw, err := f.getFirewall(id)
+	if err != nil {
+		return err
+	}
+
+	// A firewall must be removed from its servers before it can be deleted
+	if len(fw.AppliedTo) > 0 {
+		actions, _, err := f.client.Firewall.RemoveResources(context.Background(), fw, fw.AppliedTo)
+		if err != nil {
+			return err
+		}
+		if err := f.client.Action.WaitFor(context.Background(), actions...); err != nil {
+			return err
+		}
+	}
+
+	_, err = f.client.Firewall.Delete(context.Background(), fw)
+	return err
+}
+
+func (f *hetznerFirewaller) OpensPorts() bool {
+	return false
+}
+
+func (f *hetznerFirewaller) getFirewall(id string) (*hcloud.Firewall, error) {
+	fw, _, err := f.client.Firewall.Get(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	if fw == nil {
+		return nil, fmt.Errorf("firewall %s not found", id)
+	}
+	return fw, nil
+}
+
+func makeHetznerFirewallRules(rules firewallRules) ([]hcloud.FirewallRule, error) {
+	_, all4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, all6, _ := net.ParseCIDR("::/0")
+
+	controlPort := strconv.Itoa(inletsPROControlPort)
+	res := []hcloud.FirewallRule{
+		{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocolTCP,
+			Port:      &controlPort,
+			SourceIPs: []net.IPNet{*all4, *all6},
+		},
+	}
+
+	sources := []net.IPNet{}
+	for _, r := range rules.SourceRanges {
+		_, cidr, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, *cidr)
+	}
+
+	for _, p := range rules.Ports {
+		port := strconv.Itoa(int(p))
+		res = append(res, hcloud.FirewallRule{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocolTCP,
+			Port:      &port,
+			SourceIPs: sources,
+		})
+	}
+
+	return res, nil
+}
+
+// ec2Firewaller manages the security group which the provisioner creates
+// for each EC2 instance, its ingress rules are replaced with the
+// Service's ports, and it is deleted along with the instance.
+type ec2Firewaller struct {
+	client *ec2.EC2
+}
+
+// ec2Permission is a single port opened to a single CIDR.
+type ec2Permission struct {
+	Port int64
+	CIDR string
+}
+
+func (f *ec2Firewaller) Apply(namespace, name, id, hostID string, rules firewallRules) (string, error) {
+	if len(id) == 0 {
+		groupID, err := f.getInstanceGroup(hostID)
+		if err != nil {
+			return "", err
+		}
+		id = groupID
+	}
+
+	res, err := f.client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(id)},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res.SecurityGroups) == 0 {
+		return "", fmt.Errorf("security group %s not found", id)
+	}
+
+	current := flattenEC2Permissions(res.SecurityGroups[0].IpPermissions)
+	add, remove := diffEC2Permissions(current, makeEC2Permissions(rules))
+
+	// Rules are added before any are removed, so that ports which are
+	// not changing stay reachable.
+	if len(add) > 0 {
+		if _, err := f.client.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(id),
+			IpPermissions: toEC2IpPermissions(add),
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	if len(remove) > 0 {
+		if _, err := f.client.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(id),
+			IpPermissions: toEC2IpPermissions(remove),
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// Delete is a no-op, the security group cannot be deleted while the
+// instance is running, and is deleted by the provisioner once the instance
+// has been terminated.
+func (f *ec2Firewaller) Delete(id string) error {
+	return nil
+}
+
+func (f *ec2Firewaller) OpensPorts() bool {
+	return true
+}
+
+// getInstanceGroup finds the security group created for the instance by
+// the provisioner.
+func (f *ec2Firewaller) getInstanceGroup(instanceID string) (string, error) {
+	res, err := f.client.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range res.Reservations {
+		for _, i := range r.Instances {
+			for _, g := range i.SecurityGroups {
+				if strings.HasPrefix(aws.StringValue(g.GroupName), "inlets-") {
+					return aws.StringValue(g.GroupId), nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no inlets security group found for instance %s", instanceID)
+}
+
+// makeEC2Permissions opens the control port to all, and the Service's
+// ports to its source ranges, or to all when none are set.
+func makeEC2Permissions(rules firewallRules) []ec2Permission {
+	all := []string{"0.0.0.0/0", "::/0"}
+
+	res := []ec2Permission{}
+	for _, cidr := range all {
+		res = append(res, ec2Permission{Port: int64(inletsPROControlPort), CIDR: cidr})
+	}
+
+	ranges := rules.SourceRanges
+	if len(ranges) == 0 {
+		ranges = all
+	}
+
+	for _, port := range rules.Ports {
+		if port == inletsPROControlPort {
+			continue
+		}
+		for _, cidr := range ranges {
+			res = append(res, ec2Permission{Port: int64(port), CIDR: cidr})
+		}
+	}
+
+	return res
+}
+
+func flattenEC2Permissions(perms []*ec2.IpPermission) []ec2Permission {
+	res := []ec2Permission{}
+	for _, p := range perms {
+		if aws.StringValue(p.IpProtocol) != "tcp" || aws.Int64Value(p.FromPort) != aws.Int64Value(p.ToPort) {
+			continue
+		}
+		port := aws.Int64Value(p.FromPort)
+		for _, r := range p.IpRanges {
+			res = append(res, ec2Permission{Port: port, CIDR: aws.StringValue(r.CidrIp)})
+		}
+		for _, r := range p.Ipv6Ranges {
+			res = append(res, ec2Permission{Port: port, CIDR: aws.StringValue(r.CidrIpv6)})
+		}
+	}
+	return res
+}
+
+// diffEC2Permissions returns the permissions to add and to remove to get
+// from current to desired.
+func diffEC2Permissions(current, desired []ec2Permission) (add, remove []ec2Permission) {
+	currentSet := map[ec2Permission]bool{}
+	for _, p := range current {
+		currentSet[p] = true
+	}
+	desiredSet := map[ec2Permission]bool{}
+	for _, p := range desired {
+		desiredSet[p] = true
+	}
+
+	for _, p := range desired {
+		if !currentSet[p] {
+			add = append(add, p)
+		}
+	}
+	for _, p := range current {
+		if !desiredSet[p] {
+			remove = append(remove, p)
+		}
+	}
+	return add, remove
+}
+
+func toEC2IpPermissions(perms []ec2Permission) []*ec2.IpPermission {
+	res := []*ec2.IpPermission{}
+	for _, p := range perms {
+		perm := &ec2.IpPermission{
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int64(p.Port),
+			ToPort:     aws.Int64(p.Port),
+		}
+		if strings.Contains(p.CIDR, ":") {
+			perm.Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: aws.String(p.CIDR)}}
+		} else {
+			perm.IpRanges = []*ec2.IpRange{{CidrIp: aws.String(p.CIDR)}}
+		}
+		res = append(res, perm)
+	}
+	return res
+}
+
+// gceFirewaller manages a firewall rule for each GCE instance, which
+// targets a network tag of the same name as the rule. The shared
+// "inlets" firewall rule created by the provisioner only opens the control
+// port.
+type gceFirewaller struct {
+	client    *compute.Service
+	projectID string
+}
+
+func (f *gceFirewaller) Apply(namespace, name, id, hostID string, rules firewallRules) (string, error) {
+	instanceName, zone, projectID, err := parseGCEHostID(hostID)
+	if err != nil {
+		return "", err
+	}
+
+	fw := makeGCEFirewall(gceFirewallName(namespace, name, instanceName), projectID, rules)
+
+	if id == fw.Name {
+		if _, err := f.client.Firewalls.Update(projectID, id, fw).Do(); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	if _, err := f.client.Firewalls.Insert(projectID, fw).Do(); err != nil {
+		if !isGCEStatus(err, http.StatusConflict) {
+			return "", err
+		}
+		if _, err := f.client.Firewalls.Update(projectID, fw.Name, fw).Do(); err != nil {
+			return "", err
+		}
+	}
+
+	if err := f.tagInstance(projectID, zone, instanceName, fw.Name); err != nil {
+		return "", err
+	}
+
+	// Rules created before the name included the namespace are replaced
+	if len(id) > 0 {
+		if err := f.Delete(id); err != nil {
+			return "", fmt.Errorf("error deleting firewall rule %s: %s", id, err)
+		}
+	}
+
+	return fw.Name, nil
+}
+
+func (f *gceFirewaller) Delete(id string) error {
+	_, err := f.client.Firewalls.Delete(f.projectID, id).Do()
+	if err != nil && isGCEStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (f *gceFirewaller) OpensPorts() bool {
+	return true
+}
+
+// tagInstance adds the firewall rule's name to the instance's network
+// tags, so that it is targeted by the rule.
+func (f *gceFirewaller) tagInstance(projectID, zone, instanceName, tag string) error {
+	instance, err := f.client.Instances.Get(projectID, zone, instanceName).Do()
+	if err != nil {
+		return err
+	}
+
+	tags := &compute.Tags{}
+	if instance.Tags != nil {
+		tags = instance.Tags
+	}
+	if containsString(tags.Items, tag) {
+		return nil
+	}
+
+	tags.Items = append(tags.Items, tag)
+	_, err = f.client.Instances.SetTags(projectID, zone, instanceName, tags).Do()
+	return err
+}
+
+// gceFirewallName returns the name of the firewall rule, and its target
+// tag, for the Tunnel's instance. Firewall rules are global to the
+// project, so a hash of the Tunnel's namespace and name is added, so that
+// Tunnels of the same name in other namespaces do not share a rule.
+func gceFirewallName(namespace, name, instanceName string) string {
+	h := fnv.New32a()
+	h.Write([]byte(namespace + "/" + name))
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+
+	// Names are limited to 63 characters, and cannot end with "-"
+	if max := 63 - len(suffix); len(instanceName) > max {
+		instanceName = strings.TrimRight(instanceName[:max], "-")
+	}
+	return instanceName + suffix
+}
+
+// makeGCEFirewall opens the Service's ports to its source ranges, or to
+// all when none are set.
+func makeGCEFirewall(name, projectID string, rules firewallRules) *compute.Firewall {
+	ports := []string{}
+	for _, p := range rules.Ports {
+		ports = append(ports, strconv.Itoa(int(p)))
+	}
+
+	ranges := rules.SourceRanges
+	if len(ranges) == 0 {
+		ranges = []string{"0.0.0.0/0"}
+	}
+
+	return &compute.Firewall{
+		Name:        name,
+		Description: "Firewall rule for the inlets tunnel " + name + " created by inlets-operator",
+		Network:     fmt.Sprintf("projects/%s/global/networks/default", projectID),
+		Allowed: []*compute.FirewallAllowed{
+			{
+				IPProtocol: "tcp",
+				Ports:      ports,
+			},
+		},
+		SourceRanges: ranges,
+		Direction:    "INGRESS",
+		TargetTags:   []string{name},
+	}
+}
+
+// parseGCEHostID reads the fields of the host ID given by the GCE
+// provisioner, in the form: name|zone|project|region
+func parseGCEHostID(hostID string) (instanceName, zone, projectID string, err error) {
+	fields := strings.Split(hostID, "|")
+	if len(fields) != 4 {
+		return "", "", "", fmt.Errorf("invalid GCE host ID: %s", hostID)
+	}
+	return fields[0], fields[1], fields[2], nil
+}
+
+func isGCEStatus(err error, code int) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code == code
+	}
+	return false
+}
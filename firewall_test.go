@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+)
+
+func Test_getSourceRanges_FromSpec(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				sourceRangesAnnotation: "10.0.0.0/8",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			LoadBalancerSourceRanges: []string{"203.0.113.10/32", "192.168.1.7/24"},
+		},
+	}
+
+	got, err := getSourceRanges(svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"192.168.1.0/24", "203.0.113.10/32"}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_getSourceRanges_FromAnnotation(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				sourceRangesAnnotation: "10.0.0.0/8, 2001:db8::/32",
+			},
+		},
+	}
+
+	got, err := getSourceRanges(svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"10.0.0.0/8", "2001:db8::/32"}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_getSourceRanges_Invalid(t *testing.T) {
+	svc := &corev1.Service{
+		Spec: corev1.ServiceSpec{
+			LoadBalancerSourceRanges: []string{"203.0.113.10"},
+		},
+	}
+
+	if _, err := getSourceRanges(svc); err == nil {
+		t.Fatalf("want error for a range without a prefix length")
+	}
+}
+
+func Test_firewallRules_equal(t *testing.T) {
+	rules := firewallRules{
+		Ports:        []int32{80, 443},
+		SourceRanges: []string{"10.0.0.0/8"},
+	}
+
+	if rules.equal(nil) {
+		t.Fatalf("want rules to differ from no status")
+	}
+
+	status := &inletsv1alpha1.FirewallStatus{
+		ID:           "fw-1",
+		Ports:        []int32{80, 443},
+		SourceRanges: []string{"10.0.0.0/8"},
+	}
+	if !rules.equal(status) {
+		t.Fatalf("want rules to equal status")
+	}
+
+	status.Ports = []int32{80}
+	if rules.equal(status) {
+		t.Fatalf("want rules to differ when ports change")
+	}
+}
+
+func Test_makeHostFirewallUserdata(t *testing.T) {
+	got := makeHostFirewallUserdata(firewallRules{
+		Ports:        []int32{443},
+		SourceRanges: []string{"10.0.0.0/8", "2001:db8::/32"},
+	})
+
+	for _, want := range []string{
+		"iptables -A INPUT -p tcp --dport 443 -s 10.0.0.0/8 -j ACCEPT\n",
+		"ip6tables -A INPUT -p tcp --dport 443 -s 2001:db8::/32 -j ACCEPT\n",
+		"iptables -A INPUT -p tcp --dport 443 -j DROP\n",
+		"ip6tables -A INPUT -p tcp --dport 443 -j DROP\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want user-data to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	if strings.Contains(got, "8123") {
+		t.Errorf("control port must not be restricted, got:\n%s", got)
+	}
+}
+
+func Test_makeHostFirewallUserdata_NoRanges(t *testing.T) {
+	got := makeHostFirewallUserdata(firewallRules{Ports: []int32{443}})
+	if got != "" {
+		t.Fatalf("want no user-data without source ranges, got: %q", got)
+	}
+}
+
+func Test_makeEC2Permissions_NoRanges(t *testing.T) {
+	got := makeEC2Permissions(firewallRules{Ports: []int32{80, 8123}})
+
+	want := []ec2Permission{
+		{Port: 8123, CIDR: "0.0.0.0/0"},
+		{Port: 8123, CIDR: "::/0"},
+		{Port: 80, CIDR: "0.0.0.0/0"},
+		{Port: 80, CIDR: "::/0"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_makeEC2Permissions_WithRanges(t *testing.T) {
+	got := makeEC2Permissions(firewallRules{
+		Ports:        []int32{443},
+		SourceRanges: []string{"203.0.113.0/24"},
+	})
+
+	want := []ec2Permission{
+		{Port: 8123, CIDR: "0.0.0.0/0"},
+		{Port: 8123, CIDR: "::/0"},
+		{Port: 443, CIDR: "203.0.113.0/24"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_diffEC2Permissions_PortAdded(t *testing.T) {
+	current := []ec2Permission{
+		{Port: 8123, CIDR: "0.0.0.0/0"},
+		{Port: 80, CIDR: "0.0.0.0/0"},
+		{Port: 22, CIDR: "0.0.0.0/0"},
+	}
+	desired := []ec2Permission{
+		{Port: 8123, CIDR: "0.0.0.0/0"},
+		{Port: 80, CIDR: "0.0.0.0/0"},
+		{Port: 443, CIDR: "0.0.0.0/0"},
+	}
+
+	add, remove := diffEC2Permissions(current, desired)
+
+	wantAdd := []ec2Permission{{Port: 443, CIDR: "0.0.0.0/0"}}
+	if !reflect.DeepEqual(wantAdd, add) {
+		t.Errorf("add: want %v, but got %v", wantAdd, add)
+	}
+	wantRemove := []ec2Permission{{Port: 22, CIDR: "0.0.0.0/0"}}
+	if !reflect.DeepEqual(wantRemove, remove) {
+		t.Errorf("remove: want %v, but got %v", wantRemove, remove)
+	}
+}
+
+func Test_diffEC2Permissions_Unchanged(t *testing.T) {
+	perms := makeEC2Permissions(firewallRules{Ports: []int32{80, 443}})
+
+	add, remove := diffEC2Permissions(perms, perms)
+	if len(add) > 0 || len(remove) > 0 {
+		t.Fatalf("want no changes, but got add: %v, remove: %v", add, remove)
+	}
+}
+
+func Test_makeUserData_GCEClosesSharedPorts(t *testing.T) {
+	c := &Controller{infraConfig: newConfigStore(&InfraConfig{
+		Provider:  "gce",
+		ProjectID: "inlets",
+		Zone:      "us-central1-a",
+		ProConfig: InletsProConfig{SkipChecksums: true},
+	})}
+	tunnel := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", Namespace: "default"},
+		Spec: inletsv1alpha1.TunnelSpec{
+			ServiceRef: &inletsv1alpha1.ResourceRef{Name: "nginx"},
+		},
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Ports:                    []corev1.ServicePort{{Port: 80}, {Port: 443}},
+			LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+		},
+	}
+
+	got, err := makeUserData(c, tunnel, service, "secret", "0.9.40")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The shared "inlets" rule opens 80 and 443 to all, so only the host's
+	// firewall keeps them closed
+	for _, port := range []string{"80", "443"} {
+		for _, want := range []string{
+			"iptables -A INPUT -p tcp --dport " + port + " -s 10.0.0.0/8 -j ACCEPT\n",
+			"iptables -A INPUT -p tcp --dport " + port + " -j DROP\n",
+			"ip6tables -A INPUT -p tcp --dport " + port + " -j DROP\n",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("want user-data to contain %q, got:\n%s", want, got)
+			}
+		}
+	}
+}
+
+func Test_gceFirewallName(t *testing.T) {
+	name := gceFirewallName("default", "nginx-tunnel", "nginx-tunnel")
+	if !strings.HasPrefix(name, "nginx-tunnel-") || len(name) != len("nginx-tunnel-")+8 {
+		t.Errorf("want nginx-tunnel- and a hash, but got %s", name)
+	}
+	if other := gceFirewallName("staging", "nginx-tunnel", "nginx-tunnel"); other == name {
+		t.Errorf("want a different name in another namespace, but got %s for both", name)
+	}
+
+	long := gceFirewallName("default", "nginx-tunnel", strings.Repeat("a", 53)+"-"+strings.Repeat("b", 9))
+	if len(long) > 63 || strings.Contains(long, "--") {
+		t.Errorf("want a valid name of at most 63 characters, but got %s", long)
+	}
+}
+
+func Test_makeGCEFirewall(t *testing.T) {
+	fw := makeGCEFirewall("nginx-tunnel", "my-project", firewallRules{Ports: []int32{80, 443}})
+
+	if fw.Name != "nginx-tunnel" {
+		t.Errorf("want name %s, but got %s", "nginx-tunnel", fw.Name)
+	}
+	if !reflect.DeepEqual([]string{"nginx-tunnel"}, fw.TargetTags) {
+		t.Errorf("want target tags %v, but got %v", []string{"nginx-tunnel"}, fw.TargetTags)
+	}
+	if !reflect.DeepEqual([]string{"80", "443"}, fw.Allowed[0].Ports) {
+		t.Errorf("want ports %v, but got %v", []string{"80", "443"}, fw.Allowed[0].Ports)
+	}
+	if !reflect.DeepEqual([]string{"0.0.0.0/0"}, fw.SourceRanges) {
+		t.Errorf("want source ranges %v, but got %v", []string{"0.0.0.0/0"}, fw.SourceRanges)
+	}
+}
+
+func Test_parseGCEHostID(t *testing.T) {
+	name, zone, project, err := parseGCEHostID("nginx-tunnel|us-central1-a|my-project|us-central1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if name != "nginx-tunnel" || zone != "us-central1-a" || project != "my-project" {
+		t.Fatalf("want nginx-tunnel, us-central1-a, my-project, but got %s, %s, %s", name, zone, project)
+	}
+
+	if _, _, _, err := parseGCEHostID("nginx-tunnel"); err == nil {
+		t.Fatalf("want error for invalid host ID")
+	}
+}
+
+func Test_syncFirewall_HostFirewallOutOfDate(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Ports:                    []corev1.ServicePort{{Port: 80}},
+			LoadBalancerSourceRanges: []string{"203.0.113.0/24"},
+		},
+	}
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Status.Firewall = &inletsv1alpha1.FirewallStatus{Ports: []int32{80}, SourceRanges: []string{"198.51.100.0/24"}}
+
+	client := fake.NewSimpleClientset(tunnel)
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		operatorclientset: client,
+		infraConfig:       newConfigStore(&InfraConfig{Provider: "linode"}),
+		recorder:          recorder,
+	}
+
+	if err := syncFirewall(tunnel, service, c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	updated, err := client.OperatorV1alpha1().Tunnels("default").Get(context.Background(), "nginx-tunnel", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !meta.IsStatusConditionTrue(updated.Status.Conditions, inletsv1alpha1.TunnelFirewallOutOfDate) {
+		t.Fatalf("want %s to be True, but got %v", inletsv1alpha1.TunnelFirewallOutOfDate, updated.Status.Conditions)
+	}
+	if events := readEvents(recorder); len(events) != 1 {
+		t.Errorf("want 1 Event, but got %v", events)
+	}
+
+	// Once recorded, the condition is not written again
+	client.ClearActions()
+	if err := syncFirewall(updated, service, c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("want no further updates, but got %d actions", len(client.Actions()))
+	}
+
+	// Restoring the ranges clears it
+	service.Spec.LoadBalancerSourceRanges = []string{"198.51.100.0/24"}
+	if err := syncFirewall(updated, service, c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	updated, _ = client.OperatorV1alpha1().Tunnels("default").Get(context.Background(), "nginx-tunnel", metav1.GetOptions{})
+	if !meta.IsStatusConditionFalse(updated.Status.Conditions, inletsv1alpha1.TunnelFirewallOutOfDate) {
+		t.Errorf("want %s to be False, but got %v", inletsv1alpha1.TunnelFirewallOutOfDate, updated.Status.Conditions)
+	}
+}
@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	provision "github.com/inlets/cloud-provision/provision"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+const (
+	// gatewayControllerName is set as spec.controllerName on a GatewayClass
+	// to have the operator create exit servers for its Gateways
+	gatewayControllerName = "operator.inlets.dev/gateway-controller"
+
+	// gatewayServiceAnnotation on a Gateway names the Service in its
+	// namespace for the in-cluster Gateway implementation, which the
+	// listener ports are tunnelled to. Otherwise the GatewayClass's
+	// parametersRef is used, when it is a Service.
+	gatewayServiceAnnotation = "operator.inlets.dev/gateway-service"
+
+	// gatewayTunnelSuffix is added to a Gateway's name for its Tunnel
+	gatewayTunnelSuffix = "-gateway-tunnel"
+)
+
+var (
+	gatewayGroupVersion = schema.GroupVersion{Group: "gateway.networking.k8s.io", Version: "v1"}
+
+	gatewayClassesResource = gatewayGroupVersion.WithResource("gatewayclasses")
+	gatewaysResource       = gatewayGroupVersion.WithResource("gateways")
+)
+
+// gatewayClass and gateway mirror the fields of the Gateway API used by
+// the operator, to avoid depending on sigs.k8s.io/gateway-api.
+type gatewayClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec struct {
+		ControllerName string                `json:"controllerName"`
+		ParametersRef  *gatewayParametersRef `json:"parametersRef,omitempty"`
+	} `json:"spec"`
+
+	Status struct {
+		Conditions []metav1.Condition `json:"conditions,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+type gatewayParametersRef struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type gateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec struct {
+		GatewayClassName string            `json:"gatewayClassName"`
+		Listeners        []gatewayListener `json:"listeners"`
+	} `json:"spec"`
+
+	Status gatewayStatus `json:"status,omitempty"`
+}
+
+type gatewayListener struct {
+	Name     string `json:"name"`
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+type gatewayStatus struct {
+	Addresses  []gatewayStatusAddress `json:"addresses,omitempty"`
+	Conditions []metav1.Condition     `json:"conditions,omitempty"`
+}
+
+type gatewayStatusAddress struct {
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+// gatewayController creates a Tunnel for each Gateway of a GatewayClass
+// with gatewayControllerName. The Tunnel forwards the Gateway's listener
+// ports to the Service of the in-cluster Gateway implementation, and the
+// exit server's IP is written to the Gateway's status.addresses.
+type gatewayController struct {
+	controller *Controller
+	client     dynamic.Interface
+
+	classLister    cache.GenericLister
+	gatewayListers map[string]cache.GenericLister
+	synced         []cache.InformerSynced
+
+	workqueue workqueue.RateLimitingInterface
+}
+
+// newGatewayController watches GatewayClasses, and Gateways in each of the
+// namespaces, the returned factories must be started.
+func newGatewayController(c *Controller, client dynamic.Interface, namespaced []namespaceInformers) (*gatewayController, []dynamicinformer.DynamicSharedInformerFactory) {
+	g := &gatewayController{
+		controller:     c,
+		client:         client,
+		gatewayListers: map[string]cache.GenericLister{},
+		workqueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Gateways"),
+	}
+
+	clusterFactory := dynamicinformer.NewDynamicSharedInformerFactory(client, time.Second*30)
+	classes := clusterFactory.ForResource(gatewayClassesResource)
+	g.classLister = classes.Lister()
+	g.synced = append(g.synced, classes.Informer().HasSynced)
+	classes.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    g.enqueueClass,
+		UpdateFunc: func(old, new interface{}) { g.enqueueClass(new) },
+	})
+
+	factories := []dynamicinformer.DynamicSharedInformerFactory{clusterFactory}
+	for _, n := range namespaced {
+		factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, time.Second*30, n.Namespace, nil)
+		gateways := factory.ForResource(gatewaysResource)
+		g.gatewayListers[n.Namespace] = gateways.Lister()
+		g.synced = append(g.synced, gateways.Informer().HasSynced)
+		gateways.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    g.enqueue,
+			UpdateFunc: func(old, new interface{}) { g.enqueue(new) },
+		})
+
+		// Tunnels update the Gateway which owns them, i.e. once the exit
+		// server has an IP
+		n.Tunnels.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    g.enqueueTunnelOwner,
+			UpdateFunc: func(old, new interface{}) { g.enqueueTunnelOwner(new) },
+		})
+
+		factories = append(factories, factory)
+	}
+
+	return g, factories
+}
+
+func (g *gatewayController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	g.workqueue.AddRateLimited(key)
+}
+
+// enqueueClass queues the GatewayClass, whose key has no namespace, and
+// each of its Gateways.
+func (g *gatewayController) enqueueClass(obj interface{}) {
+	class, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	g.enqueue(class)
+
+	for _, l := range g.gatewayListers {
+		items, err := l.List(labels.Everything())
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		for _, item := range items {
+			if u, ok := item.(*unstructured.Unstructured); ok {
+				className, _, _ := unstructured.NestedString(u.Object, "spec", "gatewayClassName")
+				if className == class.GetName() {
+					g.enqueue(u)
+				}
+			}
+		}
+	}
+}
+
+func (g *gatewayController) enqueueTunnelOwner(obj interface{}) {
+	tunnel, ok := obj.(*inletsv1alpha1.Tunnel)
+	if !ok {
+		return
+	}
+	if ref := metav1.GetControllerOf(tunnel); ref != nil && ref.Kind == "Gateway" {
+		g.workqueue.AddRateLimited(tunnel.Namespace + "/" + ref.Name)
+	}
+}
+
+func (g *gatewayController) getGateway(namespace, name string) (runtime.Object, error) {
+	if l, ok := g.gatewayListers[namespace]; ok {
+		return l.ByNamespace(namespace).Get(name)
+	}
+	if l, ok := g.gatewayListers[""]; ok {
+		return l.ByNamespace(namespace).Get(name)
+	}
+	return nil, errors.NewNotFound(gatewaysResource.GroupResource(), name)
+}
+
+// getClass returns the GatewayClass with name, as read and decoded, or
+// nil when it does not exist or belongs to another controller.
+func (g *gatewayController) getClass(name string) (*unstructured.Unstructured, *gatewayClass, error) {
+	obj, err := g.classLister.Get(name)
+	if errors.IsNotFound(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	class := &gatewayClass{}
+	u, err := fromUnstructured(obj, class)
+	if err != nil {
+		return nil, nil, err
+	}
+	if class.Spec.ControllerName != gatewayControllerName {
+		return nil, nil, nil
+	}
+	return u, class, nil
+}
+
+// Run processes Gateways until stopCh is closed.
+func (g *gatewayController) Run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer g.workqueue.ShutDown()
+
+	slog.Info("Starting Gateway controller", "controllerName", gatewayControllerName)
+	if ok := cache.WaitForCacheSync(stopCh, append(g.synced, g.controller.tunnelsSynced)...); !ok {
+		return fmt.Errorf("failed to wait for Gateway caches to sync")
+	}
+
+	go wait.Until(func() {
+		for g.processNextWorkItem() {
+		}
+	}, time.Second, stopCh)
+
+	<-stopCh
+	return nil
+}
+
+func (g *gatewayController) processNextWorkItem() bool {
+	obj, shutdown := g.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer g.workqueue.Done(obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		g.workqueue.Forget(obj)
+		utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+
+	if err := g.syncHandler(key); err != nil {
+		g.workqueue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("error syncing '%s': %s, requeuing", key, err))
+		return true
+	}
+
+	g.workqueue.Forget(obj)
+	return true
+}
+
+func (g *gatewayController) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	// GatewayClasses are cluster-scoped
+	if len(namespace) == 0 {
+		u, class, err := g.getClass(name)
+		if err != nil || class == nil {
+			return err
+		}
+		return g.acceptClass(u, class)
+	}
+
+	obj, err := g.getGateway(namespace, name)
+	if errors.IsNotFound(err) {
+		// The Tunnel is deleted by the garbage collector
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	gw := &gateway{}
+	u, err := fromUnstructured(obj, gw)
+	if err != nil {
+		return err
+	}
+	if !g.controller.namespaceAllowed(gw.Namespace) {
+		return nil
+	}
+
+	_, class, err := g.getClass(gw.Spec.GatewayClassName)
+	if err != nil {
+		return err
+	}
+	if class == nil {
+		// The Gateway may have been moved to another class
+		return g.deleteTunnel(gw)
+	}
+
+	service := getGatewayService(gw, class)
+	if len(service) == 0 {
+		return g.updateStatus(u, gw, nil, metav1.Condition{
+			Type:    "Accepted",
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidParameters",
+			Message: fmt.Sprintf("Annotate the Gateway with %s to name the Service for the Gateway implementation", gatewayServiceAnnotation),
+		})
+	}
+
+	ports, invalid := getGatewayPorts(gw, g.lookupGatewayService(gw, service))
+	if len(ports) == 0 {
+		msg := "The Gateway has no TCP listeners to tunnel"
+		if len(invalid) > 0 {
+			msg += ": " + strings.Join(invalid, ", ")
+		}
+		return g.updateStatus(u, gw, nil, metav1.Condition{
+			Type:    "Accepted",
+			Status:  metav1.ConditionFalse,
+			Reason:  "ListenersNotValid",
+			Message: msg,
+		})
+	}
+
+	tunnel, err := g.syncTunnel(gw, service, ports)
+	if err != nil {
+		return err
+	}
+
+	// The Gateway is still accepted when only some of its listeners are
+	// invalid, as the Gateway API allows
+	accepted := metav1.Condition{
+		Type:    "Accepted",
+		Status:  metav1.ConditionTrue,
+		Reason:  "Accepted",
+		Message: fmt.Sprintf("Tunnelling ports %s to Service %s", formatPorts(ports), service),
+	}
+	if len(invalid) > 0 {
+		accepted.Reason = "ListenersNotValid"
+		accepted.Message += ", ignoring " + strings.Join(invalid, ", ")
+	}
+
+	return g.updateStatus(u, gw, tunnel, accepted)
+}
+
+// acceptClass sets the GatewayClass's Accepted condition, obj is the
+// GatewayClass as read.
+func (g *gatewayController) acceptClass(obj *unstructured.Unstructured, class *gatewayClass) error {
+	if !meta.SetStatusCondition(&class.Status.Conditions, metav1.Condition{
+		Type:               "Accepted",
+		Status:             metav1.ConditionTrue,
+		Reason:             "Accepted",
+		Message:            "Exit servers are created by the inlets-operator",
+		ObservedGeneration: class.Generation,
+	}) {
+		return nil
+	}
+
+	u, err := setStatus(obj, &class.Status)
+	if err != nil {
+		return err
+	}
+	_, err = g.client.Resource(gatewayClassesResource).
+		UpdateStatus(context.Background(), u, metav1.UpdateOptions{})
+	return err
+}
+
+// getGatewayService returns the name of the Service for the Gateway
+// implementation, from the Gateway's annotation or else the class's
+// parametersRef. The Service must be in the Gateway's namespace.
+func getGatewayService(gw *gateway, class *gatewayClass) string {
+	if v := gw.Annotations[gatewayServiceAnnotation]; len(v) > 0 {
+		return v
+	}
+
+	ref := class.Spec.ParametersRef
+	if ref == nil || ref.Kind != "Service" || (ref.Group != "" && ref.Group != "core") {
+		return ""
+	}
+	if len(ref.Namespace) > 0 && ref.Namespace != gw.Namespace {
+		return ""
+	}
+	return ref.Name
+}
+
+// getGatewayPorts returns the ports of the Gateway's listeners, in order,
+// and why any listeners were left out: UDP cannot be tunnelled, and the
+// port must be one of the Service's, when it has been found.
+func getGatewayPorts(gw *gateway, service *corev1.Service) ([]int32, []string) {
+	servicePorts := map[int32]bool{}
+	if service != nil {
+		for _, p := range service.Spec.Ports {
+			servicePorts[p.Port] = true
+		}
+	}
+
+	seen := map[int32]bool{}
+	ports := []int32{}
+	invalid := []string{}
+	for _, l := range gw.Spec.Listeners {
+		switch {
+		case l.Protocol == "UDP":
+			invalid = append(invalid, fmt.Sprintf("listener %s uses UDP, only TCP can be tunnelled", l.Name))
+		case service != nil && !servicePorts[l.Port]:
+			invalid = append(invalid, fmt.Sprintf("listener %s port %d is not a port of Service %s", l.Name, l.Port, service.Name))
+		case !seen[l.Port]:
+			seen[l.Port] = true
+			ports = append(ports, l.Port)
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports, invalid
+}
+
+// lookupGatewayService returns the Gateway's Service from the cache, or
+// nil when it has not been found, and the Tunnel reports it missing.
+func (g *gatewayController) lookupGatewayService(gw *gateway, name string) *corev1.Service {
+	if g.controller.serviceLister == nil {
+		return nil
+	}
+	service, err := g.controller.serviceLister.Services(gw.Namespace).Get(name)
+	if err != nil {
+		return nil
+	}
+	return service
+}
+
+// makeGatewayTunnel returns the Tunnel for the Gateway, owned by it so
+// that it is deleted along with the Gateway.
+func makeGatewayTunnel(gw *gateway, service string, ports []int32) *inletsv1alpha1.Tunnel {
+	return &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gw.Name + gatewayTunnelSuffix,
+			Namespace: gw.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(gw, gatewayGroupVersion.WithKind("Gateway")),
+			},
+		},
+		Spec: inletsv1alpha1.TunnelSpec{
+			ServiceRef: &inletsv1alpha1.ResourceRef{
+				Name:      service,
+				Namespace: gw.Namespace,
+			},
+			Ports: ports,
+		},
+	}
+}
+
+// deleteTunnel deletes the Tunnel created for the Gateway, if there is one.
+func (g *gatewayController) deleteTunnel(gw *gateway) error {
+	tunnel, err := g.controller.tunnelsLister.Tunnels(gw.Namespace).Get(gw.Name + gatewayTunnelSuffix)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ref := metav1.GetControllerOf(tunnel); ref == nil || ref.UID != gw.UID {
+		return nil
+	}
+
+	g.controller.tunnelLogger(tunnel).Info("Deleting Tunnel, the Gateway's class is not managed by the operator", "gateway", gw.Name)
+	err = g.controller.operatorclientset.OperatorV1alpha1().
+		Tunnels(gw.Namespace).
+		Delete(context.Background(), tunnel.Name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// syncTunnel creates the Gateway's Tunnel, or updates its ports to match
+// the Gateway. The serviceRef of a Tunnel cannot be changed once its exit
+// server exists, so the Tunnel is deleted and created again when the
+// Gateway's Service changes.
+func (g *gatewayController) syncTunnel(gw *gateway, service string, ports []int32) (*inletsv1alpha1.Tunnel, error) {
+	want := makeGatewayTunnel(gw, service, ports)
+	tunnels := g.controller.operatorclientset.OperatorV1alpha1().Tunnels(gw.Namespace)
+
+	tunnel, err := g.controller.tunnelsLister.Tunnels(gw.Namespace).Get(want.Name)
+	if err == nil && metav1.IsControlledBy(tunnel, gw) &&
+		(tunnel.Spec.ServiceRef == nil || tunnel.Spec.ServiceRef.Name != service) {
+		g.controller.tunnelLogger(tunnel).Info("Deleting Tunnel, the Gateway's Service has changed", "gateway", gw.Name, logKeyService, service)
+		if err := tunnels.Delete(context.Background(), tunnel.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("error deleting tunnel %s.%s: %s", tunnel.Name, tunnel.Namespace, err)
+		}
+		err = errors.NewNotFound(inletsv1alpha1.Resource("tunnels"), tunnel.Name)
+	}
+
+	if errors.IsNotFound(err) {
+		slog.Info("Creating Tunnel for Gateway", logKeyTunnel, want.Name, logKeyNamespace, gw.Namespace,
+			"gateway", gw.Name, logKeyService, service, "ports", formatPorts(ports))
+
+		created, err := tunnels.Create(context.Background(), want, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error creating tunnel %s.%s: %s", want.Name, gw.Namespace, err)
+		}
+
+		copy := created.DeepCopy()
+		copy.Status.Generated = true
+		if _, err := tunnels.UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
+			g.controller.tunnelLogger(copy).Error("Error updating Tunnel status", logKeyError, err)
+		}
+		return created, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if ref := metav1.GetControllerOf(tunnel); ref == nil || ref.UID != gw.UID {
+		return nil, fmt.Errorf("tunnel %s.%s exists and is not owned by Gateway %s", tunnel.Name, tunnel.Namespace, gw.Name)
+	}
+
+	if formatPorts(tunnel.Spec.Ports) == formatPorts(ports) {
+		return tunnel, nil
+	}
+
+	copy := tunnel.DeepCopy()
+	copy.Spec.Ports = ports
+	g.controller.tunnelLogger(copy).Info("Updating Tunnel for Gateway", "gateway", gw.Name, "ports", formatPorts(ports))
+
+	return tunnels.Update(context.Background(), copy, metav1.UpdateOptions{})
+}
+
+// updateStatus writes the exit server's IP to the Gateway's addresses and
+// sets its conditions, the Gateway is Programmed once the exit server is
+// active. obj is the Gateway as read, so that fields which are not
+// mirrored are kept.
+func (g *gatewayController) updateStatus(obj *unstructured.Unstructured, gw *gateway, tunnel *inletsv1alpha1.Tunnel, accepted metav1.Condition) error {
+	status := gatewayStatus{
+		Conditions: append([]metav1.Condition(nil), gw.Status.Conditions...),
+	}
+
+	programmed := metav1.Condition{
+		Type:    "Programmed",
+		Status:  metav1.ConditionFalse,
+		Reason:  "Pending",
+		Message: "Waiting for the exit server to be created",
+	}
+	if tunnel != nil && tunnel.Status.HostStatus == provision.ActiveStatus && len(tunnel.Status.HostIP) > 0 {
+		status.Addresses = []gatewayStatusAddress{{Type: "IPAddress", Value: tunnel.Status.HostIP}}
+		programmed.Status = metav1.ConditionTrue
+		programmed.Reason = "Programmed"
+		programmed.Message = fmt.Sprintf("Exit server %s is active", tunnel.Status.HostID)
+	} else if accepted.Status != metav1.ConditionTrue {
+		programmed.Reason = "Invalid"
+		programmed.Message = accepted.Message
+	}
+
+	accepted.ObservedGeneration = gw.Generation
+	programmed.ObservedGeneration = gw.Generation
+	meta.SetStatusCondition(&status.Conditions, accepted)
+	meta.SetStatusCondition(&status.Conditions, programmed)
+
+	if equalGatewayStatus(gw.Status, status) {
+		return nil
+	}
+
+	u, err := setStatus(obj, &status)
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.client.Resource(gatewaysResource).
+		Namespace(gw.Namespace).
+		UpdateStatus(context.Background(), u, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating gateway %s.%s status: %s", gw.Name, gw.Namespace, err)
+	}
+
+	if programmed.Status == metav1.ConditionTrue && !meta.IsStatusConditionTrue(gw.Status.Conditions, "Programmed") {
+		g.controller.recorder.Eventf(u, corev1.EventTypeNormal, GatewayProgrammed,
+			"Published %s for Gateway %s", tunnel.Status.HostIP, gw.Name)
+	}
+	return nil
+}
+
+// equalGatewayStatus compares the addresses and conditions, ignoring the
+// conditions' transition times.
+func equalGatewayStatus(a, b gatewayStatus) bool {
+	if len(a.Addresses) != len(b.Addresses) || len(a.Conditions) != len(b.Conditions) {
+		return false
+	}
+	for i := range a.Addresses {
+		if a.Addresses[i] != b.Addresses[i] {
+			return false
+		}
+	}
+	for _, c := range a.Conditions {
+		other := meta.FindStatusCondition(b.Conditions, c.Type)
+		if other == nil || other.Status != c.Status || other.Reason != c.Reason ||
+			other.Message != c.Message || other.ObservedGeneration != c.ObservedGeneration {
+			return false
+		}
+	}
+	return true
+}
+
+// fromUnstructured decodes obj into one of the mirrored types, and
+// returns obj.
+func fromUnstructured(obj runtime.Object, into interface{}) (*unstructured.Unstructured, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("expected unstructured object but got %T", obj)
+	}
+	return u, runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, into)
+}
+
+// setStatus returns a copy of obj with the fields of status, a pointer to
+// a mirrored status, written over its status. Other fields are kept.
+func setStatus(obj *unstructured.Unstructured, status interface{}) (*unstructured.Unstructured, error) {
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return nil, err
+	}
+
+	u := obj.DeepCopy()
+	current, _, _ := unstructured.NestedMap(u.Object, "status")
+	if current == nil {
+		current = map[string]interface{}{}
+	}
+	for k, v := range fields {
+		current[k] = v
+	}
+	if _, ok := fields["addresses"]; !ok {
+		delete(current, "addresses")
+	}
+
+	if err := unstructured.SetNestedMap(u.Object, current, "status"); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
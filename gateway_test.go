@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+	listers "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1alpha1"
+)
+
+func makeTestGatewayClass(controllerName string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "GatewayClass",
+		"metadata":   map[string]interface{}{"name": "inlets"},
+		"spec": map[string]interface{}{
+			"controllerName": controllerName,
+			"parametersRef": map[string]interface{}{
+				"group":     "",
+				"kind":      "Service",
+				"name":      "envoy",
+				"namespace": "default",
+			},
+		},
+	}}
+}
+
+func makeTestGateway() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata": map[string]interface{}{
+			"name":      "web",
+			"namespace": "default",
+			"uid":       "1234",
+		},
+		"spec": map[string]interface{}{
+			"gatewayClassName": "inlets",
+			"listeners": []interface{}{
+				map[string]interface{}{"name": "https", "port": int64(443), "protocol": "HTTPS"},
+				map[string]interface{}{"name": "http", "port": int64(80), "protocol": "HTTP"},
+				map[string]interface{}{"name": "quic", "port": int64(443), "protocol": "UDP"},
+			},
+		},
+		"status": map[string]interface{}{
+			"listeners": []interface{}{},
+		},
+	}}
+}
+
+func makeTestGatewayController(class, gw *unstructured.Unstructured) (*gatewayController, cache.Indexer) {
+	classes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	classes.Add(class)
+	gateways := newNamespaceIndexer()
+	gateways.Add(gw)
+	tunnels := newNamespaceIndexer()
+
+	c := &Controller{
+		operatorclientset: fake.NewSimpleClientset(),
+		tunnelsLister:     listers.NewTunnelLister(tunnels),
+		recorder:          record.NewFakeRecorder(10),
+	}
+
+	// The fake client would guess "gatewaies" from the kind
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		gatewayClassesResource: "GatewayClassList",
+		gatewaysResource:       "GatewayList",
+	})
+	client.Tracker().Create(gatewayClassesResource, class, "")
+	client.Tracker().Create(gatewaysResource, gw, gw.GetNamespace())
+
+	return &gatewayController{
+		controller:  c,
+		client:      client,
+		classLister: cache.NewGenericLister(classes, gatewayClassesResource.GroupResource()),
+		gatewayListers: map[string]cache.GenericLister{
+			"": cache.NewGenericLister(gateways, gatewaysResource.GroupResource()),
+		},
+	}, tunnels
+}
+
+func getTestGatewayStatus(t *testing.T, g *gatewayController) (gatewayStatus, []interface{}) {
+	u, err := g.client.Resource(gatewaysResource).Namespace("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	gw := &gateway{}
+	if _, err := fromUnstructured(u, gw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	listeners, _, _ := unstructured.NestedSlice(u.Object, "status", "listeners")
+	return gw.Status, listeners
+}
+
+func Test_getGatewayPorts_SkipsUDPAndDuplicates(t *testing.T) {
+	gw := &gateway{}
+	if _, err := fromUnstructured(makeTestGateway(), gw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ports, invalid := getGatewayPorts(gw, nil)
+	if got := formatPorts(ports); got != "80, 443" {
+		t.Errorf("want ports 80, 443, but got %s", got)
+	}
+	if len(invalid) != 1 || !strings.Contains(invalid[0], "quic") {
+		t.Errorf("want the UDP listener to be reported, but got %v", invalid)
+	}
+}
+
+func Test_getGatewayPorts_NotOnService(t *testing.T) {
+	gw := &gateway{}
+	if _, err := fromUnstructured(makeTestGateway(), gw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "envoy", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 443}}},
+	}
+
+	ports, invalid := getGatewayPorts(gw, service)
+	if got := formatPorts(ports); got != "443" {
+		t.Errorf("want ports 443, but got %s", got)
+	}
+	if len(invalid) != 2 || !strings.Contains(invalid[0], "port 80 is not a port of Service envoy") {
+		t.Errorf("want the UDP and http listeners to be reported, but got %v", invalid)
+	}
+}
+
+func Test_getGatewayService_Annotation(t *testing.T) {
+	gw := &gateway{}
+	gw.Namespace = "default"
+	gw.Annotations = map[string]string{gatewayServiceAnnotation: "traefik"}
+
+	class := &gatewayClass{}
+	class.Spec.ParametersRef = &gatewayParametersRef{Kind: "Service", Name: "envoy"}
+
+	if got := getGatewayService(gw, class); got != "traefik" {
+		t.Errorf("want traefik, but got %s", got)
+	}
+}
+
+func Test_getGatewayService_ParametersRefOtherNamespace(t *testing.T) {
+	gw := &gateway{}
+	gw.Namespace = "default"
+
+	class := &gatewayClass{}
+	class.Spec.ParametersRef = &gatewayParametersRef{Kind: "Service", Name: "envoy", Namespace: "envoy-gateway-system"}
+
+	if got := getGatewayService(gw, class); got != "" {
+		t.Errorf("want no Service, but got %s", got)
+	}
+}
+
+func Test_gatewaySyncHandler_CreatesTunnel(t *testing.T) {
+	g, _ := makeTestGatewayController(makeTestGatewayClass(gatewayControllerName), makeTestGateway())
+
+	if err := g.syncHandler("default/web"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tunnel, err := g.controller.operatorclientset.OperatorV1alpha1().Tunnels("default").
+		Get(context.Background(), "web"+gatewayTunnelSuffix, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("want Tunnel for the Gateway, but got: %s", err)
+	}
+	if tunnel.Spec.ServiceRef == nil || tunnel.Spec.ServiceRef.Name != "envoy" {
+		t.Errorf("want Tunnel for Service envoy, but got %v", tunnel.Spec.ServiceRef)
+	}
+	if got := formatPorts(tunnel.Spec.Ports); got != "80, 443" {
+		t.Errorf("want ports 80, 443, but got %s", got)
+	}
+	if ref := metav1.GetControllerOf(tunnel); ref == nil || ref.Kind != "Gateway" || ref.Name != "web" {
+		t.Errorf("want Tunnel owned by Gateway web, but got %v", ref)
+	}
+
+	status, listeners := getTestGatewayStatus(t, g)
+	if !meta.IsStatusConditionTrue(status.Conditions, "Accepted") {
+		t.Errorf("want Gateway to be Accepted, but got %v", status.Conditions)
+	}
+	if meta.IsStatusConditionTrue(status.Conditions, "Programmed") {
+		t.Errorf("want Gateway not Programmed before the exit server is active")
+	}
+	if len(status.Addresses) != 0 {
+		t.Errorf("want no addresses, but got %v", status.Addresses)
+	}
+	if listeners == nil {
+		t.Errorf("want status.listeners to be kept")
+	}
+}
+
+func Test_gatewaySyncHandler_PublishesAddress(t *testing.T) {
+	g, tunnels := makeTestGatewayController(makeTestGatewayClass(gatewayControllerName), makeTestGateway())
+
+	gw := &gateway{}
+	fromUnstructured(makeTestGateway(), gw)
+	tunnel := makeGatewayTunnel(gw, "envoy", []int32{80, 443})
+	tunnel.Status = inletsv1alpha1.TunnelStatus{HostStatus: "active", HostID: "5678", HostIP: "192.0.2.10"}
+	tunnels.Add(tunnel)
+
+	if err := g.syncHandler("default/web"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	status, _ := getTestGatewayStatus(t, g)
+	if len(status.Addresses) != 1 || status.Addresses[0].Value != "192.0.2.10" || status.Addresses[0].Type != "IPAddress" {
+		t.Errorf("want address 192.0.2.10, but got %v", status.Addresses)
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, "Programmed") {
+		t.Errorf("want Gateway to be Programmed, but got %v", status.Conditions)
+	}
+}
+
+func Test_gatewaySyncHandler_OtherController(t *testing.T) {
+	g, _ := makeTestGatewayController(makeTestGatewayClass("example.com/gateway-controller"), makeTestGateway())
+
+	if err := g.syncHandler("default/web"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tunnels, _ := g.controller.operatorclientset.OperatorV1alpha1().Tunnels("default").
+		List(context.Background(), metav1.ListOptions{})
+	if len(tunnels.Items) != 0 {
+		t.Errorf("want no Tunnels for another controller's Gateway, but got %d", len(tunnels.Items))
+	}
+}
+
+func Test_gatewaySyncHandler_AcceptsClass(t *testing.T) {
+	g, _ := makeTestGatewayController(makeTestGatewayClass(gatewayControllerName), makeTestGateway())
+
+	if err := g.syncHandler("inlets"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	u, _ := g.client.Resource(gatewayClassesResource).Get(context.Background(), "inlets", metav1.GetOptions{})
+	class := &gatewayClass{}
+	fromUnstructured(u, class)
+	if !meta.IsStatusConditionTrue(class.Status.Conditions, "Accepted") {
+		t.Errorf("want GatewayClass to be Accepted, but got %v", class.Status.Conditions)
+	}
+}
+
+func Test_gatewaySyncHandler_ServiceChangedRecreatesTunnel(t *testing.T) {
+	gwObj := makeTestGateway()
+	gwObj.SetAnnotations(map[string]string{gatewayServiceAnnotation: "traefik"})
+	g, tunnels := makeTestGatewayController(makeTestGatewayClass(gatewayControllerName), gwObj)
+
+	gw := &gateway{}
+	fromUnstructured(gwObj, gw)
+	tunnel := makeGatewayTunnel(gw, "envoy", []int32{80, 443})
+	tunnel.Status = inletsv1alpha1.TunnelStatus{HostStatus: "active", HostID: "5678", HostIP: "192.0.2.10"}
+	tunnels.Add(tunnel)
+	g.controller.operatorclientset = fake.NewSimpleClientset(tunnel)
+
+	if err := g.syncHandler("default/web"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := g.controller.operatorclientset.OperatorV1alpha1().Tunnels("default").
+		Get(context.Background(), "web"+gatewayTunnelSuffix, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("want Tunnel for the Gateway, but got: %s", err)
+	}
+	if got.Spec.ServiceRef == nil || got.Spec.ServiceRef.Name != "traefik" {
+		t.Errorf("want Tunnel for Service traefik, but got %v", got.Spec.ServiceRef)
+	}
+	if got.Status.HostID != "" {
+		t.Errorf("want a new Tunnel without the old exit server, but got host %s", got.Status.HostID)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	clientset "github.com/inlets/inlets-operator/pkg/generated/clientset/versioned"
+	listers "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1alpha1"
+)
+
+// ErrReferenceNotPermitted is used as part of the Event 'reason' when a
+// Tunnel references a Service in another namespace, and no TunnelGrant
+// there allows it
+const ErrReferenceNotPermitted = "ErrReferenceNotPermitted"
+
+// getServiceNamespace returns the namespace of the Tunnel's Service, the
+// Tunnel's own unless its ServiceRef gives another.
+func getServiceNamespace(tunnel *inletsv1alpha1.Tunnel) string {
+	if ref := tunnel.Spec.ServiceRef; ref != nil && len(ref.Namespace) > 0 {
+		return ref.Namespace
+	}
+	return tunnel.Namespace
+}
+
+// getUpstreamService returns the Service and namespace an upstream names
+// when it is a Service's DNS name in another namespace, i.e.
+// <name>.<namespace>, <name>.<namespace>.svc or
+// <name>.<namespace>.svc.<cluster domain>. The client's Pod resolves
+// <name>.<namespace> within the cluster before trying it as an external
+// host name, so it is treated as a Service too.
+func getUpstreamService(upstream string) (name, namespace string, ok bool) {
+	if net.ParseIP(upstream) != nil {
+		return "", "", false
+	}
+
+	parts := strings.Split(upstream, ".")
+	if len(parts) == 2 || (len(parts) > 2 && parts[2] == "svc") {
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
+
+// getReferencedService returns the Service a Tunnel forwards to, from its
+// ServiceRef, or its upstream when that names a Service. ok is false for
+// an upstream outside the cluster.
+func getReferencedService(tunnel *inletsv1alpha1.Tunnel) (name, namespace string, ok bool) {
+	if ref := tunnel.Spec.ServiceRef; ref != nil {
+		return ref.Name, getServiceNamespace(tunnel), true
+	}
+	return getUpstreamService(tunnel.Spec.Upstream)
+}
+
+// grantAllows returns whether the grant lets Tunnels in namespace
+// reference the Service.
+func grantAllows(grant *inletsv1alpha1.TunnelGrant, namespace, service string) bool {
+	from := false
+	for _, f := range grant.Spec.From {
+		if f.Namespace == namespace {
+			from = true
+			break
+		}
+	}
+	if !from {
+		return false
+	}
+
+	if len(grant.Spec.To) == 0 {
+		return true
+	}
+	for _, t := range grant.Spec.To {
+		if t.Name == service {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceGranted returns whether a TunnelGrant in namespace lets Tunnels
+// in from reference the Service. It reads the grants from the API, for
+// the webhook, which has no informers.
+func serviceGranted(ctx context.Context, client clientset.Interface, namespace, from, service string) (bool, error) {
+	grants, err := client.OperatorV1alpha1().
+		TunnelGrants(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("error listing TunnelGrants in %s: %s", namespace, err)
+	}
+
+	for i := range grants.Items {
+		if grantAllows(&grants.Items[i], from, service) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// referenceGranted returns whether the Tunnel may use its Service. A
+// Service in another namespace, given by its ServiceRef or the DNS name in
+// its upstream, needs a TunnelGrant in that namespace which allows the
+// Tunnel's namespace, so that a Tunnel can not expose a Service its owner
+// has no access to.
+func referenceGranted(grantsLister listers.TunnelGrantLister, tunnel *inletsv1alpha1.Tunnel) (bool, error) {
+	service, namespace, ok := getReferencedService(tunnel)
+	if !ok || namespace == tunnel.Namespace {
+		return true, nil
+	}
+
+	grants, err := grantsLister.TunnelGrants(namespace).List(labels.Everything())
+	if err != nil {
+		return false, fmt.Errorf("error listing TunnelGrants in %s: %s", namespace, err)
+	}
+
+	for _, grant := range grants {
+		if grantAllows(grant, tunnel.Namespace, service) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// enqueueGrantTunnels enqueues the Tunnels which reference a Service in
+// the namespace of a TunnelGrant that was added, changed or deleted, so
+// that access is given or revoked without waiting for a resync.
+func (c *Controller) enqueueGrantTunnels(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	grant, ok := obj.(*inletsv1alpha1.TunnelGrant)
+	if !ok {
+		return
+	}
+
+	tunnels, err := c.tunnelsLister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+
+	for _, t := range tunnels {
+		if _, namespace, ok := getReferencedService(t); ok && t.Namespace != grant.Namespace && namespace == grant.Namespace {
+			c.enqueueTunnel(t)
+		}
+	}
+}
+
+// revokeClientDeployment deletes the client of a Tunnel whose TunnelGrant
+// has been removed, so that the Service is no longer exposed. The exit
+// server is kept, and the client is created again if access is granted.
+func revokeClientDeployment(tunnel *inletsv1alpha1.Tunnel, c *Controller) error {
+	ref := tunnel.Status.ClientDeploymentRef
+	if ref == nil || ref.Name == "" {
+		return nil
+	}
+
+	err := c.kubeclientset.AppsV1().
+		Deployments(tunnel.Namespace).
+		Delete(context.Background(), ref.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting client deployment: %s", err)
+	}
+
+	c.tunnelLogger(tunnel).Info("Deleted tunnel client, access to the Service was revoked", "deployment", ref.Name)
+
+	copy := tunnel.DeepCopy()
+	copy.Status.ClientDeploymentRef = nil
+	if _, err := c.operatorclientset.OperatorV1alpha1().
+		Tunnels(tunnel.Namespace).
+		UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("tunnel update error %s", err)
+	}
+	return nil
+}
+
+// syncReferenceGrant checks the Tunnel may use its Service, and records
+// an Event on the Tunnel when it may not. The Event is not recorded on the
+// Service, since the Tunnel's owner may not be able to read it.
+func syncReferenceGrant(tunnel *inletsv1alpha1.Tunnel, c *Controller) (bool, error) {
+	granted, err := referenceGranted(c.grantsLister, tunnel)
+	if err != nil || granted {
+		return granted, err
+	}
+
+	service, namespace, _ := getReferencedService(tunnel)
+	c.tunnelLogger(tunnel).Warn("No TunnelGrant allows the reference to the Service", "serviceNamespace", namespace)
+	c.recorder.Eventf(tunnel, corev1.EventTypeWarning, ErrReferenceNotPermitted,
+		"No TunnelGrant in namespace %s allows Tunnels from %s to reference Service %s",
+		namespace, tunnel.Namespace, service)
+
+	return false, revokeClientDeployment(tunnel, c)
+}
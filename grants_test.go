@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+	listers "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1alpha1"
+)
+
+func makeTunnelGrant(from string, to ...string) *inletsv1alpha1.TunnelGrant {
+	grant := &inletsv1alpha1.TunnelGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+		Spec: inletsv1alpha1.TunnelGrantSpec{
+			From: []inletsv1alpha1.TunnelGrantFrom{{Namespace: from}},
+		},
+	}
+	for _, name := range to {
+		grant.Spec.To = append(grant.Spec.To, inletsv1alpha1.TunnelGrantTo{Name: name})
+	}
+	return grant
+}
+
+func makeGrantsLister(grants ...*inletsv1alpha1.TunnelGrant) listers.TunnelGrantLister {
+	indexer := newNamespaceIndexer()
+	for _, g := range grants {
+		indexer.Add(g)
+	}
+	return listers.NewTunnelGrantLister(indexer)
+}
+
+func Test_grantAllows(t *testing.T) {
+	cases := []struct {
+		name  string
+		grant *inletsv1alpha1.TunnelGrant
+		want  bool
+	}{
+		{name: "any service", grant: makeTunnelGrant("edge"), want: true},
+		{name: "named service", grant: makeTunnelGrant("edge", "redis", "nginx"), want: true},
+		{name: "other service", grant: makeTunnelGrant("edge", "redis"), want: false},
+		{name: "other namespace", grant: makeTunnelGrant("team-a"), want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := grantAllows(tc.grant, "edge", "nginx"); got != tc.want {
+				t.Fatalf("want %t, but got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_referenceGranted_SameNamespace(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+
+	granted, err := referenceGranted(makeGrantsLister(), tunnel)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !granted {
+		t.Fatalf("want a Service in the Tunnel's namespace to need no grant")
+	}
+}
+
+func Test_referenceGranted_OtherNamespace(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "default"
+
+	granted, err := referenceGranted(makeGrantsLister(), tunnel)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if granted {
+		t.Fatalf("want a Service in another namespace to need a grant")
+	}
+
+	granted, err = referenceGranted(makeGrantsLister(makeTunnelGrant("edge")), tunnel)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !granted {
+		t.Fatalf("want the TunnelGrant to allow the reference")
+	}
+}
+
+func Test_getUpstreamService(t *testing.T) {
+	cases := []struct {
+		upstream  string
+		name      string
+		namespace string
+		ok        bool
+	}{
+		{upstream: "nginx"},
+		{upstream: "192.168.0.10"},
+		{upstream: "db.example.com"},
+		{upstream: "nginx.default", name: "nginx", namespace: "default", ok: true},
+		{upstream: "nginx.default.svc", name: "nginx", namespace: "default", ok: true},
+		{upstream: "nginx.default.svc.cluster.local", name: "nginx", namespace: "default", ok: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.upstream, func(t *testing.T) {
+			name, namespace, ok := getUpstreamService(tc.upstream)
+			if name != tc.name || namespace != tc.namespace || ok != tc.ok {
+				t.Fatalf("want %s.%s (%t), but got %s.%s (%t)", tc.name, tc.namespace, tc.ok, name, namespace, ok)
+			}
+		})
+	}
+}
+
+func Test_referenceGranted_Upstream(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "")
+	tunnel.Spec.Upstream = "nginx.default.svc"
+
+	granted, err := referenceGranted(makeGrantsLister(), tunnel)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if granted {
+		t.Fatalf("want an upstream Service in another namespace to need a grant")
+	}
+
+	granted, err = referenceGranted(makeGrantsLister(makeTunnelGrant("edge", "nginx")), tunnel)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !granted {
+		t.Fatalf("want the TunnelGrant to allow the upstream")
+	}
+
+	tunnel.Spec.Upstream = "192.168.0.10"
+	granted, err = referenceGranted(makeGrantsLister(), tunnel)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !granted {
+		t.Fatalf("want an upstream outside the cluster to need no grant")
+	}
+}
+
+func Test_getUpstream_OtherNamespace(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "default"
+
+	if got := getUpstream(tunnel); got != "nginx.default" {
+		t.Fatalf("want nginx.default, but got %s", got)
+	}
+}
+
+func Test_syncReferenceGrant_RevokesClient(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "default"
+	tunnel.Status.ClientDeploymentRef = &inletsv1alpha1.ResourceRef{Name: "nginx-tunnel-client", Namespace: "edge"}
+
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		kubeclientset: kubefake.NewSimpleClientset(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel-client", Namespace: "edge"},
+		}),
+		operatorclientset: fake.NewSimpleClientset(tunnel),
+		grantsLister:      makeGrantsLister(),
+		recorder:          recorder,
+	}
+
+	granted, err := syncReferenceGrant(tunnel, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if granted {
+		t.Fatalf("want the reference not to be granted")
+	}
+
+	_, err = c.kubeclientset.AppsV1().Deployments("edge").Get(context.Background(), "nginx-tunnel-client", metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("want the client deployment to be deleted, but got: %v", err)
+	}
+
+	got, _ := c.operatorclientset.OperatorV1alpha1().Tunnels("edge").Get(context.Background(), "nginx-tunnel", metav1.GetOptions{})
+	if got.Status.ClientDeploymentRef != nil {
+		t.Errorf("want clientDeploymentRef cleared, but got %v", got.Status.ClientDeploymentRef)
+	}
+
+	if events := readEvents(recorder); len(events) != 1 {
+		t.Errorf("want 1 event on the Tunnel, but got %q", events)
+	}
+}
+
+func Test_updateService_OtherNamespace(t *testing.T) {
+	c, _ := makeEventsController(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+	})
+	c.grantsLister = makeGrantsLister(makeTunnelGrant("edge"))
+
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "default"
+	tunnel.Spec.UpdateServiceIP = true
+
+	if err := c.updateService(tunnel, "192.0.2.10"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	service, _ := c.kubeclientset.CoreV1().Services("default").Get(context.Background(), "nginx", metav1.GetOptions{})
+	if len(service.Spec.ExternalIPs) != 1 || service.Spec.ExternalIPs[0] != "192.0.2.10" {
+		t.Errorf("want externalIPs [192.0.2.10], but got %v", service.Spec.ExternalIPs)
+	}
+	if len(service.Status.LoadBalancer.Ingress) != 1 || service.Status.LoadBalancer.Ingress[0].IP != "192.0.2.10" {
+		t.Errorf("want ingress 192.0.2.10, but got %v", service.Status.LoadBalancer.Ingress)
+	}
+}
+
+func Test_updateService_OtherNamespaceNotGranted(t *testing.T) {
+	c, _ := makeEventsController(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+	})
+	c.grantsLister = makeGrantsLister()
+
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "default"
+	tunnel.Spec.UpdateServiceIP = true
+
+	if err := c.updateService(tunnel, "192.0.2.10"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	service, _ := c.kubeclientset.CoreV1().Services("default").Get(context.Background(), "nginx", metav1.GetOptions{})
+	if len(service.Spec.ExternalIPs) != 0 {
+		t.Errorf("want no externalIPs without a TunnelGrant, but got %v", service.Spec.ExternalIPs)
+	}
+}
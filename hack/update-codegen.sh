@@ -32,7 +32,7 @@ chmod +x ${CODEGEN_PKG}/generate-groups.sh
 
 ${CODEGEN_PKG}/generate-groups.sh all \
     github.com/inlets/inlets-operator/pkg/generated github.com/inlets/inlets-operator/pkg/apis \
-    inletsoperator:v1alpha1 \
+    inletsoperator:v1alpha1,v1beta1 \
     --output-base "${TEMP_DIR}" \
     --go-header-file ${SCRIPT_ROOT}/hack/boilerplate.go.txt
 
@@ -0,0 +1,19 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// makeTestTunnel returns a Tunnel for the Service, or with no ServiceRef
+// when service is empty, for tests to add the fields they need.
+func makeTestTunnel(name, namespace, service string) *inletsv1alpha1.Tunnel {
+	tunnel := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+	if len(service) > 0 {
+		tunnel.Spec.ServiceRef = &inletsv1alpha1.ResourceRef{Name: service}
+	}
+	return tunnel
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/digitalocean/godo"
+	provision "github.com/inlets/cloud-provision/provision"
+	"github.com/linode/linodego"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+const (
+	// HostReplaced is used as part of the Event 'reason' when the exit
+	// server of a Tunnel was found missing or stopped, and is replaced
+	HostReplaced = "HostReplaced"
+)
+
+// hostFailureThreshold is the number of checks in a row a host must be
+// found missing or stopped before it is replaced, so that a single bad
+// response from the provider does not cause a new exit server.
+const hostFailureThreshold = 2
+
+// hostHealth is the state of an active exit server, as reported by the
+// provider.
+type hostHealth string
+
+const (
+	hostHealthy hostHealth = "healthy"
+	hostMissing hostHealth = "missing"
+	hostStopped hostHealth = "stopped"
+	// hostUnknown is returned for errors which do not show that the host
+	// is gone, such as a timeout, and for states such as rebooting.
+	hostUnknown hostHealth = "unknown"
+	// hostFake is a host from -dry-run, which is replaced with a real one
+	// once -dry-run is turned off.
+	hostFake hostHealth = "fake"
+)
+
+// stoppedHostStatuses are the statuses providers report for a host which
+// has been powered off or is being removed.
+var stoppedHostStatuses = map[string]bool{
+	"off":           true,
+	"archive":       true,
+	"stopped":       true,
+	"stopping":      true,
+	"shutting_down": true,
+	"shutting-down": true,
+	"terminated":    true,
+	"deleting":      true,
+	"offline":       true,
+}
+
+// missingHostErrors are the prefixes of the errors created by the
+// provision package when a host does not exist, which have no type, or
+// format the client's error so that it cannot be unwrapped.
+var missingHostErrors = []string{
+	"failed to find server with id ",               // hetzner
+	"cannot describe host: ",                       // ec2
+	"could not get instance: googleapi: Error 404", // gce
+}
+
+// ec2InstanceNotFound is the code of the error EC2 returns for an
+// instance ID that does not exist
+const ec2InstanceNotFound = "InvalidInstanceID.NotFound"
+
+// isHostNotFound returns whether err is the provider's answer that a host
+// does not exist. The clients' errors are matched by their type and
+// status code, so that any other 404, i.e. from a firewall, is not taken
+// for a missing host.
+func isHostNotFound(err error) bool {
+	var doErr *godo.ErrorResponse
+	if errors.As(err, &doErr) {
+		return doErr.Response != nil && doErr.Response.StatusCode == http.StatusNotFound
+	}
+	var linodeErr *linodego.Error
+	if errors.As(err, &linodeErr) {
+		return linodeErr.Code == http.StatusNotFound
+	}
+	var scwNotFound *scw.ResourceNotFoundError
+	if errors.As(err, &scwNotFound) {
+		return true
+	}
+	var scwErr *scw.ResponseError
+	if errors.As(err, &scwErr) {
+		return scwErr.StatusCode == http.StatusNotFound
+	}
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		return azureErr.StatusCode == http.StatusNotFound
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return awsErr.Code() == ec2InstanceNotFound
+	}
+	var gceErr *googleapi.Error
+	if errors.As(err, &gceErr) {
+		return gceErr.Code == http.StatusNotFound
+	}
+
+	msg := err.Error()
+	for _, prefix := range missingHostErrors {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var hostReplacementsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "inlets_operator",
+	Name:      "host_replacements_total",
+	Help:      "Number of exit servers replaced after they were found missing or stopped, by reason",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(hostReplacementsTotal)
+}
+
+// getHostHealth classifies the result of provisioner.Status for an
+// active exit server.
+func getHostHealth(host *provision.ProvisionedHost, err error) hostHealth {
+	if err != nil {
+		if isHostNotFound(err) {
+			return hostMissing
+		}
+		return hostUnknown
+	}
+
+	if host == nil {
+		return hostMissing
+	}
+	if host.Status == provision.ActiveStatus {
+		return hostHealthy
+	}
+	if stoppedHostStatuses[strings.ToLower(host.Status)] {
+		return hostStopped
+	}
+	return hostUnknown
+}
+
+// resetTunnelHost returns a copy of the Tunnel without its exit server,
+// so that the next sync creates a new one with the same token.
+func resetTunnelHost(tunnel *inletsv1alpha1.Tunnel) *inletsv1alpha1.Tunnel {
+	copy := tunnel.DeepCopy()
+	copy.Status.HostStatus = ""
+	copy.Status.HostID = ""
+	copy.Status.HostIP = ""
+	copy.Status.Firewall = nil
+	copy.Status.TLS = nil
+	copy.Status.Cost = nil
+	copy.Status.Connection = nil
+	return copy
+}
+
+// replaceHost removes a missing or stopped exit server from each Tunnel
+// using it, they are then synced to create a new one. A stopped host is
+// deleted first, so that it is not left running up a bill.
+func (c *Controller) replaceHost(tunnels []*inletsv1alpha1.Tunnel, health hostHealth) error {
+	first := tunnels[0]
+	region := getTunnelRegion(c, first)
+
+	if health == hostStopped {
+		provisioner, err := getHostProvisioner(c, region, first.Status.HostID)
+		if err != nil {
+			return err
+		}
+
+		c.tunnelLogger(first).Info("Deleting stopped tunnel server", "ip", first.Status.HostIP)
+		if err := provisioner.Delete(provision.HostDeleteRequest{
+			ID:     first.Status.HostID,
+			IP:     first.Status.HostIP,
+			Region: region,
+			Zone:   c.config().Zone,
+		}); err != nil {
+			return fmt.Errorf("error deleting tunnel server %s: %s", first.Status.HostID, err)
+		}
+	}
+
+	for _, tunnel := range tunnels {
+		if err := deleteFirewall(tunnel, c); err != nil {
+			c.tunnelLogger(tunnel).Error("Error deleting firewall", logKeyError, err)
+		}
+
+		msg := fmt.Sprintf("Tunnel server %s (%s) is %s, creating a new one", tunnel.Status.HostID, tunnel.Status.HostIP, health)
+		c.tunnelLogger(tunnel).Warn("Replacing tunnel server", "health", health, "ip", tunnel.Status.HostIP)
+		c.recordEvent(tunnel, corev1.EventTypeWarning, HostReplaced, "%s", msg)
+
+		// The old IP is removed while the Tunnel still has it, the new
+		// host's IP is published once it is active
+		if err := c.updateService(tunnel, ""); err != nil {
+			return fmt.Errorf("error removing %s from the service of %s.%s: %s", tunnel.Status.HostIP, tunnel.Name, tunnel.Namespace, err)
+		}
+
+		if _, err := c.operatorclientset.OperatorV1alpha1().
+			Tunnels(tunnel.Namespace).
+			UpdateStatus(context.Background(), resetTunnelHost(tunnel), metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("tunnel %s.%s status reset error: %s", tunnel.Name, tunnel.Namespace, err)
+		}
+	}
+
+	hostReplacementsTotal.WithLabelValues(string(health)).Inc()
+	return nil
+}
+
+// checkHostsOnce queries the provider for each active exit server once,
+// and replaces those found missing or stopped hostFailureThreshold
+// times in a row. failures is kept between calls.
+func (c *Controller) checkHostsOnce(failures map[string]int) {
+	tunnels, err := c.tunnelsLister.List(labels.Everything())
+	if err != nil {
+		slog.Error("Error listing tunnels", logKeyError, err)
+		return
+	}
+
+	// Shared exit servers are checked once for all of their Tunnels
+	hosts := map[string][]*inletsv1alpha1.Tunnel{}
+	for _, tunnel := range tunnels {
+		if tunnel.Status.HostStatus != provision.ActiveStatus ||
+			len(tunnel.Status.HostID) == 0 ||
+			tunnel.Status.PoolAllocation != nil ||
+			getUpstream(tunnel) == "" ||
+			!c.namespaceAllowed(tunnel.Namespace) {
+			continue
+		}
+		hosts[tunnel.Status.HostID] = append(hosts[tunnel.Status.HostID], tunnel)
+	}
+
+	for id := range failures {
+		if _, ok := hosts[id]; !ok {
+			delete(failures, id)
+		}
+	}
+
+	for id, hostTunnels := range hosts {
+		provisioner, err := getHostProvisioner(c, getTunnelRegion(c, hostTunnels[0]), id)
+		if err != nil {
+			slog.Error("Error creating provisioner", logKeyProvider, c.config().Provider, logKeyError, err)
+			return
+		}
+
+		health := getHostHealth(provisioner.Status(id))
+		if health == hostHealthy || health == hostUnknown {
+			delete(failures, id)
+			continue
+		}
+
+		failures[id]++
+		slog.Info("Tunnel server failed check", logKeyProvider, c.config().Provider, logKeyHostID, id,
+			"health", health, "check", failures[id], "threshold", hostFailureThreshold)
+		if failures[id] < hostFailureThreshold {
+			continue
+		}
+
+		if err := c.replaceHost(hostTunnels, health); err != nil {
+			slog.Error("Error replacing tunnel server", logKeyProvider, c.config().Provider, logKeyHostID, id, logKeyError, err)
+			continue
+		}
+		delete(failures, id)
+	}
+}
+
+// checkHosts checks the exit servers of active Tunnels with the provider
+// every interval, it blocks until stopCh is closed.
+func (c *Controller) checkHosts(interval time.Duration, stopCh <-chan struct{}) {
+	if ok := cache.WaitForCacheSync(stopCh, c.tunnelsSynced); !ok {
+		return
+	}
+
+	failures := map[string]int{}
+	wait.Until(func() {
+		c.checkHostsOnce(failures)
+	}, interval, stopCh)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/digitalocean/godo"
+	provision "github.com/inlets/cloud-provision/provision"
+	"github.com/linode/linodego"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+)
+
+func Test_getHostHealth(t *testing.T) {
+	cases := []struct {
+		name string
+		host *provision.ProvisionedHost
+		err  error
+		want hostHealth
+	}{
+		{name: "active", host: &provision.ProvisionedHost{Status: provision.ActiveStatus}, want: hostHealthy},
+		{name: "digitalocean powered off", host: &provision.ProvisionedHost{Status: "off"}, want: hostStopped},
+		{name: "linode offline", host: &provision.ProvisionedHost{Status: "offline"}, want: hostStopped},
+		{name: "rebooting", host: &provision.ProvisionedHost{Status: "rebooting"}, want: hostUnknown},
+		{name: "digitalocean 404", err: &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}, want: hostMissing},
+		{name: "digitalocean 500", err: &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusInternalServerError}}, want: hostUnknown},
+		{name: "hetzner missing", err: fmt.Errorf("failed to find server with id 1234"), want: hostMissing},
+		{name: "linode 404", err: &linodego.Error{Code: http.StatusNotFound}, want: hostMissing},
+		{name: "ec2 missing", err: awserr.New(ec2InstanceNotFound, "The instance ID 'i-1234' does not exist", nil), want: hostMissing},
+		{name: "ec2 not described", err: fmt.Errorf("cannot describe host: i-1234"), want: hostMissing},
+		{name: "gce 404", err: fmt.Errorf("could not get instance: %v", &googleapi.Error{Code: http.StatusNotFound, Message: "not found"}), want: hostMissing},
+		{name: "other 404", err: fmt.Errorf("error getting firewall: 404 not found"), want: hostUnknown},
+		{name: "timeout", err: fmt.Errorf("context deadline exceeded"), want: hostUnknown},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := getHostHealth(tc.host, tc.err)
+			if got != tc.want {
+				t.Fatalf("want %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_resetTunnelHost_KeepsTokenAndClient(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{
+		Status: inletsv1alpha1.TunnelStatus{
+			HostStatus:          provision.ActiveStatus,
+			HostID:              "1234",
+			HostIP:              "192.0.2.10",
+			AuthTokenRef:        &inletsv1alpha1.ResourceRef{Name: "nginx-tunnel-token"},
+			ClientDeploymentRef: &inletsv1alpha1.ResourceRef{Name: "nginx-tunnel-client"},
+			Firewall:            &inletsv1alpha1.FirewallStatus{ID: "fw-1"},
+			Connection:          &inletsv1alpha1.ConnectionStatus{Clients: 1},
+		},
+	}
+
+	got := resetTunnelHost(tunnel)
+
+	if got.Status.HostStatus != "" || got.Status.HostID != "" || got.Status.HostIP != "" {
+		t.Errorf("want host fields cleared, but got %s %s %s", got.Status.HostStatus, got.Status.HostID, got.Status.HostIP)
+	}
+	if got.Status.Firewall != nil || got.Status.Connection != nil {
+		t.Errorf("want firewall and connection cleared, but got %v %v", got.Status.Firewall, got.Status.Connection)
+	}
+	if got.Status.AuthTokenRef == nil || got.Status.AuthTokenRef.Name != "nginx-tunnel-token" {
+		t.Errorf("want authTokenRef kept, but got %v", got.Status.AuthTokenRef)
+	}
+	if got.Status.ClientDeploymentRef == nil {
+		t.Errorf("want clientDeploymentRef kept, but got nil")
+	}
+	if tunnel.Status.HostID != "1234" {
+		t.Errorf("want original Tunnel unchanged, but got HostID %s", tunnel.Status.HostID)
+	}
+}
+
+func Test_makeClientDeployment_HostAnnotation(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{
+		Spec: inletsv1alpha1.TunnelSpec{
+			ServiceRef:   &inletsv1alpha1.ResourceRef{Name: "nginx"},
+			AuthTokenRef: &inletsv1alpha1.ResourceRef{Name: "nginx-tunnel-token"},
+		},
+		Status: inletsv1alpha1.TunnelStatus{HostIP: "192.0.2.10"},
+	}
+	tunnel.Name = "nginx-tunnel"
+
+	got := makeClientDeployment(tunnel, "ghcr.io/inlets/inlets-pro:"+defaultRelease, "80", "", "128Mi")
+
+	if got.Annotations[inletsHostAnnotation] != "192.0.2.10" {
+		t.Fatalf("want %s, but got %s", "192.0.2.10", got.Annotations[inletsHostAnnotation])
+	}
+}
+
+func Test_makeClientDeployment_Upstream(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{
+		Spec: inletsv1alpha1.TunnelSpec{
+			Upstream: "192.168.0.10",
+			Ports:    []int32{5432},
+		},
+		Status: inletsv1alpha1.TunnelStatus{HostIP: "192.0.2.10"},
+	}
+	tunnel.Name = "postgres-tunnel"
+
+	got := makeClientDeployment(tunnel, "ghcr.io/inlets/inlets-pro:"+defaultRelease, getPortsString(tunnel, nil), "", "128Mi")
+	args := strings.Join(got.Spec.Template.Spec.Containers[0].Args, " ")
+
+	for _, want := range []string{"--upstream=192.168.0.10", "--ports=5432"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("want args to contain %s, but got %s", want, args)
+		}
+	}
+}
+
+func Test_replaceHost_RemovesServiceIP(t *testing.T) {
+	c, _ := makeEventsController(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{ExternalIPs: []string{"192.0.2.10"}},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "192.0.2.10"}},
+		}},
+	})
+
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.OwnerReferences = []metav1.OwnerReference{{Kind: "Service", Name: "nginx"}}
+	tunnel.Status = inletsv1alpha1.TunnelStatus{HostStatus: provision.ActiveStatus, HostID: "1234", HostIP: "192.0.2.10"}
+	c.operatorclientset = fake.NewSimpleClientset(tunnel)
+	c.infraConfig = newConfigStore(&InfraConfig{Provider: "digitalocean"})
+
+	if err := c.replaceHost([]*inletsv1alpha1.Tunnel{tunnel}, hostMissing); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	service, _ := c.kubeclientset.CoreV1().Services("default").Get(context.Background(), "nginx", metav1.GetOptions{})
+	if len(service.Spec.ExternalIPs) != 0 || len(service.Status.LoadBalancer.Ingress) != 0 {
+		t.Fatalf("want the old IP removed, but got %v and %v", service.Spec.ExternalIPs, service.Status.LoadBalancer.Ingress)
+	}
+
+	got, _ := c.operatorclientset.OperatorV1alpha1().Tunnels("default").Get(context.Background(), "nginx-tunnel", metav1.GetOptions{})
+	if got.Status.HostIP != "" {
+		t.Fatalf("want the host reset, but got %s", got.Status.HostIP)
+	}
+}
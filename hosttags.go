@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/digitalocean/godo"
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// clusterTagKey is the tag, or label, written to the exit servers
+// created by the operator, its value is the name of the cluster.
+const clusterTagKey = "inlets-cluster"
+
+// ErrHostTag is used as part of the Event 'reason' when the exit server
+// cannot be tagged with the name of the cluster
+const ErrHostTag = "ErrHostTag"
+
+// taggedProviders are the providers whose exit servers can be tagged with
+// the name of the cluster, so are the only ones gc supports.
+var taggedProviders = []string{"digitalocean", "scaleway", "ec2", "gce"}
+
+// errGCNotSupported is returned by gc for the providers which have no
+// tagger, i.e. hetzner, linode and azure.
+func errGCNotSupported(provider string) error {
+	return fmt.Errorf("gc is not supported for provider: %s, its exit servers cannot be tagged with the cluster's name, only %s are supported",
+		provider, strings.Join(taggedProviders, ", "))
+}
+
+// hostTagger tags exit servers with the name of the cluster which
+// created them, so that gc only considers the cluster's own hosts, and
+// reads back when they were created.
+type hostTagger interface {
+	// Tag adds the cluster's tag to the host
+	Tag(hostID, cluster string) error
+	// Created returns when the host was created
+	Created(hostID string) (time.Time, error)
+}
+
+// getClusterName returns the name given for the cluster, or the UID of
+// its kube-system namespace, which is unique to each cluster and stays
+// the same for its lifetime. The name is written as a GCE label value, so
+// is limited to lower-case letters, digits, "-" and "_".
+func getClusterName(kubeClient kubernetes.Interface, name string) (string, error) {
+	if len(name) == 0 {
+		ns, err := kubeClient.CoreV1().Namespaces().Get(context.Background(), metav1.NamespaceSystem, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("cannot read the UID of %s to name the cluster, give cluster-name instead: %s", metav1.NamespaceSystem, err)
+		}
+		name = string(ns.UID)
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, name)
+
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name, nil
+}
+
+// clusterTag is the tag for providers where tags have no value.
+func clusterTag(cluster string) string {
+	return clusterTagKey + ":" + cluster
+}
+
+// getHostTagger returns the tagger for the provider, or nil when the
+// provider's hosts cannot be tagged.
+func getHostTagger(infra *InfraConfig, region string) hostTagger {
+	switch infra.Provider {
+	case "digitalocean":
+		return &digitalOceanTagger{client: godo.NewFromToken(infra.GetAccessKey())}
+	case "scaleway":
+		if len(region) == 0 {
+			region = "fr-par-1"
+		}
+		zone, err := scw.ParseZone(region)
+		if err != nil {
+			slog.Error("Error parsing Scaleway zone", logKeyProvider, "scaleway", logKeyError, err)
+			return nil
+		}
+		client, err := scw.NewClient(
+			scw.WithAuth(infra.GetAccessKey(), infra.GetSecretKey()),
+			scw.WithDefaultOrganizationID(infra.OrganizationID),
+			scw.WithDefaultZone(zone),
+		)
+		if err != nil {
+			slog.Error("Error creating Scaleway client", logKeyProvider, "scaleway", logKeyError, err)
+			return nil
+		}
+		return &scalewayTagger{client: instance.NewAPI(client)}
+	case "ec2":
+		sess, err := session.NewSession(&aws.Config{
+			Region:      aws.String(region),
+			Credentials: credentials.NewStaticCredentials(infra.GetAccessKey(), infra.GetSecretKey(), ""),
+		})
+		if err != nil {
+			slog.Error("Error creating AWS session", logKeyProvider, "ec2", logKeyError, err)
+			return nil
+		}
+		return &ec2Tagger{client: ec2.New(sess)}
+	case "gce":
+		service, err := compute.NewService(context.Background(), option.WithCredentialsJSON([]byte(infra.GetAccessKey())))
+		if err != nil {
+			slog.Error("Error creating GCE client", logKeyProvider, "gce", logKeyError, err)
+			return nil
+		}
+		return &gceTagger{client: service}
+	}
+	return nil
+}
+
+// tagHost tags the Tunnel's new exit server with the name of the cluster,
+// a failure is recorded, but does not stop the Tunnel from being created.
+func (c *Controller) tagHost(tunnel *inletsv1alpha1.Tunnel, hostID string) {
+	if c.dryRun || len(c.clusterName) == 0 {
+		return
+	}
+
+	tagger := getHostTagger(c.config(), getTunnelRegion(c, tunnel))
+	if tagger == nil {
+		return
+	}
+
+	if err := tagger.Tag(hostID, c.clusterName); err != nil {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ErrHostTag,
+			"Error tagging tunnel server %s with cluster %s, it will not be found by gc: %s", hostID, c.clusterName, err)
+	}
+}
+
+type digitalOceanTagger struct {
+	client *godo.Client
+}
+
+func (t *digitalOceanTagger) Tag(hostID, cluster string) error {
+	tag := clusterTag(cluster)
+	if _, _, err := t.client.Tags.Create(context.Background(), &godo.TagCreateRequest{Name: tag}); err != nil {
+		return err
+	}
+
+	_, err := t.client.Tags.TagResources(context.Background(), tag, &godo.TagResourcesRequest{
+		Resources: []godo.Resource{{ID: hostID, Type: godo.DropletResourceType}},
+	})
+	return err
+}
+
+func (t *digitalOceanTagger) Created(hostID string) (time.Time, error) {
+	dropletID, err := strconv.Atoi(hostID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid droplet ID: %s", hostID)
+	}
+
+	droplet, _, err := t.client.Droplets.Get(context.Background(), dropletID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, droplet.Created)
+}
+
+type scalewayTagger struct {
+	client *instance.API
+}
+
+func (t *scalewayTagger) Tag(hostID, cluster string) error {
+	res, err := t.client.GetServer(&instance.GetServerRequest{ServerID: hostID})
+	if err != nil {
+		return err
+	}
+
+	tag := clusterTag(cluster)
+	if containsString(res.Server.Tags, tag) {
+		return nil
+	}
+
+	tags := append(res.Server.Tags, tag)
+	_, err = t.client.UpdateServer(&instance.UpdateServerRequest{ServerID: hostID, Tags: &tags})
+	return err
+}
+
+func (t *scalewayTagger) Created(hostID string) (time.Time, error) {
+	res, err := t.client.GetServer(&instance.GetServerRequest{ServerID: hostID})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if res.Server.CreationDate == nil {
+		return time.Time{}, fmt.Errorf("no creation date for server: %s", hostID)
+	}
+	return *res.Server.CreationDate, nil
+}
+
+type ec2Tagger struct {
+	client *ec2.EC2
+}
+
+func (t *ec2Tagger) Tag(hostID, cluster string) error {
+	_, err := t.client.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(hostID)},
+		Tags:      []*ec2.Tag{{Key: aws.String(clusterTagKey), Value: aws.String(cluster)}},
+	})
+	return err
+}
+
+func (t *ec2Tagger) Created(hostID string) (time.Time, error) {
+	res, err := t.client.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(hostID)},
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, r := range res.Reservations {
+		for _, i := range r.Instances {
+			if i.LaunchTime != nil {
+				return *i.LaunchTime, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("no launch time for instance: %s", hostID)
+}
+
+type gceTagger struct {
+	client *compute.Service
+}
+
+func (t *gceTagger) Tag(hostID, cluster string) error {
+	instanceName, zone, projectID, err := parseGCEHostID(hostID)
+	if err != nil {
+		return err
+	}
+
+	vm, err := t.client.Instances.Get(projectID, zone, instanceName).Do()
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{}
+	for k, v := range vm.Labels {
+		labels[k] = v
+	}
+	if labels[clusterTagKey] == cluster {
+		return nil
+	}
+	labels[clusterTagKey] = cluster
+
+	_, err = t.client.Instances.SetLabels(projectID, zone, instanceName, &compute.InstancesSetLabelsRequest{
+		Labels:           labels,
+		LabelFingerprint: vm.LabelFingerprint,
+	}).Do()
+	return err
+}
+
+func (t *gceTagger) Created(hostID string) (time.Time, error) {
+	instanceName, zone, projectID, err := parseGCEHostID(hostID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	vm, err := t.client.Instances.Get(projectID, zone, instanceName).Do()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, vm.CreationTimestamp)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	klogv2 "k8s.io/klog/v2"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// Keys used in the logs, so that they can be filtered by Tunnel, namespace
+// and so on in a log store.
+const (
+	logKeyTunnel    = "tunnel"
+	logKeyNamespace = "namespace"
+	logKeyService   = "service"
+	logKeyUpstream  = "upstream"
+	logKeyProvider  = "provider"
+	logKeyHostID    = "hostID"
+	logKeyPhase     = "phase"
+	logKeyError     = "error"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// newLogger returns a logger writing to w, as text or JSON, at level or
+// above.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	l, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level: %s, give one of debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format: %s, give text or json", format)
+}
+
+// setupLogging makes the logger the default for the operator, for the
+// standard log package, and for the Kubernetes client libraries.
+func setupLogging(format, level string) error {
+	logger, err := newLogger(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+	klogv2.SetSlogLogger(logger)
+	return nil
+}
+
+// fatal logs msg as an error, then exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// tunnelLogger returns a logger with the Tunnel's keys.
+func (c *Controller) tunnelLogger(tunnel *inletsv1alpha1.Tunnel) *slog.Logger {
+	phase := tunnel.Status.HostStatus
+	if len(phase) == 0 {
+		phase = "pending"
+	}
+
+	args := []any{
+		logKeyTunnel, tunnel.Name,
+		logKeyNamespace, tunnel.Namespace,
+		logKeyPhase, phase,
+	}
+	if tunnel.Spec.ServiceRef != nil {
+		args = append(args, logKeyService, tunnel.Spec.ServiceRef.Name)
+	}
+	if len(tunnel.Spec.Upstream) > 0 {
+		args = append(args, logKeyUpstream, tunnel.Spec.Upstream)
+	}
+	if len(tunnel.Status.HostID) > 0 {
+		args = append(args, logKeyHostID, tunnel.Status.HostID)
+	}
+	if c.infraConfig != nil && len(c.config().Provider) > 0 {
+		args = append(args, logKeyProvider, c.config().Provider)
+	}
+
+	return slog.With(args...)
+}
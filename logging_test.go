@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+func Test_newLogger_JSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, err := newLogger(out, "json", "info")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	logger.Debug("hidden")
+	logger.Info("shown", logKeyTunnel, "nginx-tunnel")
+
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("want one JSON line, but got %q: %s", out.String(), err)
+	}
+	if got["msg"] != "shown" || got[logKeyTunnel] != "nginx-tunnel" {
+		t.Fatalf("want msg shown for nginx-tunnel, but got %v", got)
+	}
+}
+
+func Test_newLogger_Invalid(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "json", "verbose"); err == nil {
+		t.Errorf("want error for unknown level")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Errorf("want error for unknown format")
+	}
+}
+
+func Test_tunnelLogger_Keys(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, _ := newLogger(out, "json", "info")
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(previous)
+
+	c := &Controller{infraConfig: newConfigStore(&InfraConfig{Provider: "digitalocean"})}
+	tunnel := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", Namespace: "default"},
+		Spec:       inletsv1alpha1.TunnelSpec{ServiceRef: &inletsv1alpha1.ResourceRef{Name: "nginx"}},
+		Status:     inletsv1alpha1.TunnelStatus{HostStatus: "active", HostID: "1234"},
+	}
+
+	c.tunnelLogger(tunnel).Info("test")
+
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := map[string]string{
+		logKeyTunnel:    "nginx-tunnel",
+		logKeyNamespace: "default",
+		logKeyService:   "nginx",
+		logKeyProvider:  "digitalocean",
+		logKeyHostID:    "1234",
+		logKeyPhase:     "active",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s, want %s, but got %v", k, v, got[k])
+		}
+	}
+}
@@ -4,17 +4,20 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
 
 	restclient "k8s.io/client-go/rest"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/klog"
 
 	// Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -40,58 +43,141 @@ func init() {
 
 const defaultRelease = "0.9.40"
 
+// defaultDownloadURL is where the exit server downloads inlets-pro from
+const defaultDownloadURL = "https://github.com/inlets/inlets-pro/releases/download"
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	infra := &InfraConfig{
 		ProConfig: InletsProConfig{},
 	}
 
-	flag.StringVar(&infra.Provider, "provider", "", "Your infrastructure provider - 'equinix-metal', 'digitalocean', 'scaleway', 'gce', 'linode', 'azure', 'ec2' or 'hetzner'")
-	flag.StringVar(&infra.Region, "region", "", "The region to provision hosts into")
-	flag.StringVar(&infra.Zone, "zone", "us-central1-a", "The zone where the exit node is to be provisioned")
-	flag.StringVar(&infra.AccessKey, "access-key", "", "The access key for your infrastructure provider")
-	flag.StringVar(&infra.AccessKeyFile, "access-key-file", "", "Read the access key for your infrastructure provider from a file (recommended)")
-	flag.StringVar(&infra.SecretKey, "secret-key", "", "The secret key if using scaleway or ec2 as the provider")
-	flag.StringVar(&infra.SecretKeyFile, "secret-key-file", "", "Read the access key for your infrastructure provider from a file (recommended)")
-	flag.StringVar(&infra.SubscriptionID, "subscription-id", "", "The azure Subscription ID")
-	flag.StringVar(&infra.OrganizationID, "organization-id", "", "The organization id if using scaleway as the provider")
-	flag.StringVar(&infra.VpcID, "vpc-id", "", "The VPC ID to create the exit-server in (ec2)")
-	flag.StringVar(&infra.SubnetID, "subnet-id", "", "The Subnet ID where the exit-server should be placed (ec2)")
-	flag.StringVar(&infra.ProjectID, "project-id", "", "The project ID if using equinix-metal, or gce as the provider")
+	addProviderFlags(flag.CommandLine, infra)
 	flag.StringVar(&infra.ProConfig.License, "license", "", "Supply a license for use with inlets-pro")
 	flag.StringVar(&infra.ProConfig.LicenseFile, "license-file", "", "Supply a file to read for the inlets-pro license")
 	flag.StringVar(&infra.ProConfig.ClientImage, "client-image", "ghcr.io/inlets/inlets-pro:"+defaultRelease, "Container image for inlets tunnel clients run in the cluster")
 	flag.StringVar(&infra.ProConfig.InletsRelease, "inlets-release", defaultRelease, "Inlets version to use to create tunnel servers")
+	flag.StringVar(&infra.ProConfig.DownloadURL, "download-url", defaultDownloadURL, "Base URL to download the inlets-pro server binary and systemd unit from, i.e. a mirror of the GitHub releases")
+
+	var serverChecksum, serviceChecksum string
+	flag.StringVar(&serverChecksum, "inlets-checksum", "", "SHA256 checksum of the inlets-pro server binary for the inlets-release")
+	flag.StringVar(&serviceChecksum, "inlets-service-checksum", "", "SHA256 checksum of the inlets-pro.service systemd unit for the inlets-release")
+	var managedTLS bool
+	var caSecret string
+	flag.BoolVar(&managedTLS, "managed-tls", false, "Issue exit servers a certificate from a CA run by the operator, which the clients pin")
+	flag.StringVar(&caSecret, "ca-secret", "inlets-operator-ca", "Name of the Secret in the operator's namespace for the CA used by -managed-tls, created if missing")
+	var workers, provisioningWorkers int
+	flag.IntVar(&workers, "workers", 2, "Number of workers syncing Services and Tunnels")
+	flag.IntVar(&provisioningWorkers, "provisioning-workers", 4, "Number of exit servers to create at once, apart from the workers, 0 to create them from the workers")
+
+	var providerQPS float64
+	var providerBurst, providerFailureThreshold int
+	var providerCooldown time.Duration
+	flag.Float64Var(&providerQPS, "provider-qps", 2, "Maximum calls per second to the provider's API, shared by all workers, 0 for no limit")
+	flag.IntVar(&providerBurst, "provider-burst", 5, "Maximum burst of calls to the provider's API")
+	flag.IntVar(&providerFailureThreshold, "provider-failure-threshold", 5, "Number of failed calls in a row to the provider's API before calls are paused, 0 to never pause")
+	flag.DurationVar(&providerCooldown, "provider-cooldown", time.Minute, "How long to pause calls to the provider's API after repeated failures")
+
+	var gatewayAPI bool
+	flag.BoolVar(&gatewayAPI, "gateway-api", false, "Create tunnel servers for Gateways of a GatewayClass with controllerName "+gatewayControllerName+", the Gateway API CRDs must be installed")
+
+	var dryRun bool
+	flag.BoolVar(&dryRun, "dry-run", false, "Log the tunnel servers that would be created instead of creating them, and scale their clients to zero")
+	flag.BoolVar(&infra.ProConfig.SkipChecksums, "skip-checksums", false, "Allow tunnel servers to be created for an inlets release without checksums, the downloaded files are then not verified")
 
 	flag.StringVar(&infra.MaxClientMemory, "max-client-memory", "128Mi", "Maximum memory limit for the tunnel clients")
 
 	flag.StringVar(&infra.Plan, "plan", "", "Plan code for cloud host")
+	flag.StringVar(&infra.UserDataTemplateFile, "user-data-template-file", "", "Path to a Go template for the exit server's user-data, replacing the default script")
+
+	flag.IntVar(&infra.Quotas.Cluster.MaxTunnels, "max-tunnels", 0, "Maximum number of tunnel servers to create for the cluster, 0 for no limit")
+	flag.IntVar(&infra.Quotas.Namespace.MaxTunnels, "max-tunnels-per-namespace", 0, "Maximum number of tunnel servers to create for each namespace, 0 for no limit")
+	flag.Float64Var(&infra.Quotas.Cluster.MaxMonthlySpend, "max-monthly-spend", 0, "Maximum estimated monthly spend on tunnel servers for the cluster, 0 for no limit")
+	flag.Float64Var(&infra.Quotas.Namespace.MaxMonthlySpend, "max-monthly-spend-per-namespace", 0, "Maximum estimated monthly spend on tunnel servers for each namespace, 0 for no limit")
 
 	flag.BoolVar(&infra.AnnotatedOnly, "annotated-only", false, "Only create a tunnel for annotated services. Annotate with operator.inlets.dev/manage=1.")
 
+	var watchNamespaces string
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "", "Comma-separated list of namespaces to watch, leave empty to watch all namespaces")
+	flag.StringVar(&infra.NamespaceSelector, "namespace-selector", "", "Only manage namespaces matching this label selector i.e. inlets.dev/tunnels=enabled")
+
+	var configFile, configMap, metricsAddr string
+	var configReloadInterval time.Duration
+	flag.StringVar(&configFile, "config-file", "", "Path to a YAML config file, values override the flags and are reloaded when the file changes")
+	flag.StringVar(&configMap, "config-map", "", "Name of a ConfigMap in the operator's namespace with a config.yaml key, values override the flags and are reloaded when it changes")
+	flag.DurationVar(&configReloadInterval, "config-reload-interval", time.Second*10, "How often to check the config file for changes")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "Address to serve Prometheus metrics on, leave empty to disable")
+
+	var connectionCheckInterval, hostCheckInterval time.Duration
+	flag.DurationVar(&connectionCheckInterval, "connection-check-interval", time.Minute, "How often to query each tunnel server for its connected clients, 0 to disable")
+	flag.DurationVar(&hostCheckInterval, "host-check-interval", time.Minute*5, "How often to check with the provider that each tunnel server still exists and is running, and replace it if not, 0 to disable")
+
+	var webhookAddr, webhookCertFile, webhookKeyFile, webhookService, webhookCAFile string
+	flag.StringVar(&webhookAddr, "webhook-addr", "", "Address to serve the Tunnel admission webhooks on over TLS, leave empty to disable")
+	flag.StringVar(&webhookCertFile, "webhook-cert-file", "/var/run/webhook/tls.crt", "Path to the TLS certificate for the admission webhooks")
+	flag.StringVar(&webhookKeyFile, "webhook-key-file", "/var/run/webhook/tls.key", "Path to the TLS key for the admission webhooks")
+	flag.StringVar(&webhookService, "webhook-service", "", "Name of the Service for the webhooks in the operator's namespace, when set the Tunnel CRD's conversion webhook is pointed at it")
+	flag.StringVar(&webhookCAFile, "webhook-ca-file", "/var/run/webhook/ca.crt", "Path to the CA for the webhooks' certificate, for the Tunnel CRD's conversion webhook")
+
+	var logFormat, logLevel string
+	flag.StringVar(&logFormat, "log-format", "text", "Format of the logs, text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimum level to log, one of debug, info, warn or error")
+
+	flag.Usage = usage
 	flag.Parse()
-	log.Printf("Inlets Operator version: %s SHA: %s\n", version.Release, version.SHA)
 
-	create := "all tunnels"
-	if infra.AnnotatedOnly {
-		create = "tunnels annotated with: operator.inlets.dev/manage=1"
+	if err := setupLogging(logFormat, logLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
 	}
 
-	log.Printf("Creating tunnels for: %s ", create)
-
-	err := validateFlags(*infra)
+	infra.WatchNamespaces = parseNamespaces(watchNamespaces)
+	if len(serverChecksum) > 0 || len(serviceChecksum) > 0 {
+		infra.ProConfig.Checksums = map[string]ReleaseChecksums{
+			infra.GetInletsRelease(): {Server: serverChecksum, Service: serviceChecksum},
+		}
+	}
+	slog.Info("Inlets Operator", "version", version.Release, "sha", version.SHA)
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	if len(configFile) > 0 && len(configMap) > 0 {
+		fmt.Fprintf(os.Stderr, "give only one of config-file or config-map\n")
 		os.Exit(1)
 	}
 
-	log.Printf("Inlets client image: %s\tInlets server version: %s\n",
-		infra.GetInletsClientImage(),
-		infra.GetInletsRelease())
+	loader := configLoader{base: *infra}
 
-	if _, err := infra.ProConfig.GetLicenseKey(); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-		os.Exit(1)
+	if len(configFile) > 0 {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading config file: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if infra, err = loader.load(data); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", configFile, err.Error())
+			os.Exit(1)
+		}
+	} else if len(configMap) == 0 {
+		err := validateFlags(*infra)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if _, err := infra.ProConfig.GetLicenseKey(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
 	}
 
 	// set up signals so we handle the first shutdown signal gracefully
@@ -99,38 +185,206 @@ func main() {
 
 	cfg, err := getClientCmdConfig(masterURL, kubeconfig)
 	if err != nil {
-		log.Fatalf("Error building kubeconfig: %s", err.Error())
+		fatal("Error building kubeconfig", logKeyError, err)
 	}
 
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
+		fatal("Error building kubernetes clientset", logKeyError, err)
 	}
 
 	operatorClient, err := clientset.NewForConfig(cfg)
 	if err != nil {
-		klog.Fatalf("Error building example clientset: %s", err.Error())
+		fatal("Error building operator clientset", logKeyError, err)
+	}
+
+	if len(configMap) > 0 {
+		data, err := readConfigMap(kubeClient, readNamespace(), configMap)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if infra, err = loader.load(data); err != nil {
+			fmt.Fprintf(os.Stderr, "ConfigMap %s: %s\n", configMap, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	create := "all tunnels"
+	if infra.AnnotatedOnly {
+		create = "tunnels annotated with: operator.inlets.dev/manage=1"
+	}
+
+	slog.Info("Creating tunnels for "+create, logKeyProvider, infra.Provider)
+
+	if len(infra.WatchNamespaces) > 0 {
+		slog.Info("Watching namespaces", "namespaces", strings.Join(infra.WatchNamespaces, ","))
+	}
+	if len(infra.NamespaceSelector) > 0 {
+		slog.Info("Managing namespaces matching selector", "selector", infra.NamespaceSelector)
+	}
+
+	slog.Info("Inlets versions",
+		"clientImage", infra.GetInletsClientImage(),
+		"serverRelease", infra.GetInletsRelease())
+
+	if len(metricsAddr) > 0 {
+		go serveMetrics(metricsAddr)
+	}
+
+	if len(webhookAddr) > 0 {
+		go serveWebhook(webhookAddr, webhookCertFile, webhookKeyFile, infra.Provider, kubeClient, operatorClient)
 	}
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-	tunnelsInformerFactory := informers.NewSharedInformerFactory(operatorClient, time.Second*30)
+	if len(webhookService) > 0 {
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			fatal("Error building dynamic client", logKeyError, err)
+		}
+		if err := configureTunnelConversion(dynamicClient, readNamespace(), webhookService, webhookCAFile); err != nil {
+			slog.Error("Error configuring conversion webhook", logKeyError, err)
+		}
+	}
+
+	namespaces := infra.WatchNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var namespaced []namespaceInformers
+	var kubeInformerFactories []kubeinformers.SharedInformerFactory
+	var tunnelsInformerFactories []informers.SharedInformerFactory
+
+	for _, ns := range namespaces {
+		kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30, kubeinformers.WithNamespace(ns))
+		tunnelsInformerFactory := informers.NewSharedInformerFactoryWithOptions(operatorClient, time.Second*30, informers.WithNamespace(ns))
+
+		namespaced = append(namespaced, namespaceInformers{
+			Namespace:   ns,
+			Deployments: kubeInformerFactory.Apps().V1().Deployments(),
+			Tunnels:     tunnelsInformerFactory.Operator().V1alpha1().Tunnels(),
+			Services:    kubeInformerFactory.Core().V1().Services(),
+			Grants:      tunnelsInformerFactory.Operator().V1alpha1().TunnelGrants(),
+		})
+
+		kubeInformerFactories = append(kubeInformerFactories, kubeInformerFactory)
+		tunnelsInformerFactories = append(tunnelsInformerFactories, tunnelsInformerFactory)
+	}
+
+	// Namespaces are cluster-scoped, so are only watched when a selector
+	// is given, and the operator has a ClusterRole to read them.
+	var namespaceInformer coreinformers.NamespaceInformer
+	if len(infra.NamespaceSelector) > 0 {
+		clusterInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
+		namespaceInformer = clusterInformerFactory.Core().V1().Namespaces()
+		kubeInformerFactories = append(kubeInformerFactories, clusterInformerFactory)
+	}
 
 	controller := NewController(kubeClient, operatorClient,
-		kubeInformerFactory.Apps().V1().Deployments(),
-		tunnelsInformerFactory.Operator().V1alpha1().Tunnels(),
-		kubeInformerFactory.Core().V1().Services(),
+		namespaced,
+		namespaceInformer,
 		infra)
 
+	if len(metricsAddr) > 0 {
+		registerCostMetrics(controller.tunnelsLister)
+	}
+
+	if workers < 1 {
+		fatal("-workers must be at least 1")
+	}
+	if provisioningWorkers > 0 {
+		controller.provisioningPool = newProvisioningPool(provisioningWorkers)
+	}
+	controller.providerGuards = newProviderGuards(providerQPS, providerBurst, providerFailureThreshold, providerCooldown)
+	controller.dryRun = dryRun
+	if dryRun {
+		slog.Info("Running in dry-run mode, no tunnel servers will be created or deleted")
+	}
+	// Without a name, exit servers would be created untagged, and never
+	// be found by gc, so the operator does not start
+	if controller.clusterName, err = getClusterName(kubeClient, infra.ClusterName); err != nil {
+		if containsString(taggedProviders, infra.Provider) {
+			fatal("Error naming the cluster, set -cluster-name", logKeyError, err)
+		}
+		slog.Warn("Tunnel servers cannot be tagged for this provider, and cannot be found by gc", logKeyProvider, infra.Provider, logKeyError, err)
+	}
+
+	var gateways *gatewayController
+	var gatewayFactories []dynamicinformer.DynamicSharedInformerFactory
+	if gatewayAPI {
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			fatal("Error building dynamic client", logKeyError, err)
+		}
+		gateways, gatewayFactories = newGatewayController(controller, dynamicClient, namespaced)
+	}
+
+	if managedTLS {
+		ca, err := ensureCertAuthority(kubeClient, readNamespace(), caSecret)
+		if err != nil {
+			fatal("Error loading CA", logKeyError, err)
+		}
+		controller.ca = ca
+	}
+
 	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
-	kubeInformerFactory.Start(stopCh)
-	tunnelsInformerFactory.Start(stopCh)
+	for _, f := range kubeInformerFactories {
+		f.Start(stopCh)
+	}
+	for _, f := range tunnelsInformerFactories {
+		f.Start(stopCh)
+	}
+	for _, f := range gatewayFactories {
+		f.Start(stopCh)
+	}
+
+	if len(configFile) > 0 {
+		go controller.watchConfigFile(loader, configFile, configReloadInterval, stopCh)
+	} else if len(configMap) > 0 {
+		controller.watchConfigMap(loader, kubeClient, readNamespace(), configMap, stopCh)
+	}
+
+	if connectionCheckInterval > 0 {
+		go controller.checkConnections(connectionCheckInterval, stopCh)
+	}
 
-	if err = controller.Run(2, stopCh); err != nil {
-		klog.Fatalf("Error running controller: %s", err.Error())
+	if hostCheckInterval > 0 {
+		go controller.checkHosts(hostCheckInterval, stopCh)
+	}
+
+	if gateways != nil {
+		go func() {
+			if err := gateways.Run(stopCh); err != nil {
+				fatal("Error running Gateway controller", logKeyError, err)
+			}
+		}()
+	}
+
+	if err = controller.Run(workers, stopCh); err != nil {
+		fatal("Error running controller", logKeyError, err)
 	}
 }
 
+// addProviderFlags adds the flags for the infrastructure provider, which
+// are shared by the controller and the CLI's subcommands.
+func addProviderFlags(fs *flag.FlagSet, infra *InfraConfig) {
+	fs.StringVar(&infra.Provider, "provider", "", "Your infrastructure provider - 'equinix-metal', 'digitalocean', 'scaleway', 'gce', 'linode', 'azure', 'ec2' or 'hetzner'")
+	fs.StringVar(&infra.Region, "region", "", "The region to provision hosts into")
+	fs.StringVar(&infra.Zone, "zone", "us-central1-a", "The zone where the exit node is to be provisioned")
+	fs.StringVar(&infra.AccessKey, "access-key", "", "The access key for your infrastructure provider")
+	fs.StringVar(&infra.AccessKeyFile, "access-key-file", "", "Read the access key for your infrastructure provider from a file (recommended)")
+	fs.StringVar(&infra.SecretKey, "secret-key", "", "The secret key if using scaleway or ec2 as the provider")
+	fs.StringVar(&infra.SecretKeyFile, "secret-key-file", "", "Read the access key for your infrastructure provider from a file (recommended)")
+	fs.StringVar(&infra.SubscriptionID, "subscription-id", "", "The azure Subscription ID")
+	fs.StringVar(&infra.OrganizationID, "organization-id", "", "The organization id if using scaleway as the provider")
+	fs.StringVar(&infra.VpcID, "vpc-id", "", "The VPC ID to create the exit-server in (ec2)")
+	fs.StringVar(&infra.SubnetID, "subnet-id", "", "The Subnet ID where the exit-server should be placed (ec2)")
+	fs.StringVar(&infra.ProjectID, "project-id", "", "The project ID if using equinix-metal, or gce as the provider")
+	fs.StringVar(&infra.ClusterName, "cluster-name", "", "Name to tag the exit servers with, so that gc only finds the cluster's own, leave empty to use the UID of the kube-system namespace")
+}
+
 // GetInletsClientImage returns the image for the client-side tunnel
 func (i *InfraConfig) GetInletsClientImage() string {
 	if i.ProConfig.ClientImage == "" {
@@ -147,6 +401,15 @@ func (i *InfraConfig) GetInletsRelease() string {
 	return strings.TrimSpace(i.ProConfig.InletsRelease)
 }
 
+// GetDownloadURL returns the base URL for the inlets-pro server binary
+func (i *InfraConfig) GetDownloadURL() string {
+	if i.ProConfig.DownloadURL == "" {
+		return defaultDownloadURL
+	}
+
+	return strings.TrimSuffix(strings.TrimSpace(i.ProConfig.DownloadURL), "/")
+}
+
 // GetAccessKey from parameter or file trimming
 // any whitespace found.
 func (i *InfraConfig) GetAccessKey() string {
@@ -154,7 +417,7 @@ func (i *InfraConfig) GetAccessKey() string {
 		data, err := ioutil.ReadFile(i.AccessKeyFile)
 
 		if err != nil {
-			log.Fatalln(err)
+			fatal("Error reading key file", logKeyError, err)
 		}
 
 		return strings.TrimSpace(string(data))
@@ -170,7 +433,7 @@ func (i *InfraConfig) GetSecretKey() string {
 		data, err := ioutil.ReadFile(i.SecretKeyFile)
 
 		if err != nil {
-			log.Fatalln(err)
+			fatal("Error reading key file", logKeyError, err)
 		}
 
 		return strings.TrimSpace(string(data))
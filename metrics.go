@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	listers "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1alpha1"
+)
+
+var (
+	// configReloadsTotal counts reloads of the config file or ConfigMap
+	// by result, either "success" or "failure".
+	configReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "inlets_operator",
+		Name:      "config_reloads_total",
+		Help:      "Number of times the config was reloaded, by result",
+	}, []string{"result"})
+
+	// configLastReloadSuccess is 1 when the most recent reload was applied.
+	configLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "inlets_operator",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last config reload was successful",
+	})
+
+	// configLastReloadTime is the time of the last successful reload.
+	configLastReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "inlets_operator",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful config reload",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configReloadsTotal,
+		configLastReloadSuccess,
+		configLastReloadTime)
+}
+
+// registerCostMetrics adds the estimated cost of the exit servers in
+// the Tunnels' status to the metrics.
+func registerCostMetrics(tunnelsLister listers.TunnelLister) {
+	prometheus.MustRegister(newCostCollector(tunnelsLister))
+}
+
+// serveMetrics exposes the Prometheus metrics on addr, it
+// blocks until the server exits.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	slog.Info("Serving metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Error serving metrics", logKeyError, err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	informers "github.com/inlets/inlets-operator/pkg/generated/informers/externalversions/inletsoperator/v1alpha1"
+	listers "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1alpha1"
+)
+
+// namespaceInformers are the informers the controller needs for one
+// watched namespace, or for the whole cluster when Namespace is empty.
+type namespaceInformers struct {
+	Namespace   string
+	Deployments appsinformers.DeploymentInformer
+	Tunnels     informers.TunnelInformer
+	Services    coreinformers.ServiceInformer
+	Grants      informers.TunnelGrantInformer
+}
+
+// parseNamespaces splits a comma-separated list of namespaces, an empty
+// list means all namespaces.
+func parseNamespaces(value string) []string {
+	namespaces := []string{}
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		if len(ns) > 0 {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+func validateNamespaces(c InfraConfig) error {
+	for _, ns := range c.WatchNamespaces {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace in watch-namespaces: %q, %s", ns, strings.Join(errs, ", "))
+		}
+	}
+
+	if len(c.NamespaceSelector) > 0 {
+		if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespace-selector: %s", err.Error())
+		}
+
+		// The selector needs namespaces to be listed across the cluster,
+		// which a Role in each of the watched namespaces cannot allow
+		if len(c.WatchNamespaces) > 0 {
+			return fmt.Errorf("namespace-selector cannot be used with watch-namespaces, list only the namespaces to manage in watch-namespaces instead")
+		}
+	}
+
+	return nil
+}
+
+// namespaceAllowed returns true when objects in the namespace should be
+// managed by this operator. Namespaces outside of the watched set are never
+// seen by the informers, so only the label selector needs to be checked.
+func (c *Controller) namespaceAllowed(namespace string) bool {
+	if c.namespaceSelector == nil || c.namespaceLister == nil {
+		return true
+	}
+
+	ns, err := c.namespaceLister.Get(namespace)
+	if err != nil {
+		return false
+	}
+
+	return c.namespaceSelector.Matches(labels.Set(ns.Labels))
+}
+
+// enqueueNamespace re-queues every LoadBalancer Service in a namespace, so
+// that Tunnels are created or removed when the namespace's labels change.
+func (c *Controller) enqueueNamespace(obj interface{}) {
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return
+	}
+
+	services, err := c.serviceLister.Services(ns.Name).List(labels.Everything())
+	if err != nil {
+		return
+	}
+
+	for _, svc := range services {
+		if checkServiceType(svc) {
+			c.enqueueService(svc)
+		}
+	}
+}
+
+// multiNamespaceTunnelLister looks up Tunnels in the lister for the
+// namespace they belong to.
+type multiNamespaceTunnelLister map[string]listers.TunnelLister
+
+func (m multiNamespaceTunnelLister) List(selector labels.Selector) ([]*inletsv1alpha1.Tunnel, error) {
+	ret := []*inletsv1alpha1.Tunnel{}
+	for _, l := range m {
+		items, err := l.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, items...)
+	}
+	return ret, nil
+}
+
+func (m multiNamespaceTunnelLister) Tunnels(namespace string) listers.TunnelNamespaceLister {
+	if l, ok := m[namespace]; ok {
+		return l.Tunnels(namespace)
+	}
+	if l, ok := m[""]; ok {
+		return l.Tunnels(namespace)
+	}
+	return listers.NewTunnelLister(newNamespaceIndexer()).Tunnels(namespace)
+}
+
+// multiNamespaceServiceLister looks up Services in the lister for the
+// namespace they belong to.
+type multiNamespaceServiceLister map[string]corelisters.ServiceLister
+
+func (m multiNamespaceServiceLister) List(selector labels.Selector) ([]*corev1.Service, error) {
+	ret := []*corev1.Service{}
+	for _, l := range m {
+		items, err := l.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, items...)
+	}
+	return ret, nil
+}
+
+func (m multiNamespaceServiceLister) Services(namespace string) corelisters.ServiceNamespaceLister {
+	if l, ok := m[namespace]; ok {
+		return l.Services(namespace)
+	}
+	if l, ok := m[""]; ok {
+		return l.Services(namespace)
+	}
+	return corelisters.NewServiceLister(newNamespaceIndexer()).Services(namespace)
+}
+
+// multiNamespaceTunnelGrantLister looks up TunnelGrants in the lister for
+// the namespace they belong to.
+type multiNamespaceTunnelGrantLister map[string]listers.TunnelGrantLister
+
+func (m multiNamespaceTunnelGrantLister) List(selector labels.Selector) ([]*inletsv1alpha1.TunnelGrant, error) {
+	ret := []*inletsv1alpha1.TunnelGrant{}
+	for _, l := range m {
+		items, err := l.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, items...)
+	}
+	return ret, nil
+}
+
+func (m multiNamespaceTunnelGrantLister) TunnelGrants(namespace string) listers.TunnelGrantNamespaceLister {
+	if l, ok := m[namespace]; ok {
+		return l.TunnelGrants(namespace)
+	}
+	if l, ok := m[""]; ok {
+		return l.TunnelGrants(namespace)
+	}
+	return listers.NewTunnelGrantLister(newNamespaceIndexer()).TunnelGrants(namespace)
+}
+
+func newNamespaceIndexer() cache.Indexer {
+	return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+	})
+}
+
+// allSynced combines the HasSynced funcs from each watched namespace.
+func allSynced(synced []cache.InformerSynced) cache.InformerSynced {
+	return func() bool {
+		for _, s := range synced {
+			if !s() {
+				return false
+			}
+		}
+		return true
+	}
+}
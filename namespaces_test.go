@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+func Test_parseNamespaces(t *testing.T) {
+	got := parseNamespaces(" team-a,,team-b ")
+	want := []string{"team-a", "team-b"}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+
+	if got := parseNamespaces(""); len(got) != 0 {
+		t.Fatalf("want no namespaces, but got %v", got)
+	}
+}
+
+func Test_multiNamespaceServiceLister_Services(t *testing.T) {
+	indexer := newNamespaceIndexer()
+	indexer.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "team-a"},
+	})
+
+	lister := multiNamespaceServiceLister{
+		"team-a": corelisters.NewServiceLister(indexer),
+	}
+
+	if _, err := lister.Services("team-a").Get("nginx"); err != nil {
+		t.Fatalf("want service in watched namespace, got error: %s", err)
+	}
+
+	if _, err := lister.Services("team-b").Get("nginx"); !errors.IsNotFound(err) {
+		t.Fatalf("want not found for unwatched namespace, got: %v", err)
+	}
+}
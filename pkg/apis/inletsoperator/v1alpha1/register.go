@@ -47,6 +47,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&Tunnel{},
 		&TunnelList{},
+		&ExitServerPool{},
+		&ExitServerPoolList{},
+		&TunnelGrant{},
+		&TunnelGrantList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
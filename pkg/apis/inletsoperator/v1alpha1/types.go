@@ -31,8 +31,11 @@ import (
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 // +kubebuilder:printcolumn:name="HostID",priority=1,type=string,JSONPath=`.status.hostId`
 // +kubebuilder:printcolumn:name="UpdateServiceIP",priority=1,type=boolean,JSONPath=`.spec.updateServiceIP`
+// +kubebuilder:printcolumn:name="Region",priority=1,type=string,JSONPath=`.spec.region`
 // +kubebuilder:printcolumn:name="Generated",priority=1,type=boolean,JSONPath=`.status.generated`
+// +kubebuilder:printcolumn:name="Clients",priority=1,type=integer,JSONPath=`.status.connection.clients`
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 type Tunnel struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -45,7 +48,9 @@ type Tunnel struct {
 
 // TunnelSpec is the spec for a Tunnel resource
 type TunnelSpec struct {
-	// ServiceRef is the internal service to tunnel to the remote host
+	// ServiceRef is the internal service to tunnel to the remote host,
+	// unless Upstream is given. A Service in another namespace needs a
+	// TunnelGrant there
 	ServiceRef *ResourceRef `json:"serviceRef,omitempty"`
 
 	// +nullable
@@ -63,6 +68,34 @@ type TunnelSpec struct {
 	// +nullable
 	// +kubebuilder:validation:Optional
 	UpdateServiceIP bool `json:"updateServiceIP,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Region overrides the region configured for the operator, so that
+	// the exit server for this tunnel can be placed elsewhere
+	Region string `json:"region,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ExitServerPool is the name of an ExitServerPool in the Tunnel's
+	// namespace to allocate an existing server from, instead of creating
+	// one with the provider
+	ExitServerPool string `json:"exitServerPool,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ShareExitServer allows the Tunnel to use the exit server of another
+	// Tunnel in the same namespace and region, when none of its ports are
+	// in use there. The exit server is deleted with the last Tunnel using it
+	ShareExitServer bool `json:"shareExitServer,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Ports to tunnel to the Service, instead of each of the Service's
+	// ports, i.e. the listener ports of a Gateway
+	Ports []int32 `json:"ports,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Upstream is a host name or IP address reachable from the cluster,
+	// which the client forwards the Ports to instead of a Service, i.e. a
+	// database on-premises
+	Upstream string `json:"upstream,omitempty"`
 }
 
 // TunnelStatus is the status for a Tunnel resource
@@ -87,6 +120,136 @@ type TunnelStatus struct {
 	// +nullable
 	// +kubebuilder:validation:Optional
 	ClientDeploymentRef *ResourceRef `json:"clientDeploymentRef,omitempty"`
+
+	// +nullable
+	// +kubebuilder:validation:Optional
+	// Firewall records the rules applied to the exit server
+	Firewall *FirewallStatus `json:"firewall,omitempty"`
+
+	// +nullable
+	// +kubebuilder:validation:Optional
+	// TLS is set when the exit server was given a certificate issued by
+	// the operator's CA, which the client pins
+	TLS *TLSStatus `json:"tls,omitempty"`
+
+	// +nullable
+	// +kubebuilder:validation:Optional
+	// PoolAllocation is set when the exit server was allocated from an
+	// ExitServerPool, it is returned to the pool when the Tunnel is deleted
+	PoolAllocation *PoolAllocationStatus `json:"poolAllocation,omitempty"`
+
+	// +nullable
+	// +kubebuilder:validation:Optional
+	// Cost is the estimated cost of the exit server created for the Tunnel
+	Cost *CostStatus `json:"cost,omitempty"`
+
+	// +nullable
+	// +kubebuilder:validation:Optional
+	// Connection is the tunnel client's connection to the exit server, as
+	// last reported by the inlets-pro server
+	Connection *ConnectionStatus `json:"connection,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// Conditions are the latest observations of the Tunnel's state
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+const (
+	// TunnelQuotaExceeded is True when no exit server can be created for
+	// the Tunnel without exceeding a quota set for the operator
+	TunnelQuotaExceeded = "QuotaExceeded"
+
+	// TunnelConnected is True when a tunnel client is connected to the
+	// exit server
+	TunnelConnected = "Connected"
+
+	// TunnelProviderUnavailable is True when calls to the provider are
+	// paused, after it rate limited the operator or failed too many times
+	// in a row
+	TunnelProviderUnavailable = "ProviderUnavailable"
+
+	// TunnelFirewallOutOfDate is True when the Service's source ranges
+	// differ from those written to the exit server's own firewall when it
+	// was created, and the Tunnel must be recreated to apply them
+	TunnelFirewallOutOfDate = "FirewallOutOfDate"
+)
+
+// ConnectionStatus is the tunnel client's connection to the exit server,
+// as reported by the inlets-pro server's status endpoint
+type ConnectionStatus struct {
+	// Clients is the number of tunnel clients connected to the exit server
+	Clients int32 `json:"clients"`
+
+	// +optional
+	// ConnectedAt is when the longest connected client connected
+	ConnectedAt *metav1.Time `json:"connectedAt,omitempty"`
+
+	// +optional
+	// LastSeen is the last time a client was seen connected
+	LastSeen *metav1.Time `json:"lastSeen,omitempty"`
+
+	// +optional
+	// Ports forwarded by the connected clients
+	Ports []int32 `json:"ports,omitempty"`
+
+	// +optional
+	// LastChecked is when the status endpoint was last queried
+	LastChecked *metav1.Time `json:"lastChecked,omitempty"`
+
+	// +optional
+	// Error from the last query of the status endpoint, empty when it
+	// succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// CostStatus is the estimated cost of an exit server, from the operator's
+// price table
+type CostStatus struct {
+	Provider string `json:"provider,omitempty"`
+	Plan     string `json:"plan,omitempty"`
+
+	// Hourly is the estimated cost per hour, empty when the plan has no
+	// price
+	Hourly string `json:"hourly,omitempty"`
+
+	// Monthly is the estimated cost per month, empty when the plan has
+	// no price
+	Monthly string `json:"monthly,omitempty"`
+}
+
+// PoolAllocationStatus records the server allocated to a Tunnel
+type PoolAllocationStatus struct {
+	// Pool is the name of the ExitServerPool
+	Pool string `json:"pool,omitempty"`
+
+	// Server is the name of the server within the pool
+	Server string `json:"server,omitempty"`
+}
+
+// TLSStatus records the certificate issued to the exit server
+type TLSStatus struct {
+	// ServerName is the name in the exit server's certificate, which
+	// the client resolves to the HostIP
+	ServerName string `json:"serverName,omitempty"`
+
+	// CASecretRef is the Secret holding the CA certificate for the client
+	CASecretRef *ResourceRef `json:"caSecretRef,omitempty"`
+}
+
+// FirewallStatus records the rules applied to the exit server's firewall
+type FirewallStatus struct {
+	// ID of the cloud firewall created for the exit server, empty when
+	// the rules were written to the host's own firewall instead
+	ID string `json:"id,omitempty"`
+
+	// Ports open to the SourceRanges
+	Ports []int32 `json:"ports,omitempty"`
+
+	// SourceRanges allowed to connect to the Ports, from the Service's
+	// spec.loadBalancerSourceRanges
+	SourceRanges []string `json:"sourceRanges,omitempty"`
 }
 
 // ResourceRef references resources across namespaces
@@ -104,3 +267,134 @@ type TunnelList struct {
 
 	Items []Tunnel `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ExitServerPool lists existing exit servers which Tunnels can be
+// allocated from, the operator never creates or deletes them
+// +kubebuilder:printcolumn:name="Servers",type=integer,JSONPath=`.status.servers`
+// +kubebuilder:printcolumn:name="Allocated",type=integer,JSONPath=`.status.allocated`
+// +kubebuilder:printcolumn:name="Capacity",type=integer,JSONPath=`.status.capacity`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+type ExitServerPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ExitServerPoolSpec `json:"spec,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Status ExitServerPoolStatus `json:"status,omitempty"`
+}
+
+// ExitServerPoolSpec is the spec for an ExitServerPool resource
+type ExitServerPoolSpec struct {
+	// Servers are the exit servers in the pool, each runs inlets-pro
+	// tcp server with its control port on 8123
+	Servers []PoolServer `json:"servers,omitempty"`
+}
+
+// PoolServer is an existing exit server
+type PoolServer struct {
+	// Name identifies the server within the pool
+	Name string `json:"name"`
+
+	// IP is the public IP address of the server
+	IP string `json:"ip"`
+
+	// TokenSecretName is a Secret in the pool's namespace with the
+	// server's auth token under the "token" key
+	TokenSecretName string `json:"tokenSecretName"`
+
+	// +kubebuilder:validation:Optional
+	// Capacity is the number of Tunnels which can share the server, the
+	// default is 1
+	Capacity int32 `json:"capacity,omitempty"`
+}
+
+// ExitServerPoolStatus is the status for an ExitServerPool resource
+type ExitServerPoolStatus struct {
+	// Allocations are the Tunnels using each server
+	Allocations []PoolAllocation `json:"allocations,omitempty"`
+
+	// Servers is the number of servers in the pool
+	Servers int32 `json:"servers,omitempty"`
+
+	// Allocated is the number of Tunnels allocated from the pool
+	Allocated int32 `json:"allocated,omitempty"`
+
+	// Capacity is the number of Tunnels the pool can hold
+	Capacity int32 `json:"capacity,omitempty"`
+}
+
+// PoolAllocation is a Tunnel using a server from the pool
+type PoolAllocation struct {
+	// Server is the name of the server within the pool
+	Server string `json:"server"`
+
+	// Tunnel is the name of the Tunnel in the pool's namespace
+	Tunnel string `json:"tunnel"`
+
+	// Ports are the Tunnel's ports on the server, which no other Tunnel
+	// allocated to it may use
+	// +optional
+	Ports []int32 `json:"ports,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ExitServerPoolList is a list of ExitServerPool resources
+type ExitServerPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ExitServerPool `json:"items"`
+}
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelGrant allows Tunnels in other namespaces to reference Services in
+// its namespace, in the same way as the Gateway API's ReferenceGrant
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type TunnelGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TunnelGrantSpec `json:"spec,omitempty"`
+}
+
+// TunnelGrantSpec is the spec for a TunnelGrant resource
+type TunnelGrantSpec struct {
+	// From are the namespaces whose Tunnels may reference the Services
+	From []TunnelGrantFrom `json:"from"`
+
+	// +kubebuilder:validation:Optional
+	// To are the Services which may be referenced, when empty any Service
+	// in the namespace may be
+	To []TunnelGrantTo `json:"to,omitempty"`
+}
+
+// TunnelGrantFrom is a namespace whose Tunnels are granted access
+type TunnelGrantFrom struct {
+	// Namespace of the Tunnels
+	Namespace string `json:"namespace"`
+}
+
+// TunnelGrantTo is a Service which may be referenced
+type TunnelGrantTo struct {
+	// Name of the Service
+	Name string `json:"name"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelGrantList is a list of TunnelGrant resources
+type TunnelGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TunnelGrant `json:"items"`
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Tunnel is a specification for a Tunnel resource
+// +kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.serviceRef.name`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.status.exitServer.ip`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:printcolumn:name="ID",priority=1,type=string,JSONPath=`.status.exitServer.id`
+// +kubebuilder:printcolumn:name="Region",priority=1,type=string,JSONPath=`.spec.exitServer.region`
+// +kubebuilder:printcolumn:name="Clients",priority=1,type=integer,JSONPath=`.status.connection.clients`
+// +kubebuilder:subresource:status
+type Tunnel struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TunnelSpec `json:"spec,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Status TunnelStatus `json:"status,omitempty"`
+}
+
+// TunnelSpec is the spec for a Tunnel resource
+type TunnelSpec struct {
+	// +kubebuilder:validation:Optional
+	// ServiceRef is the Service to expose through the exit server, unless
+	// Upstream is given. A Service in another namespace needs a
+	// TunnelGrant there
+	ServiceRef *ObjectReference `json:"serviceRef,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AuthTokenRef is a Secret with the token for the exit server under
+	// the "token" key, one is generated when it is not given
+	AuthTokenRef *ObjectReference `json:"authTokenRef,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Update;None
+	// +kubebuilder:default=None
+	// ServiceIPPolicy is Update to write the exit server's IP to the
+	// Service, the Tunnels the operator creates for LoadBalancer
+	// Services always do so
+	ServiceIPPolicy ServiceIPPolicy `json:"serviceIPPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ExitServer sets where the exit server comes from
+	ExitServer ExitServerSpec `json:"exitServer,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Ports to tunnel to the Service, instead of each of the Service's
+	// ports
+	Ports []int32 `json:"ports,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Upstream is a host name or IP address reachable from the cluster,
+	// which the client forwards the Ports to instead of a Service
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// ServiceIPPolicy sets whether the Service is updated with the exit
+// server's IP
+type ServiceIPPolicy string
+
+const (
+	// ServiceIPPolicyUpdate writes the exit server's IP to the Service
+	ServiceIPPolicyUpdate ServiceIPPolicy = "Update"
+	// ServiceIPPolicyNone leaves the Service unchanged
+	ServiceIPPolicyNone ServiceIPPolicy = "None"
+)
+
+// ExitServerSpec sets where the Tunnel's exit server comes from
+type ExitServerSpec struct {
+	// +kubebuilder:validation:Optional
+	// Region overrides the region configured for the operator
+	Region string `json:"region,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Pool is the name of an ExitServerPool in the Tunnel's namespace to
+	// allocate an existing server from, instead of creating one
+	Pool string `json:"pool,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Shared allows the exit server of another Tunnel in the same
+	// namespace and region to be used, when none of its ports are in use
+	Shared bool `json:"shared,omitempty"`
+}
+
+// TunnelPhase is where the Tunnel is in the lifecycle of its exit server
+type TunnelPhase string
+
+const (
+	// TunnelPhasePending is a Tunnel without an exit server
+	TunnelPhasePending TunnelPhase = "Pending"
+	// TunnelPhaseProvisioning is a Tunnel waiting for its exit server to
+	// start
+	TunnelPhaseProvisioning TunnelPhase = "Provisioning"
+	// TunnelPhaseActive is a Tunnel with a running exit server
+	TunnelPhaseActive TunnelPhase = "Active"
+)
+
+// TunnelStatus is the status for a Tunnel resource
+type TunnelStatus struct {
+	// +optional
+	Phase TunnelPhase `json:"phase,omitempty"`
+
+	// +optional
+	// Generated is true when the Tunnel was created by the operator for a
+	// LoadBalancer Service
+	Generated bool `json:"generated,omitempty"`
+
+	// +optional
+	// ExitServer is the exit server used by the Tunnel
+	ExitServer ExitServerStatus `json:"exitServer,omitempty"`
+
+	// +optional
+	// AuthTokenRef is the Secret with the token used by the exit server
+	AuthTokenRef *ObjectReference `json:"authTokenRef,omitempty"`
+
+	// +optional
+	// ClientDeploymentRef is the Deployment running the tunnel client
+	ClientDeploymentRef *ObjectReference `json:"clientDeploymentRef,omitempty"`
+
+	// +optional
+	// Connection is the tunnel client's connection to the exit server, as
+	// last reported by the inlets-pro server
+	Connection *ConnectionStatus `json:"connection,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// Conditions are the latest observations of the Tunnel's state
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// ConnectionStatus is the tunnel client's connection to the exit server,
+// as reported by the inlets-pro server's status endpoint
+type ConnectionStatus struct {
+	// Clients is the number of tunnel clients connected to the exit server
+	Clients int32 `json:"clients"`
+
+	// +optional
+	// ConnectedAt is when the longest connected client connected
+	ConnectedAt *metav1.Time `json:"connectedAt,omitempty"`
+
+	// +optional
+	// LastSeen is the last time a client was seen connected
+	LastSeen *metav1.Time `json:"lastSeen,omitempty"`
+
+	// +optional
+	// Ports forwarded by the connected clients
+	Ports []int32 `json:"ports,omitempty"`
+
+	// +optional
+	// LastChecked is when the status endpoint was last queried
+	LastChecked *metav1.Time `json:"lastChecked,omitempty"`
+
+	// +optional
+	// Error from the last query of the status endpoint, empty when it
+	// succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// ExitServerStatus records the exit server used by the Tunnel
+type ExitServerStatus struct {
+	// ID of the exit server with the provider
+	ID string `json:"id,omitempty"`
+
+	// IP is the public IP address of the exit server
+	IP string `json:"ip,omitempty"`
+
+	// +optional
+	// Firewall records the rules applied to the exit server
+	Firewall *FirewallStatus `json:"firewall,omitempty"`
+
+	// +optional
+	// TLS is set when the exit server was given a certificate issued by
+	// the operator's CA, which the client pins
+	TLS *TLSStatus `json:"tls,omitempty"`
+
+	// +optional
+	// PoolAllocation is set when the exit server was allocated from an
+	// ExitServerPool
+	PoolAllocation *PoolAllocationStatus `json:"poolAllocation,omitempty"`
+
+	// +optional
+	// Cost is the estimated cost of the exit server
+	Cost *CostStatus `json:"cost,omitempty"`
+}
+
+// CostStatus is the estimated cost of an exit server, from the operator's
+// price table
+type CostStatus struct {
+	Provider string `json:"provider,omitempty"`
+	Plan     string `json:"plan,omitempty"`
+
+	// Hourly is the estimated cost per hour, empty when the plan has no
+	// price
+	Hourly string `json:"hourly,omitempty"`
+
+	// Monthly is the estimated cost per month, empty when the plan has
+	// no price
+	Monthly string `json:"monthly,omitempty"`
+}
+
+// PoolAllocationStatus records the server allocated to a Tunnel
+type PoolAllocationStatus struct {
+	// Pool is the name of the ExitServerPool
+	Pool string `json:"pool,omitempty"`
+
+	// Server is the name of the server within the pool
+	Server string `json:"server,omitempty"`
+}
+
+// TLSStatus records the certificate issued to the exit server
+type TLSStatus struct {
+	// ServerName is the name in the exit server's certificate, which
+	// the client resolves to the exit server's IP
+	ServerName string `json:"serverName,omitempty"`
+
+	// CASecretRef is the Secret holding the CA certificate for the client
+	CASecretRef *ObjectReference `json:"caSecretRef,omitempty"`
+}
+
+// FirewallStatus records the rules applied to the exit server's firewall
+type FirewallStatus struct {
+	// ID of the cloud firewall created for the exit server, empty when
+	// the rules were written to the host's own firewall instead
+	ID string `json:"id,omitempty"`
+
+	// Ports open to the SourceRanges
+	Ports []int32 `json:"ports,omitempty"`
+
+	// SourceRanges allowed to connect to the Ports, from the Service's
+	// spec.loadBalancerSourceRanges
+	SourceRanges []string `json:"sourceRanges,omitempty"`
+}
+
+// ObjectReference refers to an object by name, in the Tunnel's namespace
+// unless Namespace is given
+type ObjectReference struct {
+	Name string `json:"name"`
+
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelList is a list of Tunnel resources
+type TunnelList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []Tunnel `json:"items"`
+}
@@ -0,0 +1,142 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeExitServerPools implements ExitServerPoolInterface
+type FakeExitServerPools struct {
+	Fake *FakeOperatorV1alpha1
+	ns   string
+}
+
+var exitServerPoolsResource = schema.GroupVersionResource{Group: "operator.inlets.dev", Version: "v1alpha1", Resource: "exitserverpools"}
+
+var exitServerPoolsKind = schema.GroupVersionKind{Group: "operator.inlets.dev", Version: "v1alpha1", Kind: "ExitServerPool"}
+
+// Get takes name of the exitServerPool, and returns the corresponding exitServerPool object, and an error if there is any.
+func (c *FakeExitServerPools) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.ExitServerPool, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(exitServerPoolsResource, c.ns, name), &v1alpha1.ExitServerPool{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExitServerPool), err
+}
+
+// List takes label and field selectors, and returns the list of ExitServerPools that match those selectors.
+func (c *FakeExitServerPools) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.ExitServerPoolList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(exitServerPoolsResource, exitServerPoolsKind, c.ns, opts), &v1alpha1.ExitServerPoolList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.ExitServerPoolList{ListMeta: obj.(*v1alpha1.ExitServerPoolList).ListMeta}
+	for _, item := range obj.(*v1alpha1.ExitServerPoolList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested exitServerPools.
+func (c *FakeExitServerPools) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(exitServerPoolsResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a exitServerPool and creates it.  Returns the server's representation of the exitServerPool, and an error, if there is any.
+func (c *FakeExitServerPools) Create(ctx context.Context, exitServerPool *v1alpha1.ExitServerPool, opts v1.CreateOptions) (result *v1alpha1.ExitServerPool, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(exitServerPoolsResource, c.ns, exitServerPool), &v1alpha1.ExitServerPool{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExitServerPool), err
+}
+
+// Update takes the representation of a exitServerPool and updates it. Returns the server's representation of the exitServerPool, and an error, if there is any.
+func (c *FakeExitServerPools) Update(ctx context.Context, exitServerPool *v1alpha1.ExitServerPool, opts v1.UpdateOptions) (result *v1alpha1.ExitServerPool, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(exitServerPoolsResource, c.ns, exitServerPool), &v1alpha1.ExitServerPool{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExitServerPool), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeExitServerPools) UpdateStatus(ctx context.Context, exitServerPool *v1alpha1.ExitServerPool, opts v1.UpdateOptions) (*v1alpha1.ExitServerPool, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(exitServerPoolsResource, "status", c.ns, exitServerPool), &v1alpha1.ExitServerPool{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExitServerPool), err
+}
+
+// Delete takes name of the exitServerPool and deletes it. Returns an error if one occurs.
+func (c *FakeExitServerPools) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteActionWithOptions(exitServerPoolsResource, c.ns, name, opts), &v1alpha1.ExitServerPool{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeExitServerPools) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(exitServerPoolsResource, c.ns, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.ExitServerPoolList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched exitServerPool.
+func (c *FakeExitServerPools) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.ExitServerPool, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(exitServerPoolsResource, c.ns, name, pt, data, subresources...), &v1alpha1.ExitServerPool{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.ExitServerPool), err
+}
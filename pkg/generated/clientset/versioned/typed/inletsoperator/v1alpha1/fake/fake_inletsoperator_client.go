@@ -28,10 +28,18 @@ type FakeOperatorV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeOperatorV1alpha1) ExitServerPools(namespace string) v1alpha1.ExitServerPoolInterface {
+	return &FakeExitServerPools{c, namespace}
+}
+
 func (c *FakeOperatorV1alpha1) Tunnels(namespace string) v1alpha1.TunnelInterface {
 	return &FakeTunnels{c, namespace}
 }
 
+func (c *FakeOperatorV1alpha1) TunnelGrants(namespace string) v1alpha1.TunnelGrantInterface {
+	return &FakeTunnelGrants{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeOperatorV1alpha1) RESTClient() rest.Interface {
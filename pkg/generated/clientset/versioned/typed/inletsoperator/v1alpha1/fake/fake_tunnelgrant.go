@@ -0,0 +1,130 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeTunnelGrants implements TunnelGrantInterface
+type FakeTunnelGrants struct {
+	Fake *FakeOperatorV1alpha1
+	ns   string
+}
+
+var tunnelGrantsResource = schema.GroupVersionResource{Group: "operator.inlets.dev", Version: "v1alpha1", Resource: "tunnelgrants"}
+
+var tunnelGrantsKind = schema.GroupVersionKind{Group: "operator.inlets.dev", Version: "v1alpha1", Kind: "TunnelGrant"}
+
+// Get takes name of the tunnelGrant, and returns the corresponding tunnelGrant object, and an error if there is any.
+func (c *FakeTunnelGrants) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.TunnelGrant, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(tunnelGrantsResource, c.ns, name), &v1alpha1.TunnelGrant{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.TunnelGrant), err
+}
+
+// List takes label and field selectors, and returns the list of TunnelGrants that match those selectors.
+func (c *FakeTunnelGrants) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.TunnelGrantList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(tunnelGrantsResource, tunnelGrantsKind, c.ns, opts), &v1alpha1.TunnelGrantList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.TunnelGrantList{ListMeta: obj.(*v1alpha1.TunnelGrantList).ListMeta}
+	for _, item := range obj.(*v1alpha1.TunnelGrantList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelGrants.
+func (c *FakeTunnelGrants) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(tunnelGrantsResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a tunnelGrant and creates it.  Returns the server's representation of the tunnelGrant, and an error, if there is any.
+func (c *FakeTunnelGrants) Create(ctx context.Context, tunnelGrant *v1alpha1.TunnelGrant, opts v1.CreateOptions) (result *v1alpha1.TunnelGrant, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(tunnelGrantsResource, c.ns, tunnelGrant), &v1alpha1.TunnelGrant{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.TunnelGrant), err
+}
+
+// Update takes the representation of a tunnelGrant and updates it. Returns the server's representation of the tunnelGrant, and an error, if there is any.
+func (c *FakeTunnelGrants) Update(ctx context.Context, tunnelGrant *v1alpha1.TunnelGrant, opts v1.UpdateOptions) (result *v1alpha1.TunnelGrant, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(tunnelGrantsResource, c.ns, tunnelGrant), &v1alpha1.TunnelGrant{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.TunnelGrant), err
+}
+
+// Delete takes name of the tunnelGrant and deletes it. Returns an error if one occurs.
+func (c *FakeTunnelGrants) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteActionWithOptions(tunnelGrantsResource, c.ns, name, opts), &v1alpha1.TunnelGrant{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeTunnelGrants) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(tunnelGrantsResource, c.ns, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.TunnelGrantList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched tunnelGrant.
+func (c *FakeTunnelGrants) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.TunnelGrant, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(tunnelGrantsResource, c.ns, name, pt, data, subresources...), &v1alpha1.TunnelGrant{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.TunnelGrant), err
+}
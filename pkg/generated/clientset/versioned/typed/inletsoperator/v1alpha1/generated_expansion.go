@@ -18,4 +18,8 @@ limitations under the License.
 
 package v1alpha1
 
+type ExitServerPoolExpansion interface{}
+
 type TunnelExpansion interface{}
+
+type TunnelGrantExpansion interface{}
@@ -28,7 +28,9 @@ import (
 
 type OperatorV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	ExitServerPoolsGetter
 	TunnelsGetter
+	TunnelGrantsGetter
 }
 
 // OperatorV1alpha1Client is used to interact with features provided by the operator.inlets.dev group.
@@ -36,10 +38,18 @@ type OperatorV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *OperatorV1alpha1Client) ExitServerPools(namespace string) ExitServerPoolInterface {
+	return newExitServerPools(c, namespace)
+}
+
 func (c *OperatorV1alpha1Client) Tunnels(namespace string) TunnelInterface {
 	return newTunnels(c, namespace)
 }
 
+func (c *OperatorV1alpha1Client) TunnelGrants(namespace string) TunnelGrantInterface {
+	return newTunnelGrants(c, namespace)
+}
+
 // NewForConfig creates a new OperatorV1alpha1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
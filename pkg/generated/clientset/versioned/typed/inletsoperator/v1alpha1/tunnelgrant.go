@@ -0,0 +1,178 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"context"
+	"time"
+
+	v1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	scheme "github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// TunnelGrantsGetter has a method to return a TunnelGrantInterface.
+// A group's client should implement this interface.
+type TunnelGrantsGetter interface {
+	TunnelGrants(namespace string) TunnelGrantInterface
+}
+
+// TunnelGrantInterface has methods to work with TunnelGrant resources.
+type TunnelGrantInterface interface {
+	Create(ctx context.Context, tunnelGrant *v1alpha1.TunnelGrant, opts v1.CreateOptions) (*v1alpha1.TunnelGrant, error)
+	Update(ctx context.Context, tunnelGrant *v1alpha1.TunnelGrant, opts v1.UpdateOptions) (*v1alpha1.TunnelGrant, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1alpha1.TunnelGrant, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1alpha1.TunnelGrantList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.TunnelGrant, err error)
+	TunnelGrantExpansion
+}
+
+// tunnelGrants implements TunnelGrantInterface
+type tunnelGrants struct {
+	client rest.Interface
+	ns     string
+}
+
+// newTunnelGrants returns a TunnelGrants
+func newTunnelGrants(c *OperatorV1alpha1Client, namespace string) *tunnelGrants {
+	return &tunnelGrants{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the tunnelGrant, and returns the corresponding tunnelGrant object, and an error if there is any.
+func (c *tunnelGrants) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.TunnelGrant, err error) {
+	result = &v1alpha1.TunnelGrant{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of TunnelGrants that match those selectors.
+func (c *tunnelGrants) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.TunnelGrantList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1alpha1.TunnelGrantList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelGrants.
+func (c *tunnelGrants) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a tunnelGrant and creates it.  Returns the server's representation of the tunnelGrant, and an error, if there is any.
+func (c *tunnelGrants) Create(ctx context.Context, tunnelGrant *v1alpha1.TunnelGrant, opts v1.CreateOptions) (result *v1alpha1.TunnelGrant, err error) {
+	result = &v1alpha1.TunnelGrant{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(tunnelGrant).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a tunnelGrant and updates it. Returns the server's representation of the tunnelGrant, and an error, if there is any.
+func (c *tunnelGrants) Update(ctx context.Context, tunnelGrant *v1alpha1.TunnelGrant, opts v1.UpdateOptions) (result *v1alpha1.TunnelGrant, err error) {
+	result = &v1alpha1.TunnelGrant{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		Name(tunnelGrant.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(tunnelGrant).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the tunnelGrant and deletes it. Returns an error if one occurs.
+func (c *tunnelGrants) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *tunnelGrants) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched tunnelGrant.
+func (c *tunnelGrants) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.TunnelGrant, err error) {
+	result = &v1alpha1.TunnelGrant{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("tunnelgrants").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	v1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	v1beta1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1beta1"
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	cache "k8s.io/client-go/tools/cache"
 )
@@ -53,8 +54,16 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=operator.inlets.dev, Version=v1alpha1
+	case v1alpha1.SchemeGroupVersion.WithResource("exitserverpools"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Operator().V1alpha1().ExitServerPools().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("tunnels"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Operator().V1alpha1().Tunnels().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("tunnelgrants"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Operator().V1alpha1().TunnelGrants().Informer()}, nil
+
+		// Group=operator.inlets.dev, Version=v1beta1
+	case v1beta1.SchemeGroupVersion.WithResource("tunnels"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Operator().V1beta1().Tunnels().Informer()}, nil
 
 	}
 
@@ -24,8 +24,12 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ExitServerPools returns a ExitServerPoolInformer.
+	ExitServerPools() ExitServerPoolInformer
 	// Tunnels returns a TunnelInformer.
 	Tunnels() TunnelInformer
+	// TunnelGrants returns a TunnelGrantInformer.
+	TunnelGrants() TunnelGrantInformer
 }
 
 type version struct {
@@ -39,7 +43,17 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ExitServerPools returns a ExitServerPoolInformer.
+func (v *version) ExitServerPools() ExitServerPoolInformer {
+	return &exitServerPoolInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // Tunnels returns a TunnelInformer.
 func (v *version) Tunnels() TunnelInformer {
 	return &tunnelInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// TunnelGrants returns a TunnelGrantInformer.
+func (v *version) TunnelGrants() TunnelGrantInformer {
+	return &tunnelGrantInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	time "time"
+
+	inletsoperatorv1beta1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1beta1"
+	versioned "github.com/inlets/inlets-operator/pkg/generated/clientset/versioned"
+	internalinterfaces "github.com/inlets/inlets-operator/pkg/generated/informers/externalversions/internalinterfaces"
+	v1beta1 "github.com/inlets/inlets-operator/pkg/generated/listers/inletsoperator/v1beta1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// TunnelInformer provides access to a shared informer and lister for
+// Tunnels.
+type TunnelInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1beta1.TunnelLister
+}
+
+type tunnelInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewTunnelInformer constructs a new informer for Tunnel type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewTunnelInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredTunnelInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredTunnelInformer constructs a new informer for Tunnel type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredTunnelInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.OperatorV1beta1().Tunnels(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.OperatorV1beta1().Tunnels(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&inletsoperatorv1beta1.Tunnel{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *tunnelInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredTunnelInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *tunnelInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&inletsoperatorv1beta1.Tunnel{}, f.defaultInformer)
+}
+
+func (f *tunnelInformer) Lister() v1beta1.TunnelLister {
+	return v1beta1.NewTunnelLister(f.Informer().GetIndexer())
+}
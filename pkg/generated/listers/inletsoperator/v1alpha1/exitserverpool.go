@@ -0,0 +1,99 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ExitServerPoolLister helps list ExitServerPools.
+// All objects returned here must be treated as read-only.
+type ExitServerPoolLister interface {
+	// List lists all ExitServerPools in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.ExitServerPool, err error)
+	// ExitServerPools returns an object that can list and get ExitServerPools.
+	ExitServerPools(namespace string) ExitServerPoolNamespaceLister
+	ExitServerPoolListerExpansion
+}
+
+// exitServerPoolLister implements the ExitServerPoolLister interface.
+type exitServerPoolLister struct {
+	indexer cache.Indexer
+}
+
+// NewExitServerPoolLister returns a new ExitServerPoolLister.
+func NewExitServerPoolLister(indexer cache.Indexer) ExitServerPoolLister {
+	return &exitServerPoolLister{indexer: indexer}
+}
+
+// List lists all ExitServerPools in the indexer.
+func (s *exitServerPoolLister) List(selector labels.Selector) (ret []*v1alpha1.ExitServerPool, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.ExitServerPool))
+	})
+	return ret, err
+}
+
+// ExitServerPools returns an object that can list and get ExitServerPools.
+func (s *exitServerPoolLister) ExitServerPools(namespace string) ExitServerPoolNamespaceLister {
+	return exitServerPoolNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// ExitServerPoolNamespaceLister helps list and get ExitServerPools.
+// All objects returned here must be treated as read-only.
+type ExitServerPoolNamespaceLister interface {
+	// List lists all ExitServerPools in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.ExitServerPool, err error)
+	// Get retrieves the ExitServerPool from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*v1alpha1.ExitServerPool, error)
+	ExitServerPoolNamespaceListerExpansion
+}
+
+// exitServerPoolNamespaceLister implements the ExitServerPoolNamespaceLister
+// interface.
+type exitServerPoolNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all ExitServerPools in the indexer for a given namespace.
+func (s exitServerPoolNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.ExitServerPool, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.ExitServerPool))
+	})
+	return ret, err
+}
+
+// Get retrieves the ExitServerPool from the indexer for a given namespace and name.
+func (s exitServerPoolNamespaceLister) Get(name string) (*v1alpha1.ExitServerPool, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("exitserverpool"), name)
+	}
+	return obj.(*v1alpha1.ExitServerPool), nil
+}
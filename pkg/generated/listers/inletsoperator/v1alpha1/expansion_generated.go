@@ -18,6 +18,14 @@ limitations under the License.
 
 package v1alpha1
 
+// ExitServerPoolListerExpansion allows custom methods to be added to
+// ExitServerPoolLister.
+type ExitServerPoolListerExpansion interface{}
+
+// ExitServerPoolNamespaceListerExpansion allows custom methods to be added to
+// ExitServerPoolNamespaceLister.
+type ExitServerPoolNamespaceListerExpansion interface{}
+
 // TunnelListerExpansion allows custom methods to be added to
 // TunnelLister.
 type TunnelListerExpansion interface{}
@@ -25,3 +33,11 @@ type TunnelListerExpansion interface{}
 // TunnelNamespaceListerExpansion allows custom methods to be added to
 // TunnelNamespaceLister.
 type TunnelNamespaceListerExpansion interface{}
+
+// TunnelGrantListerExpansion allows custom methods to be added to
+// TunnelGrantLister.
+type TunnelGrantListerExpansion interface{}
+
+// TunnelGrantNamespaceListerExpansion allows custom methods to be added to
+// TunnelGrantNamespaceLister.
+type TunnelGrantNamespaceListerExpansion interface{}
@@ -0,0 +1,99 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// TunnelGrantLister helps list TunnelGrants.
+// All objects returned here must be treated as read-only.
+type TunnelGrantLister interface {
+	// List lists all TunnelGrants in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.TunnelGrant, err error)
+	// TunnelGrants returns an object that can list and get TunnelGrants.
+	TunnelGrants(namespace string) TunnelGrantNamespaceLister
+	TunnelGrantListerExpansion
+}
+
+// tunnelGrantLister implements the TunnelGrantLister interface.
+type tunnelGrantLister struct {
+	indexer cache.Indexer
+}
+
+// NewTunnelGrantLister returns a new TunnelGrantLister.
+func NewTunnelGrantLister(indexer cache.Indexer) TunnelGrantLister {
+	return &tunnelGrantLister{indexer: indexer}
+}
+
+// List lists all TunnelGrants in the indexer.
+func (s *tunnelGrantLister) List(selector labels.Selector) (ret []*v1alpha1.TunnelGrant, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.TunnelGrant))
+	})
+	return ret, err
+}
+
+// TunnelGrants returns an object that can list and get TunnelGrants.
+func (s *tunnelGrantLister) TunnelGrants(namespace string) TunnelGrantNamespaceLister {
+	return tunnelGrantNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// TunnelGrantNamespaceLister helps list and get TunnelGrants.
+// All objects returned here must be treated as read-only.
+type TunnelGrantNamespaceLister interface {
+	// List lists all TunnelGrants in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.TunnelGrant, err error)
+	// Get retrieves the TunnelGrant from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*v1alpha1.TunnelGrant, error)
+	TunnelGrantNamespaceListerExpansion
+}
+
+// tunnelGrantNamespaceLister implements the TunnelGrantNamespaceLister
+// interface.
+type tunnelGrantNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all TunnelGrants in the indexer for a given namespace.
+func (s tunnelGrantNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.TunnelGrant, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.TunnelGrant))
+	})
+	return ret, err
+}
+
+// Get retrieves the TunnelGrant from the indexer for a given namespace and name.
+func (s tunnelGrantNamespaceLister) Get(name string) (*v1alpha1.TunnelGrant, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("tunnelgrant"), name)
+	}
+	return obj.(*v1alpha1.TunnelGrant), nil
+}
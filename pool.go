@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	provision "github.com/inlets/cloud-provision/provision"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// exitServerPoolAnnotation on a Service sets spec.exitServerPool on the
+// Tunnels created for it.
+const exitServerPoolAnnotation = "operator.inlets.dev/exit-server-pool"
+
+const (
+	// ErrPoolExhausted is used as part of the Event 'reason' when no
+	// server in the ExitServerPool has capacity for the Tunnel
+	ErrPoolExhausted = "ErrPoolExhausted"
+	// PoolAllocated is used as part of the Event 'reason' when a Tunnel is
+	// allocated a server from an ExitServerPool
+	PoolAllocated = "PoolAllocated"
+)
+
+// errPoolExhausted is returned when all servers in a pool are in use.
+type errPoolExhausted struct {
+	pool string
+}
+
+func (e errPoolExhausted) Error() string {
+	return fmt.Sprintf("no server in ExitServerPool %s has capacity and free ports", e.pool)
+}
+
+func getServerCapacity(server inletsv1alpha1.PoolServer) int32 {
+	if server.Capacity < 1 {
+		return 1
+	}
+	return server.Capacity
+}
+
+// pickPoolServer returns the server already allocated to the Tunnel, or
+// the server with the most free capacity, so that Tunnels are spread out.
+// Servers where another Tunnel already uses one of the ports are skipped,
+// and allocations to servers which have been removed from the pool are
+// ignored.
+func pickPoolServer(pool *inletsv1alpha1.ExitServerPool, tunnel string, ports []int32) (server *inletsv1alpha1.PoolServer, allocated bool) {
+	used := map[string]int32{}
+	usedPorts := map[string]map[int32]bool{}
+	for _, a := range pool.Status.Allocations {
+		for i, s := range pool.Spec.Servers {
+			if s.Name != a.Server {
+				continue
+			}
+			if a.Tunnel == tunnel {
+				return &pool.Spec.Servers[i], true
+			}
+			used[a.Server]++
+			if usedPorts[a.Server] == nil {
+				usedPorts[a.Server] = map[int32]bool{}
+			}
+			for _, p := range a.Ports {
+				usedPorts[a.Server][p] = true
+			}
+		}
+	}
+
+	var free int32
+	for i, s := range pool.Spec.Servers {
+		clash := false
+		for _, p := range ports {
+			if usedPorts[s.Name][p] {
+				clash = true
+				break
+			}
+		}
+		if clash {
+			continue
+		}
+
+		if f := getServerCapacity(s) - used[s.Name]; f > free {
+			server = &pool.Spec.Servers[i]
+			free = f
+		}
+	}
+
+	return server, false
+}
+
+// setPoolCounts summarises the allocations for kubectl get.
+func setPoolCounts(pool *inletsv1alpha1.ExitServerPool) {
+	var capacity int32
+	for _, s := range pool.Spec.Servers {
+		capacity += getServerCapacity(s)
+	}
+
+	pool.Status.Servers = int32(len(pool.Spec.Servers))
+	pool.Status.Allocated = int32(len(pool.Status.Allocations))
+	pool.Status.Capacity = capacity
+}
+
+// allocateFromPool records the Tunnel and its ports against a server in
+// the pool's status, the pool is read from the API rather than the cache, so that
+// a conflict is returned, and retried, if two Tunnels are allocated at
+// once.
+func allocateFromPool(tunnel *inletsv1alpha1.Tunnel, ports []int32, c *Controller) (*inletsv1alpha1.PoolServer, error) {
+	pools := c.operatorclientset.OperatorV1alpha1().
+		ExitServerPools(tunnel.Namespace)
+
+	var server *inletsv1alpha1.PoolServer
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool, err := pools.Get(context.Background(), tunnel.Spec.ExitServerPool, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting ExitServerPool %s: %s", tunnel.Spec.ExitServerPool, err)
+		}
+
+		var allocated bool
+		server, allocated = pickPoolServer(pool, tunnel.Name, ports)
+		if server == nil {
+			return errPoolExhausted{pool: pool.Name}
+		}
+		if allocated {
+			return nil
+		}
+
+		pool.Status.Allocations = append(pool.Status.Allocations, inletsv1alpha1.PoolAllocation{
+			Server: server.Name,
+			Tunnel: tunnel.Name,
+			Ports:  ports,
+		})
+		setPoolCounts(pool)
+
+		_, err = pools.UpdateStatus(context.Background(), pool, metav1.UpdateOptions{})
+		return err
+	})
+
+	return server, err
+}
+
+// releaseToPool removes the Tunnel's allocation, the server itself is
+// left running for the next Tunnel.
+func releaseToPool(tunnel *inletsv1alpha1.Tunnel, c *Controller) error {
+	pools := c.operatorclientset.OperatorV1alpha1().
+		ExitServerPools(tunnel.Namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool, err := pools.Get(context.Background(), tunnel.Status.PoolAllocation.Pool, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		allocations := []inletsv1alpha1.PoolAllocation{}
+		for _, a := range pool.Status.Allocations {
+			if a.Tunnel != tunnel.Name {
+				allocations = append(allocations, a)
+			}
+		}
+		if len(allocations) == len(pool.Status.Allocations) {
+			return nil
+		}
+
+		pool.Status.Allocations = allocations
+		setPoolCounts(pool)
+
+		_, err = pools.UpdateStatus(context.Background(), pool, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// syncPoolAllocation allocates a server from the Tunnel's ExitServerPool,
+// and marks the Tunnel as active with the server's IP and token, in place
+// of creating an exit server with the provider.
+func syncPoolAllocation(tunnel *inletsv1alpha1.Tunnel, c *Controller) error {
+	service, err := c.lookupService(tunnel)
+	if err != nil {
+		return fmt.Errorf("error getting service: %s", err)
+	}
+
+	server, err := allocateFromPool(tunnel, getTunnelPorts(tunnel, service), c)
+	if err != nil {
+		if _, ok := err.(errPoolExhausted); ok {
+			c.recordEvent(tunnel, corev1.EventTypeWarning, ErrPoolExhausted, "%s", err)
+		}
+		return err
+	}
+
+	if _, err := c.kubeclientset.CoreV1().
+		Secrets(tunnel.Namespace).
+		Get(context.Background(), server.TokenSecretName, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("error getting token secret for server %s in ExitServerPool %s: %s",
+			server.Name, tunnel.Spec.ExitServerPool, err)
+	}
+
+	c.tunnelLogger(tunnel).Info("Allocated server from ExitServerPool",
+		"server", server.Name, "ip", server.IP, "pool", tunnel.Spec.ExitServerPool)
+	c.recordEvent(tunnel, corev1.EventTypeNormal, PoolAllocated,
+		"Allocated server %s (%s) from ExitServerPool %s", server.Name, server.IP, tunnel.Spec.ExitServerPool)
+
+	copy := tunnel.DeepCopy()
+	copy.Status.AuthTokenRef = &inletsv1alpha1.ResourceRef{
+		Name:      server.TokenSecretName,
+		Namespace: tunnel.Namespace,
+	}
+	copy.Status.PoolAllocation = &inletsv1alpha1.PoolAllocationStatus{
+		Pool:   tunnel.Spec.ExitServerPool,
+		Server: server.Name,
+	}
+
+	updated, err := c.updateTunnelProvisioningStatus(copy, provision.ActiveStatus, "", server.IP)
+	if err != nil {
+		return fmt.Errorf("tunnel %s.%s (%s) update error: %s", tunnel.Name, tunnel.Namespace, provision.ActiveStatus, err)
+	}
+
+	return c.updateService(updated, server.IP)
+}
+
+// enqueuePoolTunnels enqueues the Tunnels waiting for a server from the
+// pool, after one has been returned to it.
+func (c *Controller) enqueuePoolTunnels(namespace, pool string) {
+	tunnels, err := c.tunnelsLister.Tunnels(namespace).List(labels.Everything())
+	if err != nil {
+		return
+	}
+
+	for _, t := range tunnels {
+		if t.Spec.ExitServerPool == pool && t.Status.PoolAllocation == nil {
+			c.enqueueTunnel(t)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+)
+
+var testPool = inletsv1alpha1.ExitServerPool{
+	ObjectMeta: metav1.ObjectMeta{Name: "site-a", Namespace: "default"},
+	Spec: inletsv1alpha1.ExitServerPoolSpec{
+		Servers: []inletsv1alpha1.PoolServer{
+			{Name: "vm1", IP: "203.0.113.10", TokenSecretName: "vm1-token"},
+			{Name: "vm2", IP: "203.0.113.11", TokenSecretName: "vm2-token", Capacity: 2},
+		},
+	},
+}
+
+func Test_pickPoolServer_MostFree(t *testing.T) {
+	pool := testPool.DeepCopy()
+
+	server, allocated := pickPoolServer(pool, "nginx-tunnel", nil)
+	if server == nil || server.Name != "vm2" || allocated {
+		t.Fatalf("want vm2 to be newly allocated, but got %v, allocated: %t", server, allocated)
+	}
+}
+
+func Test_pickPoolServer_Existing(t *testing.T) {
+	pool := testPool.DeepCopy()
+	pool.Status.Allocations = []inletsv1alpha1.PoolAllocation{
+		{Server: "vm1", Tunnel: "nginx-tunnel"},
+	}
+
+	server, allocated := pickPoolServer(pool, "nginx-tunnel", nil)
+	if server == nil || server.Name != "vm1" || !allocated {
+		t.Fatalf("want existing allocation to vm1, but got %v, allocated: %t", server, allocated)
+	}
+}
+
+func Test_pickPoolServer_Exhausted(t *testing.T) {
+	pool := testPool.DeepCopy()
+	pool.Status.Allocations = []inletsv1alpha1.PoolAllocation{
+		{Server: "vm1", Tunnel: "a-tunnel"},
+		{Server: "vm2", Tunnel: "b-tunnel"},
+		{Server: "vm2", Tunnel: "c-tunnel"},
+	}
+
+	if server, _ := pickPoolServer(pool, "nginx-tunnel", nil); server != nil {
+		t.Fatalf("want no server, but got %s", server.Name)
+	}
+}
+
+func Test_pickPoolServer_PortsInUse(t *testing.T) {
+	pool := testPool.DeepCopy()
+	pool.Status.Allocations = []inletsv1alpha1.PoolAllocation{
+		{Server: "vm2", Tunnel: "a-tunnel", Ports: []int32{80, 443}},
+	}
+
+	server, allocated := pickPoolServer(pool, "nginx-tunnel", []int32{443})
+	if server == nil || server.Name != "vm1" || allocated {
+		t.Fatalf("want vm1 to be newly allocated, but got %v, allocated: %t", server, allocated)
+	}
+
+	pool.Status.Allocations = append(pool.Status.Allocations,
+		inletsv1alpha1.PoolAllocation{Server: "vm1", Tunnel: "b-tunnel", Ports: []int32{443}})
+	if server, _ := pickPoolServer(pool, "nginx-tunnel", []int32{443}); server != nil {
+		t.Fatalf("want no server, but got %s", server.Name)
+	}
+}
+
+func Test_pickPoolServer_RemovedServerIgnored(t *testing.T) {
+	pool := testPool.DeepCopy()
+	pool.Spec.Servers = pool.Spec.Servers[:1]
+	pool.Status.Allocations = []inletsv1alpha1.PoolAllocation{
+		{Server: "vm2", Tunnel: "nginx-tunnel"},
+	}
+
+	server, allocated := pickPoolServer(pool, "nginx-tunnel", nil)
+	if server == nil || server.Name != "vm1" || allocated {
+		t.Fatalf("want vm1 to be newly allocated, but got %v, allocated: %t", server, allocated)
+	}
+}
+
+func Test_allocateFromPool_AndRelease(t *testing.T) {
+	client := fake.NewSimpleClientset(testPool.DeepCopy())
+	c := &Controller{operatorclientset: client}
+
+	var allocated []string
+	for _, name := range []string{"a-tunnel", "b-tunnel", "c-tunnel"} {
+		tunnel := &inletsv1alpha1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       inletsv1alpha1.TunnelSpec{ExitServerPool: "site-a"},
+		}
+		server, err := allocateFromPool(tunnel, nil, c)
+		if err != nil {
+			t.Fatalf("unexpected error allocating %s: %s", name, err)
+		}
+		allocated = append(allocated, server.Name)
+	}
+
+	want := []string{"vm2", "vm1", "vm2"}
+	for i := range want {
+		if allocated[i] != want[i] {
+			t.Fatalf("want allocations %v, but got %v", want, allocated)
+		}
+	}
+
+	full := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "d-tunnel", Namespace: "default"},
+		Spec:       inletsv1alpha1.TunnelSpec{ExitServerPool: "site-a"},
+	}
+	if _, err := allocateFromPool(full, nil, c); err == nil {
+		t.Fatalf("want error when the pool is exhausted")
+	} else if _, ok := err.(errPoolExhausted); !ok {
+		t.Fatalf("want errPoolExhausted, but got %s", err)
+	}
+
+	released := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "b-tunnel", Namespace: "default"},
+		Status: inletsv1alpha1.TunnelStatus{
+			PoolAllocation: &inletsv1alpha1.PoolAllocationStatus{Pool: "site-a", Server: "vm1"},
+		},
+	}
+	if err := releaseToPool(released, c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pool, err := client.OperatorV1alpha1().ExitServerPools("default").Get(context.Background(), "site-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if pool.Status.Allocated != 2 || pool.Status.Capacity != 3 || pool.Status.Servers != 2 {
+		t.Fatalf("want 2 of 3 allocated on 2 servers, but got %d of %d on %d",
+			pool.Status.Allocated, pool.Status.Capacity, pool.Status.Servers)
+	}
+
+	server, err := allocateFromPool(full, nil, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if server.Name != "vm1" {
+		t.Fatalf("want released server vm1, but got %s", server.Name)
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/digitalocean/godo"
+	provision "github.com/inlets/cloud-provision/provision"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+const (
+	// ProviderUnavailable is used as part of the Event 'reason' when calls
+	// to the provider are paused, after it rate limited the operator or
+	// failed too many times in a row
+	ProviderUnavailable = "ProviderUnavailable"
+
+	// defaultRetryAfter is how long to pause calls to a provider which
+	// rate limited the operator without saying when to retry.
+	defaultRetryAfter = time.Second * 30
+
+	// maxRetryAfter caps the pause requested by a provider.
+	maxRetryAfter = time.Minute * 15
+)
+
+// circuitState is the state of a provider's circuit breaker. Calls are
+// made while it is closed, and refused while it is open. After the
+// cooldown it is half-open, and a single call is let through to find out
+// whether the provider has recovered.
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// rateLimitedErrors are parts of the errors returned by the providers'
+// clients when a request was rate limited.
+var rateLimitedErrors = []string{
+	"too many requests",
+	"rate limit",
+	"rate_limit",
+	"ratelimit",
+	"throttl",
+	"requestlimitexceeded",
+}
+
+var statusTooManyRequests = regexp.MustCompile(`\b429\b`)
+
+// statusCodeMessage finds the HTTP status code in the message of an error
+// which has lost its type, i.e. "status code: 503", "POST <url>: 422 ..."
+// or "400 Bad Request".
+var statusCodeMessage = regexp.MustCompile(`(?i)status ?code:? ?([1-5][0-9]{2})\b|https?://\S+: ([1-5][0-9]{2})\b|\b([1-5][0-9]{2}) ([a-z][a-z -]+)`)
+
+// timeoutErrors are parts of the errors returned when a call to the
+// provider timed out.
+var timeoutErrors = []string{
+	"timeout",
+	"timed out",
+	"deadline exceeded",
+}
+
+var providerCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "inlets_operator",
+	Name:      "provider_circuit_state",
+	Help:      "State of the circuit breaker for calls to each provider, 0 for closed, 1 for half-open and 2 for open",
+}, []string{"provider"})
+
+var providerRateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "inlets_operator",
+	Name:      "provider_rate_limited_total",
+	Help:      "Number of calls to each provider which were rate limited",
+}, []string{"provider"})
+
+func init() {
+	prometheus.MustRegister(providerCircuitState)
+	prometheus.MustRegister(providerRateLimitedTotal)
+}
+
+// errProviderUnavailable is returned instead of calling a provider while
+// its calls are paused.
+type errProviderUnavailable struct {
+	Provider   string
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e errProviderUnavailable) Error() string {
+	return fmt.Sprintf("calls to %s are paused (%s), retry in %s", e.Provider, e.Reason, e.RetryAfter.Round(time.Second))
+}
+
+// providerGuard limits the rate of calls to a provider, and stops calling
+// it for a while when it rate limits the operator, or fails threshold
+// times in a row.
+type providerGuard struct {
+	provider  string
+	limiter   *rate.Limiter
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	lock        sync.Mutex
+	state       circuitState
+	failures    int
+	openedAt    time.Time
+	pausedUntil time.Time
+	probing     bool
+}
+
+// providerGuards holds a providerGuard for each provider, so that all
+// workers and clients for a provider share the same limits.
+type providerGuards struct {
+	qps       float64
+	burst     int
+	threshold int
+	cooldown  time.Duration
+
+	lock   sync.Mutex
+	guards map[string]*providerGuard
+}
+
+func newProviderGuards(qps float64, burst, threshold int, cooldown time.Duration) *providerGuards {
+	return &providerGuards{
+		qps:       qps,
+		burst:     burst,
+		threshold: threshold,
+		cooldown:  cooldown,
+		guards:    map[string]*providerGuard{},
+	}
+}
+
+// get returns the guard for the provider, creating it on first use.
+func (g *providerGuards) get(provider string) *providerGuard {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if guard, ok := g.guards[provider]; ok {
+		return guard
+	}
+
+	limit := rate.Inf
+	if g.qps > 0 {
+		limit = rate.Limit(g.qps)
+	}
+	burst := g.burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	guard := &providerGuard{
+		provider:  provider,
+		limiter:   rate.NewLimiter(limit, burst),
+		threshold: g.threshold,
+		cooldown:  g.cooldown,
+		now:       time.Now,
+	}
+	g.guards[provider] = guard
+	providerCircuitState.WithLabelValues(provider).Set(float64(circuitClosed))
+	return guard
+}
+
+// allow returns an errProviderUnavailable when calls to the provider are
+// paused.
+func (g *providerGuard) allow() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := g.now()
+	if now.Before(g.pausedUntil) {
+		return errProviderUnavailable{Provider: g.provider, Reason: "RateLimited", RetryAfter: g.pausedUntil.Sub(now)}
+	}
+
+	switch g.state {
+	case circuitOpen:
+		if wait := g.openedAt.Add(g.cooldown).Sub(now); wait > 0 {
+			return errProviderUnavailable{Provider: g.provider, Reason: "CircuitOpen", RetryAfter: wait}
+		}
+		g.setState(circuitHalfOpen)
+		g.probing = true
+	case circuitHalfOpen:
+		if g.probing {
+			return errProviderUnavailable{Provider: g.provider, Reason: "CircuitOpen", RetryAfter: g.cooldown}
+		}
+		g.probing = true
+	}
+
+	return nil
+}
+
+// record updates the breaker with the result of a call. Only errors from
+// the provider itself count as failures, a host which is not found, or a
+// request the provider rejected, is an answer, so is not.
+func (g *providerGuard) record(err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.probing = false
+	if err == nil || isHostNotFound(err) || !isProviderFailure(err) {
+		g.failures = 0
+		g.setState(circuitClosed)
+		return
+	}
+
+	now := g.now()
+	if isRateLimited(err) {
+		retryAfter := getRetryAfter(err, now)
+		slog.Warn("Provider rate limited the operator, pausing calls", logKeyProvider, g.provider, "retryAfter", retryAfter)
+		providerRateLimitedTotal.WithLabelValues(g.provider).Inc()
+		if until := now.Add(retryAfter); until.After(g.pausedUntil) {
+			g.pausedUntil = until
+		}
+	}
+
+	g.failures++
+	if g.state == circuitHalfOpen || (g.threshold > 0 && g.failures >= g.threshold) {
+		if g.state != circuitOpen {
+			slog.Warn("Provider failed repeatedly, pausing calls", logKeyProvider, g.provider,
+				"failures", g.failures, "cooldown", g.cooldown, logKeyError, err)
+		}
+		g.openedAt = now
+		g.setState(circuitOpen)
+	}
+}
+
+func (g *providerGuard) setState(state circuitState) {
+	if g.state != state {
+		slog.Info("Circuit breaker changed state", logKeyProvider, g.provider, "state", state)
+	}
+	g.state = state
+	providerCircuitState.WithLabelValues(g.provider).Set(float64(state))
+}
+
+// call runs fn unless calls to the provider are paused, waiting for the
+// rate limiter first.
+func (g *providerGuard) call(fn func() error) error {
+	if err := g.allow(); err != nil {
+		return err
+	}
+
+	if err := g.limiter.Wait(context.Background()); err != nil {
+		g.lock.Lock()
+		g.probing = false
+		g.lock.Unlock()
+		return err
+	}
+
+	err := fn()
+	g.record(err)
+	return err
+}
+
+// isRateLimited is true for errors returned when the provider rate limited
+// the request.
+func isRateLimited(err error) bool {
+	var doErr *godo.ErrorResponse
+	if errors.As(err, &doErr) && doErr.Response != nil {
+		return doErr.Response.StatusCode == http.StatusTooManyRequests
+	}
+
+	msg := strings.ToLower(err.Error())
+	if statusTooManyRequests.MatchString(msg) {
+		return true
+	}
+	for _, s := range rateLimitedErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProviderFailure is true for errors which show that the provider is
+// failing, rather than that the request was wrong: rate limits, timeouts,
+// 5xx responses, and errors without a response, such as a refused
+// connection. 4xx responses, such as 400 or 422 for an invalid plan or
+// region, are the caller's error.
+func isProviderFailure(err error) bool {
+	if isRateLimited(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range timeoutErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	if code := getStatusCode(err); code >= 400 && code < 500 {
+		return false
+	}
+	return true
+}
+
+// getStatusCode returns the HTTP status code of the provider's response,
+// from the client's error type, or from the message when the error was
+// wrapped without it. It returns 0 when there is none.
+func getStatusCode(err error) int {
+	var doErr *godo.ErrorResponse
+	if errors.As(err, &doErr) && doErr.Response != nil {
+		return doErr.Response.StatusCode
+	}
+	var awsErr awserr.RequestFailure
+	if errors.As(err, &awsErr) {
+		return awsErr.StatusCode()
+	}
+	var gceErr *googleapi.Error
+	if errors.As(err, &gceErr) {
+		return gceErr.Code
+	}
+	var scwErr *scw.ResponseError
+	if errors.As(err, &scwErr) {
+		return scwErr.StatusCode
+	}
+
+	for _, m := range statusCodeMessage.FindAllStringSubmatch(err.Error(), -1) {
+		if code, _ := strconv.Atoi(m[1] + m[2]); code > 0 {
+			return code
+		}
+		// A bare number is only a status code when followed by its text,
+		// so that i.e. a port number is not mistaken for one
+		code, _ := strconv.Atoi(m[3])
+		if text := http.StatusText(code); len(text) > 0 && strings.HasPrefix(strings.ToLower(m[4]), strings.ToLower(text)) {
+			return code
+		}
+	}
+	return 0
+}
+
+// getRetryAfter returns how long the provider asked the operator to wait,
+// from the Retry-After or RateLimit-Reset headers when they are available.
+func getRetryAfter(err error, now time.Time) time.Duration {
+	var doErr *godo.ErrorResponse
+	if !errors.As(err, &doErr) || doErr.Response == nil {
+		return defaultRetryAfter
+	}
+
+	header := doErr.Response.Header
+	retryAfter := defaultRetryAfter
+	if v := header.Get("Retry-After"); len(v) > 0 {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(v); err == nil {
+			retryAfter = at.Sub(now)
+		}
+	} else if v := header.Get("RateLimit-Reset"); len(v) > 0 {
+		if epoch, err := strconv.ParseInt(v, 10, 64); err == nil {
+			retryAfter = time.Unix(epoch, 0).Sub(now)
+		}
+	}
+
+	if retryAfter <= 0 {
+		return defaultRetryAfter
+	}
+	if retryAfter > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return retryAfter
+}
+
+// guardedProvisioner makes each call to the provisioner through the
+// provider's guard.
+type guardedProvisioner struct {
+	provisioner provision.Provisioner
+	guard       *providerGuard
+}
+
+func (p *guardedProvisioner) Provision(host provision.BasicHost) (res *provision.ProvisionedHost, err error) {
+	err = p.guard.call(func() error {
+		res, err = p.provisioner.Provision(host)
+		return err
+	})
+	return res, err
+}
+
+func (p *guardedProvisioner) Status(id string) (res *provision.ProvisionedHost, err error) {
+	err = p.guard.call(func() error {
+		res, err = p.provisioner.Status(id)
+		return err
+	})
+	return res, err
+}
+
+func (p *guardedProvisioner) Delete(req provision.HostDeleteRequest) error {
+	return p.guard.call(func() error {
+		return p.provisioner.Delete(req)
+	})
+}
+
+// guardedHostLister is a guardedProvisioner for the provisioners which can
+// list their hosts.
+type guardedHostLister struct {
+	*guardedProvisioner
+	lister hostLister
+}
+
+func (p *guardedHostLister) List(filter provision.ListFilter) (res []*provision.ProvisionedHost, err error) {
+	err = p.guard.call(func() error {
+		res, err = p.lister.List(filter)
+		return err
+	})
+	return res, err
+}
+
+func (g *providerGuard) wrapProvisioner(provisioner provision.Provisioner) provision.Provisioner {
+	guarded := &guardedProvisioner{provisioner: provisioner, guard: g}
+	if lister, ok := provisioner.(hostLister); ok {
+		return &guardedHostLister{guardedProvisioner: guarded, lister: lister}
+	}
+	return guarded
+}
+
+// guardedFirewaller makes each call to the firewaller through the
+// provider's guard.
+type guardedFirewaller struct {
+	firewaller firewaller
+	guard      *providerGuard
+}
+
+func (f *guardedFirewaller) Apply(namespace, name, id, hostID string, rules firewallRules) (res string, err error) {
+	err = f.guard.call(func() error {
+		res, err = f.firewaller.Apply(namespace, name, id, hostID, rules)
+		return err
+	})
+	return res, err
+}
+
+func (f *guardedFirewaller) Delete(id string) error {
+	return f.guard.call(func() error {
+		return f.firewaller.Delete(id)
+	})
+}
+
+func (f *guardedFirewaller) OpensPorts() bool {
+	return f.firewaller.OpensPorts()
+}
+
+// setProviderUnavailable records on the Tunnel that calls to its provider
+// are paused, when err is an errProviderUnavailable, and returns err.
+func (c *Controller) setProviderUnavailable(tunnel *inletsv1alpha1.Tunnel, err error) error {
+	var unavailable errProviderUnavailable
+	if !errors.As(err, &unavailable) {
+		return err
+	}
+
+	copy := tunnel.DeepCopy()
+	if meta.SetStatusCondition(&copy.Status.Conditions, metav1.Condition{
+		Type:               inletsv1alpha1.TunnelProviderUnavailable,
+		Status:             metav1.ConditionTrue,
+		Reason:             unavailable.Reason,
+		Message:            unavailable.Error(),
+		ObservedGeneration: tunnel.Generation,
+	}) {
+		c.recordEvent(tunnel, corev1.EventTypeWarning, ProviderUnavailable, "%s", unavailable.Error())
+		if _, updateErr := c.operatorclientset.OperatorV1alpha1().
+			Tunnels(tunnel.Namespace).
+			UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); updateErr != nil {
+			return fmt.Errorf("tunnel %s.%s condition update error: %s", tunnel.Name, tunnel.Namespace, updateErr)
+		}
+	}
+
+	return err
+}
+
+// clearProviderUnavailable marks the ProviderUnavailable condition as
+// False, once a call to the provider has succeeded for the Tunnel.
+func clearProviderUnavailable(tunnel *inletsv1alpha1.Tunnel) {
+	if !meta.IsStatusConditionTrue(tunnel.Status.Conditions, inletsv1alpha1.TunnelProviderUnavailable) {
+		return
+	}
+
+	meta.SetStatusCondition(&tunnel.Status.Conditions, metav1.Condition{
+		Type:               inletsv1alpha1.TunnelProviderUnavailable,
+		Status:             metav1.ConditionFalse,
+		Reason:             "ProviderAvailable",
+		Message:            "Calls to the provider succeeded",
+		ObservedGeneration: tunnel.Generation,
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	provision "github.com/inlets/cloud-provision/provision"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+func makeTestGuard(threshold int, cooldown time.Duration, now *time.Time) *providerGuard {
+	guard := newProviderGuards(0, 1, threshold, cooldown).get("digitalocean")
+	guard.now = func() time.Time { return *now }
+	return guard
+}
+
+func Test_providerGuard_OpensAfterThreshold(t *testing.T) {
+	now := time.Now()
+	guard := makeTestGuard(2, time.Minute, &now)
+
+	calls := 0
+	fail := func() error {
+		calls++
+		return fmt.Errorf("500 internal server error")
+	}
+
+	guard.call(fail)
+	guard.call(fail)
+	err := guard.call(fail)
+
+	if calls != 2 {
+		t.Fatalf("want 2 calls to the provider, but got %d", calls)
+	}
+	unavailable, ok := err.(errProviderUnavailable)
+	if !ok || unavailable.Reason != "CircuitOpen" {
+		t.Fatalf("want CircuitOpen error, but got %v", err)
+	}
+	if guard.state != circuitOpen {
+		t.Errorf("want %s, but got %s", circuitOpen, guard.state)
+	}
+}
+
+func Test_providerGuard_HalfOpenProbe(t *testing.T) {
+	now := time.Now()
+	guard := makeTestGuard(1, time.Minute, &now)
+
+	guard.call(func() error { return fmt.Errorf("500 internal server error") })
+
+	now = now.Add(time.Minute)
+	if err := guard.call(func() error { return nil }); err != nil {
+		t.Fatalf("want probe to be let through, but got %s", err)
+	}
+	if guard.state != circuitClosed {
+		t.Fatalf("want %s, but got %s", circuitClosed, guard.state)
+	}
+}
+
+func Test_providerGuard_MissingHostIsNotAFailure(t *testing.T) {
+	now := time.Now()
+	guard := makeTestGuard(1, time.Minute, &now)
+
+	guard.call(func() error { return fmt.Errorf("failed to find server with id 1234") })
+
+	if guard.state != circuitClosed || guard.failures != 0 {
+		t.Fatalf("want %s with no failures, but got %s with %d", circuitClosed, guard.state, guard.failures)
+	}
+}
+
+func Test_providerGuard_RetryAfter(t *testing.T) {
+	now := time.Now()
+	guard := makeTestGuard(0, time.Minute, &now)
+
+	res := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{strconv.Itoa(90)}},
+		Request:    &http.Request{Method: http.MethodPost},
+	}
+	guard.call(func() error { return &godo.ErrorResponse{Response: res, Message: "Too many requests"} })
+
+	now = now.Add(time.Minute)
+	err := guard.call(func() error { return nil })
+	unavailable, ok := err.(errProviderUnavailable)
+	if !ok || unavailable.Reason != "RateLimited" {
+		t.Fatalf("want RateLimited error, but got %v", err)
+	}
+	if unavailable.RetryAfter != time.Second*30 {
+		t.Errorf("want %s, but got %s", time.Second*30, unavailable.RetryAfter)
+	}
+
+	now = now.Add(time.Second * 30)
+	if err := guard.call(func() error { return nil }); err != nil {
+		t.Fatalf("want call after Retry-After, but got %s", err)
+	}
+}
+
+func Test_isRateLimited(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{err: fmt.Errorf("limit of 3600 requests per hour reached (rate_limit_exceeded)"), want: true},
+		{err: fmt.Errorf("RequestLimitExceeded: Request limit exceeded."), want: true},
+		{err: fmt.Errorf("GET https://api.digitalocean.com/v2/droplets/14291: 404 not found"), want: false},
+		{err: fmt.Errorf("connection refused"), want: false},
+	}
+
+	for _, tc := range cases {
+		if got := isRateLimited(tc.err); got != tc.want {
+			t.Errorf("%s: want %t, but got %t", tc.err, tc.want, got)
+		}
+	}
+}
+
+func makeGodoError(code int, message string) error {
+	req, _ := http.NewRequest(http.MethodPost, "https://api.digitalocean.com/v2/droplets", nil)
+	return &godo.ErrorResponse{Response: &http.Response{StatusCode: code, Request: req}, Message: message}
+}
+
+func Test_isProviderFailure(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{err: fmt.Errorf("500 internal server error"), want: true},
+		{err: fmt.Errorf("unexpected status code: 503"), want: true},
+		{err: fmt.Errorf("dial tcp 192.0.2.1:443: i/o timeout"), want: true},
+		{err: fmt.Errorf("connection refused on port 443"), want: true},
+		{err: fmt.Errorf("too many requests"), want: true},
+		{err: fmt.Errorf("POST https://api.digitalocean.com/v2/droplets: 422 size is not available in this region"), want: false},
+		{err: fmt.Errorf("400 Bad Request"), want: false},
+		{err: makeGodoError(http.StatusUnprocessableEntity, "invalid size"), want: false},
+		{err: makeGodoError(http.StatusBadGateway, "bad gateway"), want: true},
+	}
+
+	for _, tc := range cases {
+		if got := isProviderFailure(tc.err); got != tc.want {
+			t.Errorf("%s: want %t, but got %t", tc.err, tc.want, got)
+		}
+	}
+}
+
+func Test_providerGuard_CallerErrorIsNotAFailure(t *testing.T) {
+	now := time.Now()
+	guard := makeTestGuard(1, time.Minute, &now)
+
+	guard.call(func() error { return makeGodoError(http.StatusBadRequest, "invalid region") })
+
+	if guard.state != circuitClosed || guard.failures != 0 {
+		t.Fatalf("want %s with no failures, but got %s with %d", circuitClosed, guard.state, guard.failures)
+	}
+}
+
+func Test_guardedProvisioner_KeepsList(t *testing.T) {
+	guard := newProviderGuards(0, 1, 5, time.Minute).get("digitalocean")
+
+	got := guard.wrapProvisioner(&fakeProvisioner{hosts: []*provision.ProvisionedHost{{ID: "1234"}}})
+
+	lister, ok := got.(hostLister)
+	if !ok {
+		t.Fatalf("want a hostLister, but got %T", got)
+	}
+	hosts, err := lister.List(provision.ListFilter{})
+	if err != nil || len(hosts) != 1 {
+		t.Fatalf("want 1 host, but got %v: %v", hosts, err)
+	}
+}
+
+func Test_clearProviderUnavailable(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{}
+	clearProviderUnavailable(tunnel)
+	if len(tunnel.Status.Conditions) != 0 {
+		t.Fatalf("want no condition added, but got %v", tunnel.Status.Conditions)
+	}
+}
+
+func Test_newProvisioner_Cached(t *testing.T) {
+	c := &Controller{
+		infraConfig:    newConfigStore(&InfraConfig{Provider: "digitalocean", AccessKey: "token"}),
+		providerGuards: newProviderGuards(0, 1, 5, time.Minute),
+		provisioners:   newProvisionerCache(),
+	}
+
+	first, err := newProvisioner(c, "lon1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := newProvisioner(c, "lon1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first != second {
+		t.Errorf("want the client for lon1 to be reused")
+	}
+
+	other, err := newProvisioner(c, "nyc1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if other == first {
+		t.Errorf("want a client for each region")
+	}
+}
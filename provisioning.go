@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// provisioningPriorityAnnotation on a Tunnel or its Service orders its
+// exit server before those with a lower priority, the default is 0.
+const provisioningPriorityAnnotation = "operator.inlets.dev/provisioning-priority"
+
+var provisioningQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "inlets_operator",
+	Name:      "provisioning_queued",
+	Help:      "Number of exit servers waiting to be created",
+})
+
+var provisioningInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "inlets_operator",
+	Name:      "provisioning_in_progress",
+	Help:      "Number of exit servers being created",
+})
+
+func init() {
+	prometheus.MustRegister(provisioningQueued)
+	prometheus.MustRegister(provisioningInProgress)
+}
+
+// provisioningRequest is an exit server waiting to be created for the
+// Tunnel with key.
+type provisioningRequest struct {
+	key       string
+	namespace string
+	priority  int
+	run       func()
+}
+
+// provisioningPool runs provisioningRequests on a fixed number of
+// workers. The request with the highest priority runs first, and between
+// namespaces with requests of the same priority, each takes a turn, so
+// that one namespace creating many Services does not hold up the others.
+type provisioningPool struct {
+	workers int
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queued  map[string][]*provisioningRequest
+	order   []string
+	keys    map[string]bool
+	stopped bool
+}
+
+func newProvisioningPool(workers int) *provisioningPool {
+	p := &provisioningPool{
+		workers: workers,
+		queued:  map[string][]*provisioningRequest{},
+		keys:    map[string]bool{},
+	}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+// submit queues the request, unless one for the same key is already
+// queued or running, and returns whether it was queued.
+func (p *provisioningPool) submit(req *provisioningRequest) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.keys[req.key] {
+		return false
+	}
+	p.keys[req.key] = true
+
+	q := p.queued[req.namespace]
+	if len(q) == 0 {
+		p.order = append(p.order, req.namespace)
+	}
+
+	// Keep each namespace's requests by priority, then in the order
+	// they were submitted
+	i := sort.Search(len(q), func(i int) bool { return q[i].priority < req.priority })
+	q = append(q, nil)
+	copy(q[i+1:], q[i:])
+	q[i] = req
+	p.queued[req.namespace] = q
+
+	provisioningQueued.Inc()
+	p.cond.Signal()
+	return true
+}
+
+// next removes and returns the request to run next, p.order must not be
+// empty. The namespace it came from goes to the back of p.order.
+func (p *provisioningPool) next() *provisioningRequest {
+	best := 0
+	for i, ns := range p.order {
+		if p.queued[ns][0].priority > p.queued[p.order[best]][0].priority {
+			best = i
+		}
+	}
+
+	ns := p.order[best]
+	req := p.queued[ns][0]
+	p.queued[ns] = p.queued[ns][1:]
+
+	p.order = append(p.order[:best], p.order[best+1:]...)
+	if len(p.queued[ns]) > 0 {
+		p.order = append(p.order, ns)
+	} else {
+		delete(p.queued, ns)
+	}
+
+	provisioningQueued.Dec()
+	return req
+}
+
+// take blocks until there is a request to run, it returns nil once the
+// pool is stopped.
+func (p *provisioningPool) take() *provisioningRequest {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for len(p.order) == 0 && !p.stopped {
+		p.cond.Wait()
+	}
+	if p.stopped {
+		return nil
+	}
+	return p.next()
+}
+
+func (p *provisioningPool) done(key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.keys, key)
+}
+
+// start runs the pool's workers until stopCh is closed. Requests already
+// running are left to finish.
+func (p *provisioningPool) start(stopCh <-chan struct{}) {
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for req := p.take(); req != nil; req = p.take() {
+				provisioningInProgress.Inc()
+				req.run()
+				provisioningInProgress.Dec()
+				p.done(req.key)
+			}
+		}()
+	}
+
+	go func() {
+		<-stopCh
+		p.lock.Lock()
+		p.stopped = true
+		p.lock.Unlock()
+		p.cond.Broadcast()
+	}()
+}
+
+// getProvisioningPriority reads the priority from the Tunnel's annotation,
+// or else from its Service's.
+func getProvisioningPriority(c *Controller, tunnel *inletsv1alpha1.Tunnel) int {
+	value, ok := tunnel.Annotations[provisioningPriorityAnnotation]
+	if !ok && tunnel.Spec.ServiceRef != nil {
+		if service, err := c.lookupService(tunnel); err == nil {
+			value, ok = service.Annotations[provisioningPriorityAnnotation]
+		}
+	}
+	if !ok {
+		return 0
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		c.tunnelLogger(tunnel).Warn("Invalid priority annotation, using 0", "annotation", provisioningPriorityAnnotation, "value", value)
+		return 0
+	}
+	return priority
+}
+
+// submitExitServer queues the creation of an exit server for the Tunnel.
+func (c *Controller) submitExitServer(tunnel *inletsv1alpha1.Tunnel) {
+	key, err := cache.MetaNamespaceKeyFunc(tunnel)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	priority := getProvisioningPriority(c, tunnel)
+	if c.provisioningPool.submit(&provisioningRequest{
+		key:       key,
+		namespace: tunnel.Namespace,
+		priority:  priority,
+		run:       func() { c.runExitServer(key) },
+	}) {
+		c.tunnelLogger(tunnel).Info("Queued tunnel server", "priority", priority)
+	}
+}
+
+// runExitServer creates the exit server for the Tunnel with key, from the
+// pool. The Tunnel is read from the API server rather than the cache, so
+// that a stale copy can not cause a second exit server to be created. On
+// an error, the Tunnel is put back on the workqueue to be retried.
+func (c *Controller) runExitServer(key string) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	tunnel, err := c.operatorclientset.OperatorV1alpha1().
+		Tunnels(namespace).
+		Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			utilruntime.HandleError(fmt.Errorf("error getting tunnel '%s': %s, requeuing", key, err))
+			c.workqueue.AddRateLimited(key)
+		}
+		return
+	}
+
+	if tunnel.Status.HostStatus != "" || tunnel.DeletionTimestamp != nil {
+		return
+	}
+
+	if err := c.createExitServer(tunnel, nil); err != nil {
+		c.tunnelLogger(tunnel).Error("Error creating tunnel server, requeuing", logKeyError, err)
+		c.workqueue.AddRateLimited(key)
+		return
+	}
+
+	c.workqueue.Forget(key)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+func submitTestRequests(p *provisioningPool, keys ...string) {
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 3)
+		priority := 0
+		if len(parts) == 3 {
+			priority = len(parts[2])
+		}
+		p.submit(&provisioningRequest{key: key, namespace: parts[0], priority: priority})
+	}
+}
+
+func takeTestRequests(p *provisioningPool) []string {
+	keys := []string{}
+	for len(p.order) > 0 {
+		keys = append(keys, p.next().key)
+	}
+	return keys
+}
+
+func Test_provisioningPool_NamespaceFairness(t *testing.T) {
+	p := newProvisioningPool(1)
+	submitTestRequests(p, "team-a/1", "team-a/2", "team-a/3", "team-b/1", "team-c/1")
+
+	got := strings.Join(takeTestRequests(p), " ")
+
+	want := "team-a/1 team-b/1 team-c/1 team-a/2 team-a/3"
+	if got != want {
+		t.Fatalf("want %s, but got %s", want, got)
+	}
+}
+
+func Test_provisioningPool_Priority(t *testing.T) {
+	p := newProvisioningPool(1)
+	// The length of the third part is used as the priority
+	submitTestRequests(p, "team-a/1", "team-a/2/xx", "team-b/1", "team-b/2/x")
+
+	got := strings.Join(takeTestRequests(p), " ")
+
+	want := "team-a/2/xx team-b/2/x team-a/1 team-b/1"
+	if got != want {
+		t.Fatalf("want %s, but got %s", want, got)
+	}
+}
+
+func Test_provisioningPool_SubmitOnce(t *testing.T) {
+	p := newProvisioningPool(1)
+
+	if !p.submit(&provisioningRequest{key: "default/nginx-tunnel", namespace: "default"}) {
+		t.Fatalf("want first request queued")
+	}
+	if p.submit(&provisioningRequest{key: "default/nginx-tunnel", namespace: "default"}) {
+		t.Fatalf("want second request for the same key ignored")
+	}
+
+	p.done(p.next().key)
+	if !p.submit(&provisioningRequest{key: "default/nginx-tunnel", namespace: "default"}) {
+		t.Fatalf("want request queued again once done")
+	}
+}
+
+func Test_provisioningPool_Runs(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	p := newProvisioningPool(2)
+	p.start(stopCh)
+
+	ran := make(chan string, 1)
+	p.submit(&provisioningRequest{key: "default/nginx-tunnel", namespace: "default", run: func() {
+		ran <- "default/nginx-tunnel"
+	}})
+
+	select {
+	case got := <-ran:
+		if got != "default/nginx-tunnel" {
+			t.Fatalf("want %s, but got %s", "default/nginx-tunnel", got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("want request run, but timed out")
+	}
+}
+
+func Test_getProvisioningPriority(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(&corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:        "nginx",
+		Namespace:   "default",
+		Annotations: map[string]string{provisioningPriorityAnnotation: "10"},
+	}})
+	c := &Controller{serviceLister: corelisters.NewServiceLister(indexer)}
+
+	tunnel := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", Namespace: "default"},
+		Spec:       inletsv1alpha1.TunnelSpec{ServiceRef: &inletsv1alpha1.ResourceRef{Name: "nginx"}},
+	}
+	if got := getProvisioningPriority(c, tunnel); got != 10 {
+		t.Errorf("from Service, want %d, but got %d", 10, got)
+	}
+
+	tunnel.Annotations = map[string]string{provisioningPriorityAnnotation: "-1"}
+	if got := getProvisioningPriority(c, tunnel); got != -1 {
+		t.Errorf("from Tunnel, want %d, but got %d", -1, got)
+	}
+
+	tunnel.Annotations[provisioningPriorityAnnotation] = "high"
+	if got := getProvisioningPriority(c, tunnel); got != 0 {
+		t.Errorf("invalid, want %d, but got %d", 0, got)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// ErrQuotaExceeded is used as part of the Event 'reason' when an exit
+// server is not created for a Tunnel because it would exceed a quota
+const ErrQuotaExceeded = "ErrQuotaExceeded"
+
+// errQuotaExceeded is returned when creating an exit server would exceed
+// one of the quotas, Reason is used for the Tunnel's condition.
+type errQuotaExceeded struct {
+	Reason  string
+	Message string
+}
+
+func (e errQuotaExceeded) Error() string {
+	return e.Message
+}
+
+// quotaUsage is the number of exit servers created by the operator, and
+// their estimated monthly spend.
+type quotaUsage struct {
+	Tunnels      int
+	MonthlySpend float64
+}
+
+// getQuotaUsage returns the usage for the cluster and for the namespace.
+// An exit server shared by several Tunnels is only counted once, and
+// servers from an ExitServerPool are not counted, as the operator did not
+// create them.
+func getQuotaUsage(tunnels []*inletsv1alpha1.Tunnel, namespace, exclude string) (cluster, ns quotaUsage) {
+	seen := map[string]bool{}
+
+	for _, t := range tunnels {
+		if t.Namespace == namespace && t.Name == exclude {
+			continue
+		}
+		if len(t.Status.HostID) == 0 || t.Status.PoolAllocation != nil {
+			continue
+		}
+
+		key := t.Namespace + "/" + t.Status.HostID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var monthly float64
+		if t.Status.Cost != nil {
+			monthly, _ = strconv.ParseFloat(t.Status.Cost.Monthly, 64)
+		}
+
+		cluster.Tunnels++
+		cluster.MonthlySpend += monthly
+		if t.Namespace == namespace {
+			ns.Tunnels++
+			ns.MonthlySpend += monthly
+		}
+	}
+
+	return cluster, ns
+}
+
+// getNamespaceLimits returns the limits for a namespace, those listed by
+// name take precedence over the default for each namespace.
+func getNamespaceLimits(quotas QuotaConfig, namespace string) QuotaLimits {
+	if limits, ok := quotas.Namespaces[namespace]; ok {
+		return limits
+	}
+	return quotas.Namespace
+}
+
+// checkLimits returns an error if one more exit server, costing monthly,
+// would take the usage over the limits.
+func checkLimits(scope string, limits QuotaLimits, usage quotaUsage, monthly float64) error {
+	if limits.MaxTunnels > 0 && usage.Tunnels+1 > limits.MaxTunnels {
+		return errQuotaExceeded{
+			Reason: "MaxTunnels",
+			Message: fmt.Sprintf("%s quota of %d tunnel server(s) reached",
+				scope, limits.MaxTunnels),
+		}
+	}
+
+	if limits.MaxMonthlySpend > 0 && usage.MonthlySpend+monthly > limits.MaxMonthlySpend {
+		return errQuotaExceeded{
+			Reason: "MaxMonthlySpend",
+			Message: fmt.Sprintf("%s quota of %.2f/month would be exceeded, %.2f/month in use, new tunnel server %.2f/month",
+				scope, limits.MaxMonthlySpend, usage.MonthlySpend, monthly),
+		}
+	}
+
+	return nil
+}
+
+// checkQuota returns an error if creating an exit server with the given
+// cost for the Tunnel would exceed the namespace or cluster quotas. When
+// the cost is unknown, only the number of exit servers is limited.
+func checkQuota(quotas QuotaConfig, tunnels []*inletsv1alpha1.Tunnel, tunnel *inletsv1alpha1.Tunnel, cost *inletsv1alpha1.CostStatus) error {
+	var monthly float64
+	if cost != nil {
+		monthly, _ = strconv.ParseFloat(cost.Monthly, 64)
+	}
+
+	cluster, ns := getQuotaUsage(tunnels, tunnel.Namespace, tunnel.Name)
+
+	if err := checkLimits(fmt.Sprintf("namespace %s", tunnel.Namespace),
+		getNamespaceLimits(quotas, tunnel.Namespace), ns, monthly); err != nil {
+		return err
+	}
+
+	return checkLimits("cluster", quotas.Cluster, cluster, monthly)
+}
+
+// validateQuotas rejects negative limits in the config.
+func validateQuotas(quotas QuotaConfig) error {
+	limits := map[string]QuotaLimits{
+		"cluster":   quotas.Cluster,
+		"namespace": quotas.Namespace,
+	}
+	for ns, l := range quotas.Namespaces {
+		limits["namespace "+ns] = l
+	}
+
+	for scope, l := range limits {
+		if l.MaxTunnels < 0 {
+			return fmt.Errorf("invalid maxTunnels for %s quota: %d", scope, l.MaxTunnels)
+		}
+		if l.MaxMonthlySpend < 0 {
+			return fmt.Errorf("invalid maxMonthlySpend for %s quota: %f", scope, l.MaxMonthlySpend)
+		}
+	}
+	return nil
+}
+
+// enforceQuota is called before an exit server is created for the Tunnel.
+// When a quota would be exceeded, an Event is recorded on the Tunnel and
+// its Service, the Tunnel's QuotaExceeded condition is set, and an error
+// is returned so that the Tunnel is retried later.
+//
+// Usage is read from the cache, so Tunnels synced at the same time may
+// briefly exceed a quota between them.
+func enforceQuota(c *Controller, tunnel *inletsv1alpha1.Tunnel, cost *inletsv1alpha1.CostStatus) error {
+	tunnels, err := c.tunnelsLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("error listing tunnels: %s", err)
+	}
+
+	err = checkQuota(c.config().Quotas, tunnels, tunnel, cost)
+	if err == nil {
+		return nil
+	}
+
+	quotaErr, ok := err.(errQuotaExceeded)
+	if !ok {
+		return err
+	}
+
+	c.tunnelLogger(tunnel).Warn("Not creating tunnel server, quota exceeded", "reason", quotaErr.Reason, logKeyError, quotaErr.Message)
+	c.recordEvent(tunnel, corev1.EventTypeWarning, ErrQuotaExceeded, "%s", quotaErr.Message)
+
+	copy := tunnel.DeepCopy()
+	if meta.SetStatusCondition(&copy.Status.Conditions, metav1.Condition{
+		Type:               inletsv1alpha1.TunnelQuotaExceeded,
+		Status:             metav1.ConditionTrue,
+		Reason:             quotaErr.Reason,
+		Message:            quotaErr.Message,
+		ObservedGeneration: tunnel.Generation,
+	}) {
+		if _, err := c.operatorclientset.OperatorV1alpha1().
+			Tunnels(tunnel.Namespace).
+			UpdateStatus(context.Background(), copy, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("tunnel %s.%s condition update error: %s", tunnel.Name, tunnel.Namespace, err)
+		}
+	}
+
+	return quotaErr
+}
+
+// clearQuotaExceeded marks the QuotaExceeded condition as False, once an
+// exit server has been created for a Tunnel which was waiting for quota.
+func clearQuotaExceeded(tunnel *inletsv1alpha1.Tunnel) {
+	if !meta.IsStatusConditionTrue(tunnel.Status.Conditions, inletsv1alpha1.TunnelQuotaExceeded) {
+		return
+	}
+
+	meta.SetStatusCondition(&tunnel.Status.Conditions, metav1.Condition{
+		Type:               inletsv1alpha1.TunnelQuotaExceeded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "WithinQuota",
+		Message:            "Tunnel server created within quota",
+		ObservedGeneration: tunnel.Generation,
+	})
+}
+
+// enqueueQuotaTunnels enqueues the Tunnels waiting for quota, after an
+// exit server has been deleted.
+func (c *Controller) enqueueQuotaTunnels() {
+	tunnels, err := c.tunnelsLister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+
+	for _, t := range tunnels {
+		if len(t.Status.HostStatus) == 0 &&
+			meta.IsStatusConditionTrue(t.Status.Conditions, inletsv1alpha1.TunnelQuotaExceeded) {
+			c.enqueueTunnel(t)
+		}
+	}
+}
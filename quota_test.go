@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+func Test_getQuotaUsage_CountsSharedHostOnce(t *testing.T) {
+	tunnels := []*inletsv1alpha1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "team-a"},
+			Status:     inletsv1alpha1.TunnelStatus{HostID: "host-1", Cost: &inletsv1alpha1.CostStatus{Monthly: "5.00"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "team-a"},
+			Status:     inletsv1alpha1.TunnelStatus{HostID: "host-1", Cost: &inletsv1alpha1.CostStatus{Monthly: "5.00"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "team-b"},
+			Status:     inletsv1alpha1.TunnelStatus{HostID: "host-2", Cost: &inletsv1alpha1.CostStatus{Monthly: "7.50"}},
+		},
+		makeTestTunnel("d", "team-a", ""),
+	}
+
+	pooled := makeTestTunnel("e", "team-a", "")
+	pooled.Status.PoolAllocation = &inletsv1alpha1.PoolAllocationStatus{Pool: "pool", Server: "s1"}
+	tunnels = append(tunnels, pooled)
+
+	cluster, ns := getQuotaUsage(tunnels, "team-a", "d")
+
+	if cluster.Tunnels != 2 || cluster.MonthlySpend != 12.5 {
+		t.Errorf("want cluster usage of 2 tunnels and 12.50, but got %d and %.2f", cluster.Tunnels, cluster.MonthlySpend)
+	}
+	if ns.Tunnels != 1 || ns.MonthlySpend != 5 {
+		t.Errorf("want namespace usage of 1 tunnel and 5.00, but got %d and %.2f", ns.Tunnels, ns.MonthlySpend)
+	}
+}
+
+func Test_checkQuota_NoLimits(t *testing.T) {
+	tunnels := []*inletsv1alpha1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "team-a"},
+			Status:     inletsv1alpha1.TunnelStatus{HostID: "host-1", Cost: &inletsv1alpha1.CostStatus{Monthly: "5.00"}},
+		},
+	}
+
+	if err := checkQuota(QuotaConfig{}, tunnels, makeTestTunnel("b", "team-a", ""), nil); err != nil {
+		t.Fatalf("want no error without limits, but got %s", err)
+	}
+}
+
+func Test_checkQuota_NamespaceMaxTunnels(t *testing.T) {
+	quotas := QuotaConfig{Namespace: QuotaLimits{MaxTunnels: 1}}
+	tunnels := []*inletsv1alpha1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "team-a"},
+			Status:     inletsv1alpha1.TunnelStatus{HostID: "host-1"},
+		},
+	}
+
+	err := checkQuota(quotas, tunnels, makeTestTunnel("b", "team-a", ""), nil)
+	if err == nil {
+		t.Fatalf("want quota error for team-a")
+	}
+	if got := err.(errQuotaExceeded).Reason; got != "MaxTunnels" {
+		t.Errorf("want reason MaxTunnels, but got %s", got)
+	}
+
+	if err := checkQuota(quotas, tunnels, makeTestTunnel("b", "team-b", ""), nil); err != nil {
+		t.Errorf("want no error for team-b, but got %s", err)
+	}
+}
+
+func Test_checkQuota_NamespaceOverride(t *testing.T) {
+	quotas := QuotaConfig{
+		Namespace:  QuotaLimits{MaxTunnels: 1},
+		Namespaces: map[string]QuotaLimits{"team-a": {MaxTunnels: 3}},
+	}
+	tunnels := []*inletsv1alpha1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "team-a"},
+			Status:     inletsv1alpha1.TunnelStatus{HostID: "host-1"},
+		},
+	}
+
+	if err := checkQuota(quotas, tunnels, makeTestTunnel("b", "team-a", ""), nil); err != nil {
+		t.Fatalf("want no error with the override for team-a, but got %s", err)
+	}
+}
+
+func Test_checkQuota_ClusterMonthlySpend(t *testing.T) {
+	quotas := QuotaConfig{Cluster: QuotaLimits{MaxMonthlySpend: 15}}
+	tunnels := []*inletsv1alpha1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "team-a"},
+			Status:     inletsv1alpha1.TunnelStatus{HostID: "host-1", Cost: &inletsv1alpha1.CostStatus{Monthly: "7.59"}},
+		},
+	}
+
+	cost := &inletsv1alpha1.CostStatus{Monthly: "7.59"}
+	err := checkQuota(quotas, tunnels, makeTestTunnel("b", "team-b", ""), cost)
+	if err == nil {
+		t.Fatalf("want quota error for the cluster's spend")
+	}
+	if got := err.(errQuotaExceeded).Reason; got != "MaxMonthlySpend" {
+		t.Errorf("want reason MaxMonthlySpend, but got %s", got)
+	}
+
+	cost = &inletsv1alpha1.CostStatus{Monthly: "6.57"}
+	if err := checkQuota(quotas, tunnels, makeTestTunnel("b", "team-b", ""), cost); err != nil {
+		t.Errorf("want no error within the spend quota, but got %s", err)
+	}
+}
+
+func Test_validateQuotas_Negative(t *testing.T) {
+	quotas := QuotaConfig{Namespaces: map[string]QuotaLimits{"team-a": {MaxTunnels: -1}}}
+
+	if err := validateQuotas(quotas); err == nil {
+		t.Fatalf("want error for a negative limit")
+	}
+}
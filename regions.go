@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// regionsAnnotation lists the regions to create an exit server in for a
+// Service, i.e. "lon1,nyc1,sgp1"
+const regionsAnnotation = "operator.inlets.dev/regions"
+
+// replicasAnnotation sets how many exit servers to create in each region
+// for a Service, i.e. "2"
+const replicasAnnotation = "operator.inlets.dev/replicas"
+
+// maxTunnelReplicas limits how many exit servers a single annotation
+// can request per region.
+const maxTunnelReplicas = 10
+
+// tunnelPlacement is the name and region of one Tunnel to be created
+// for a Service.
+type tunnelPlacement struct {
+	Name   string
+	Region string
+}
+
+// getTunnelPlacements returns one placement per exit server that the
+// Service asks for. Without any annotations a single Tunnel named
+// <service>-tunnel is created in the operator's default region.
+func getTunnelPlacements(service *corev1.Service) ([]tunnelPlacement, error) {
+	replicas := 1
+	if v, ok := service.Annotations[replicasAnnotation]; ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %q", replicasAnnotation, v)
+		}
+		if n < 1 || n > maxTunnelReplicas {
+			return nil, fmt.Errorf("%s must be between 1 and %d, got: %d", replicasAnnotation, maxTunnelReplicas, n)
+		}
+		replicas = n
+	}
+
+	regions := []string{""}
+	if v, ok := service.Annotations[regionsAnnotation]; ok && len(strings.TrimSpace(v)) > 0 {
+		regions = []string{}
+		seen := map[string]bool{}
+		for _, r := range strings.Split(v, ",") {
+			r = strings.TrimSpace(r)
+			if len(r) == 0 || seen[r] {
+				continue
+			}
+			if errs := validation.IsDNS1123Label(r); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid region in %s: %q, %s", regionsAnnotation, r, strings.Join(errs, ", "))
+			}
+			seen[r] = true
+			regions = append(regions, r)
+		}
+	}
+
+	placements := []tunnelPlacement{}
+	for _, region := range regions {
+		base := service.Name + "-tunnel"
+		if len(region) > 0 {
+			base = base + "-" + region
+		}
+
+		for i := 0; i < replicas; i++ {
+			name := base
+			if i > 0 {
+				name = fmt.Sprintf("%s-%d", base, i)
+			}
+
+			placements = append(placements, tunnelPlacement{
+				Name:   name,
+				Region: region,
+			})
+		}
+	}
+
+	return placements, nil
+}
+
+// providerIgnoresRegion is true for providers which create every exit
+// server in the operator's zone, so a Tunnel's region has no effect. On
+// GCE the VM is created in the zone, and its address would be reserved in
+// a different region.
+func providerIgnoresRegion(provider string) bool {
+	return provider == "gce"
+}
+
+// getTunnelRegion returns the region for the Tunnel's exit server, falling
+// back to the region configured for the operator.
+func getTunnelRegion(c *Controller, tunnel *inletsv1alpha1.Tunnel) string {
+	if len(tunnel.Spec.Region) > 0 {
+		return tunnel.Spec.Region
+	}
+	return c.config().Region
+}
+
+// pruneServiceTunnels deletes Tunnels generated for the Service which are no
+// longer wanted, i.e. when a region is removed from the annotation. Deleting
+// the Tunnel also deletes its exit server, the remaining Tunnels keep serving.
+func pruneServiceTunnels(c *Controller, service *corev1.Service, placements []tunnelPlacement) error {
+	wanted := map[string]bool{}
+	for _, p := range placements {
+		wanted[p.Name] = true
+	}
+
+	tunnels, err := c.tunnelsLister.Tunnels(service.Namespace).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("error listing tunnels: %s", err)
+	}
+
+	for _, t := range tunnels {
+		ref := metav1.GetControllerOf(t)
+		if ref == nil || ref.Kind != "Service" || ref.UID != service.UID {
+			continue
+		}
+		if wanted[t.Name] {
+			continue
+		}
+
+		c.tunnelLogger(t).Info("Removing Tunnel, no longer requested by its Service")
+		if err := c.operatorclientset.OperatorV1alpha1().
+			Tunnels(t.Namespace).
+			Delete(context.Background(), t.Name, metav1.DeleteOptions{}); err != nil {
+			c.tunnelLogger(t).Error("Error deleting Tunnel", logKeyError, err)
+		}
+	}
+
+	return nil
+}
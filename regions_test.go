@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_getTunnelPlacements_Default(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx"},
+	}
+
+	got, err := getTunnelPlacements(svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []tunnelPlacement{{Name: "nginx-tunnel"}}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_getTunnelPlacements_Regions(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nginx",
+			Annotations: map[string]string{
+				regionsAnnotation: "lon1, nyc1,lon1",
+			},
+		},
+	}
+
+	got, err := getTunnelPlacements(svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []tunnelPlacement{
+		{Name: "nginx-tunnel-lon1", Region: "lon1"},
+		{Name: "nginx-tunnel-nyc1", Region: "nyc1"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_getTunnelPlacements_ReplicasPerRegion(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nginx",
+			Annotations: map[string]string{
+				regionsAnnotation:  "fsn1,nbg1",
+				replicasAnnotation: "2",
+			},
+		},
+	}
+
+	got, err := getTunnelPlacements(svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []tunnelPlacement{
+		{Name: "nginx-tunnel-fsn1", Region: "fsn1"},
+		{Name: "nginx-tunnel-fsn1-1", Region: "fsn1"},
+		{Name: "nginx-tunnel-nbg1", Region: "nbg1"},
+		{Name: "nginx-tunnel-nbg1-1", Region: "nbg1"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, but got %v", want, got)
+	}
+}
+
+func Test_getTunnelPlacements_InvalidReplicas(t *testing.T) {
+	for _, v := range []string{"0", "abc", "11"} {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "nginx",
+				Annotations: map[string]string{replicasAnnotation: v},
+			},
+		}
+
+		if _, err := getTunnelPlacements(svc); err == nil {
+			t.Errorf("want error for replicas: %q", v)
+		}
+	}
+}
+
+func Test_getTunnelPlacements_InvalidRegion(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nginx",
+			Annotations: map[string]string{regionsAnnotation: "us_east"},
+		},
+	}
+
+	if _, err := getTunnelPlacements(svc); err == nil {
+		t.Fatalf("want error for invalid region")
+	}
+}
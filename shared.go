@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// shareExitServerAnnotation on a Service sets spec.shareExitServer on the
+// Tunnels created for it, i.e. operator.inlets.dev/share-exit-server=true
+const shareExitServerAnnotation = "operator.inlets.dev/share-exit-server"
+
+// SharedExitServer is used as part of the Event 'reason' when a Tunnel
+// joins an exit server created for another Tunnel
+const SharedExitServer = "SharedExitServer"
+
+// sharedHost is an exit server and the ports already in use on it.
+type sharedHost struct {
+	// Tunnel is one of the Tunnels using the exit server, its status is
+	// copied to Tunnels which join it
+	Tunnel *inletsv1alpha1.Tunnel
+	Region string
+	Ports  map[int32]bool
+}
+
+// wantsSharedExitServer is true when the Service's annotation asks for
+// its exit server to be shared.
+func wantsSharedExitServer(service *corev1.Service) bool {
+	return service.Annotations[shareExitServerAnnotation] == "true"
+}
+
+// canShareExitServer reports whether the Tunnel's exit server can be shared
+// with others. Services with source ranges are excluded, as the ranges
+// would apply to all the Services on the exit server.
+func canShareExitServer(tunnel *inletsv1alpha1.Tunnel, service *corev1.Service) bool {
+	if !tunnel.Spec.ShareExitServer || len(tunnel.Spec.ExitServerPool) > 0 {
+		return false
+	}
+	ranges, err := getSourceRanges(service)
+	return err == nil && len(ranges) == 0
+}
+
+// pickSharedHost returns the first exit server in the region with none of
+// the ports in use.
+func pickSharedHost(region string, ports []int32, hosts []sharedHost) *sharedHost {
+	for i, h := range hosts {
+		if h.Region != region {
+			continue
+		}
+
+		free := true
+		for _, p := range ports {
+			if h.Ports[p] {
+				free = false
+				break
+			}
+		}
+		if free {
+			return &hosts[i]
+		}
+	}
+	return nil
+}
+
+// getSharedHosts groups the Tunnels in the namespace which can share their
+// exit server by HostID, sorted by the name of the first Tunnel.
+func getSharedHosts(c *Controller, namespace, exclude string) ([]sharedHost, error) {
+	tunnels, err := c.tunnelsLister.Tunnels(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing tunnels: %s", err)
+	}
+
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Name < tunnels[j].Name })
+
+	hosts := map[string]*sharedHost{}
+	var ids []string
+	for _, t := range tunnels {
+		if t.Name == exclude || len(t.Status.HostID) == 0 || getUpstream(t) == "" {
+			continue
+		}
+
+		service, err := c.lookupService(t)
+		if err != nil {
+			continue
+		}
+
+		h, ok := hosts[t.Status.HostID]
+		if !ok {
+			h = &sharedHost{
+				Tunnel: t,
+				Region: getTunnelRegion(c, t),
+				Ports:  map[int32]bool{},
+			}
+			hosts[t.Status.HostID] = h
+			ids = append(ids, t.Status.HostID)
+		}
+
+		// A host is only shared if every Tunnel on it allows it
+		if !canShareExitServer(t, service) {
+			h.Tunnel = nil
+		}
+		for _, p := range getTunnelPorts(t, service) {
+			h.Ports[p] = true
+		}
+	}
+
+	res := []sharedHost{}
+	for _, id := range ids {
+		if hosts[id].Tunnel != nil {
+			res = append(res, *hosts[id])
+		}
+	}
+	return res, nil
+}
+
+// joinSharedHost gives the Tunnel an existing exit server if one has none
+// of the Service's ports in use, and returns false if there is none.
+func joinSharedHost(tunnel *inletsv1alpha1.Tunnel, service *corev1.Service, c *Controller) (bool, error) {
+	hosts, err := getSharedHosts(c, tunnel.Namespace, tunnel.Name)
+	if err != nil {
+		return false, err
+	}
+
+	rules, err := getFirewallRules(tunnel, service)
+	if err != nil {
+		return false, err
+	}
+
+	host := pickSharedHost(getTunnelRegion(c, tunnel), rules.Ports, hosts)
+	if host == nil {
+		return false, nil
+	}
+
+	// The exit server has a single token, which is kept until the last
+	// Tunnel using it is deleted by giving each Tunnel an owner reference.
+	owner := host.Tunnel
+	secretName := getSecretName(owner)
+	if err := addSecretOwner(c, tunnel, secretName); err != nil {
+		return false, err
+	}
+	if owner.Status.TLS != nil && owner.Status.TLS.CASecretRef != nil {
+		if err := addSecretOwner(c, tunnel, owner.Status.TLS.CASecretRef.Name); err != nil {
+			return false, err
+		}
+	}
+
+	c.tunnelLogger(tunnel).Info("Sharing exit server", logKeyHostID, owner.Status.HostID, "owner", owner.Name)
+	c.recordEvent(tunnel, corev1.EventTypeNormal, SharedExitServer,
+		"Sharing exit server %s with Tunnel %s", owner.Status.HostID, owner.Name)
+
+	copy := tunnel.DeepCopy()
+	copy.Status.AuthTokenRef = &inletsv1alpha1.ResourceRef{
+		Name:      secretName,
+		Namespace: tunnel.Namespace,
+	}
+	copy.Status.TLS = owner.Status.TLS
+	copy.Status.Firewall = nil
+
+	updated, err := c.updateTunnelProvisioningStatus(copy, owner.Status.HostStatus, owner.Status.HostID, owner.Status.HostIP)
+	if err != nil {
+		return false, fmt.Errorf("tunnel %s.%s update error: %s", tunnel.Name, tunnel.Namespace, err)
+	}
+
+	if len(owner.Status.HostIP) > 0 {
+		if err := c.updateService(updated, owner.Status.HostIP); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// addSecretOwner adds the Tunnel as an owner of the Secret, so that it is
+// only garbage collected once every owner has been deleted.
+func addSecretOwner(c *Controller, tunnel *inletsv1alpha1.Tunnel, name string) error {
+	secrets := c.kubeclientset.CoreV1().Secrets(tunnel.Namespace)
+
+	secret, err := secrets.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting secret %s.%s: %s", name, tunnel.Namespace, err)
+	}
+
+	for _, ref := range secret.OwnerReferences {
+		if ref.UID == tunnel.UID {
+			return nil
+		}
+	}
+
+	secret.OwnerReferences = append(secret.OwnerReferences, metav1.OwnerReference{
+		APIVersion: inletsv1alpha1.SchemeGroupVersion.String(),
+		Kind:       "Tunnel",
+		Name:       tunnel.Name,
+		UID:        tunnel.UID,
+	})
+
+	_, err = secrets.Update(context.Background(), secret, metav1.UpdateOptions{})
+	return err
+}
+
+// countHostTunnels returns the number of other Tunnels using the exit
+// server, which is only deleted when this reaches zero.
+func countHostTunnels(c *Controller, tunnel *inletsv1alpha1.Tunnel) int {
+	tunnels, err := c.tunnelsLister.Tunnels(tunnel.Namespace).List(labels.Everything())
+	if err != nil {
+		return 0
+	}
+
+	n := 0
+	for _, t := range tunnels {
+		if t.Name != tunnel.Name && t.Status.HostID == tunnel.Status.HostID {
+			n++
+		}
+	}
+	return n
+}
+
+// getSharedPorts returns the ports of every Service on the Tunnel's exit
+// server, so that a firewall managed for it opens all of them.
+func getSharedPorts(c *Controller, tunnel *inletsv1alpha1.Tunnel, rules firewallRules) []int32 {
+	if len(tunnel.Status.HostID) == 0 {
+		return rules.Ports
+	}
+
+	tunnels, err := c.tunnelsLister.Tunnels(tunnel.Namespace).List(labels.Everything())
+	if err != nil {
+		return rules.Ports
+	}
+
+	ports := map[int32]bool{}
+	for _, p := range rules.Ports {
+		ports[p] = true
+	}
+
+	for _, t := range tunnels {
+		if t.Name == tunnel.Name || t.Status.HostID != tunnel.Status.HostID || getUpstream(t) == "" {
+			continue
+		}
+		service, err := c.lookupService(t)
+		if err != nil {
+			continue
+		}
+		for _, p := range getTunnelPorts(t, service) {
+			ports[p] = true
+		}
+	}
+
+	res := []int32{}
+	for p := range ports {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+func Test_pickSharedHost_NoOverlap(t *testing.T) {
+	hosts := []sharedHost{
+		{Tunnel: &inletsv1alpha1.Tunnel{}, Region: "lon1", Ports: map[int32]bool{80: true, 443: true}},
+		{Tunnel: &inletsv1alpha1.Tunnel{}, Region: "lon1", Ports: map[int32]bool{5432: true}},
+	}
+
+	got := pickSharedHost("lon1", []int32{6379}, hosts)
+	if got != &hosts[0] {
+		t.Fatalf("want the first host, but got %v", got)
+	}
+}
+
+func Test_pickSharedHost_Overlap(t *testing.T) {
+	hosts := []sharedHost{
+		{Tunnel: &inletsv1alpha1.Tunnel{}, Region: "lon1", Ports: map[int32]bool{80: true, 443: true}},
+		{Tunnel: &inletsv1alpha1.Tunnel{}, Region: "lon1", Ports: map[int32]bool{5432: true}},
+	}
+
+	got := pickSharedHost("lon1", []int32{443}, hosts)
+	if got != &hosts[1] {
+		t.Fatalf("want the second host, but got %v", got)
+	}
+
+	if got := pickSharedHost("lon1", []int32{80, 5432}, hosts); got != nil {
+		t.Fatalf("want no host, but got %v", got)
+	}
+}
+
+func Test_pickSharedHost_OtherRegion(t *testing.T) {
+	hosts := []sharedHost{
+		{Tunnel: &inletsv1alpha1.Tunnel{}, Region: "nyc1", Ports: map[int32]bool{80: true}},
+	}
+
+	if got := pickSharedHost("lon1", []int32{443}, hosts); got != nil {
+		t.Fatalf("want no host in another region, but got %v", got)
+	}
+}
+
+func Test_canShareExitServer(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{
+		Spec: inletsv1alpha1.TunnelSpec{ShareExitServer: true},
+	}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "redis"}}
+
+	if !canShareExitServer(tunnel, svc) {
+		t.Errorf("want Tunnel to be shareable")
+	}
+
+	svc.Spec.LoadBalancerSourceRanges = []string{"203.0.113.0/24"}
+	if canShareExitServer(tunnel, svc) {
+		t.Errorf("want Tunnel with source ranges not to be shareable")
+	}
+
+	svc.Spec.LoadBalancerSourceRanges = nil
+	tunnel.Spec.ShareExitServer = false
+	if canShareExitServer(tunnel, svc) {
+		t.Errorf("want Tunnel without spec.shareExitServer not to be shareable")
+	}
+}
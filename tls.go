@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// caCertKey is the key for the CA certificate in the Secret mounted into
+// the client Deployment
+const caCertKey = "ca.crt"
+
+// serverNameSuffix is appended to the Tunnel's name and namespace to give
+// the name in the exit server's certificate. It does not need to resolve,
+// the client Deployment maps it to the HostIP.
+const serverNameSuffix = "tunnels.operator.inlets.dev"
+
+const (
+	caValidity     = time.Hour * 24 * 365 * 10
+	serverValidity = time.Hour * 24 * 365 * 5
+)
+
+// certAuthority issues certificates for the exit servers, so that the
+// clients can pin the operator's CA rather than trusting any certificate
+// presented at the HostIP.
+type certAuthority struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+// newCertAuthority generates a self-signed CA.
+func newCertAuthority() (*certAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating CA key: %s", err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "inlets-operator CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CA certificate: %s", err)
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCertAuthority(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM)
+}
+
+// parseCertAuthority loads a CA from PEM encoded data.
+func parseCertAuthority(certPEM, keyPEM []byte) (*certAuthority, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no PEM data found in CA certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA certificate: %s", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", cert.Subject.CommonName)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no PEM data found in CA key")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA key: %s", err)
+	}
+
+	return &certAuthority{
+		cert:    cert,
+		key:     key,
+		certPEM: certPEM,
+	}, nil
+}
+
+// issue returns a PEM encoded certificate and key for an exit server.
+func (ca *certAuthority) issue(serverName string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating server key: %s", err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(serverValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating server certificate: %s", err)
+	}
+
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding key: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %s", err)
+	}
+	return serial, nil
+}
+
+// ensureCertAuthority reads the CA from a Secret in the operator's
+// namespace, and creates it on first use.
+func ensureCertAuthority(kubeClient kubernetes.Interface, namespace, name string) (*certAuthority, error) {
+	secret, err := kubeClient.CoreV1().
+		Secrets(namespace).
+		Get(context.Background(), name, metav1.GetOptions{})
+	if err == nil {
+		return parseCertAuthority(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	}
+	if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("error reading CA secret %s.%s: %s", name, namespace, err)
+	}
+
+	ca, err := newCertAuthority()
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := encodeKey(ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := kubeClient.CoreV1().
+		Secrets(namespace).
+		Create(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       ca.certPEM,
+				corev1.TLSPrivateKeyKey: keyPEM,
+			},
+		}, metav1.CreateOptions{}); err != nil {
+		if errors.IsAlreadyExists(err) {
+			// Created by another replica of the operator
+			return ensureCertAuthority(kubeClient, namespace, name)
+		}
+		return nil, fmt.Errorf("error creating CA secret %s.%s: %s", name, namespace, err)
+	}
+
+	slog.Info("Created CA for exit servers", "secret", name, logKeyNamespace, namespace)
+	return ca, nil
+}
+
+// getServerName returns the name in the exit server's certificate.
+func getServerName(tunnel *inletsv1alpha1.Tunnel) string {
+	return fmt.Sprintf("%s.%s.%s", tunnel.Name, tunnel.Namespace, serverNameSuffix)
+}
+
+// createTunnelCASecret copies the CA certificate into the Tunnel's
+// namespace for its client Deployment, and returns the status to record
+// once the exit server has been created. A Secret of the same name which
+// the Tunnel does not own is left alone.
+func createTunnelCASecret(tunnel *inletsv1alpha1.Tunnel, c *Controller) (*inletsv1alpha1.TLSStatus, error) {
+	name := tunnel.Name + "-ca"
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: tunnel.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(tunnel, schema.GroupVersionKind{
+					Group:   inletsv1alpha1.SchemeGroupVersion.Group,
+					Version: inletsv1alpha1.SchemeGroupVersion.Version,
+					Kind:    "Tunnel",
+				}),
+			},
+		},
+		Data: map[string][]byte{
+			caCertKey: c.ca.certPEM,
+		},
+	}
+
+	if _, err := c.kubeclientset.CoreV1().
+		Secrets(tunnel.Namespace).
+		Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("unable to create CA secret: %s", err.Error())
+		}
+
+		existing, err := c.kubeclientset.CoreV1().
+			Secrets(tunnel.Namespace).
+			Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get CA secret: %s", err.Error())
+		}
+
+		if !metav1.IsControlledBy(existing, tunnel) {
+			c.recordEvent(tunnel, corev1.EventTypeWarning, ErrResourceExists, MessageResourceExists, name)
+			return nil, fmt.Errorf(MessageResourceExists, name)
+		}
+
+		existing = existing.DeepCopy()
+		existing.Data = secret.Data
+		if _, err := c.kubeclientset.CoreV1().
+			Secrets(tunnel.Namespace).
+			Update(context.Background(), existing, metav1.UpdateOptions{}); err != nil {
+			return nil, fmt.Errorf("unable to update CA secret: %s", err.Error())
+		}
+	}
+
+	return &inletsv1alpha1.TLSStatus{
+		ServerName: getServerName(tunnel),
+		CASecretRef: &inletsv1alpha1.ResourceRef{
+			Name:      name,
+			Namespace: tunnel.Namespace,
+		},
+	}, nil
+}
+
+// makeServerTLSUserdata returns a script to install the exit server's
+// certificate, and to restart inlets-pro with it in place of the
+// self-signed certificate it generates by default.
+func makeServerTLSUserdata(certPEM, keyPEM []byte) string {
+	return `
+# Use the certificate issued by the inlets-operator's CA
+mkdir -p /etc/inlets-pro /etc/systemd/system/inlets-pro.service.d
+cat > /etc/inlets-pro/tls.crt <<'EOF'
+` + string(certPEM) + `EOF
+cat > /etc/inlets-pro/tls.key <<'EOF'
+` + string(keyPEM) + `EOF
+chmod 600 /etc/inlets-pro/tls.key
+cat > /etc/systemd/system/inlets-pro.service.d/tls.conf <<'EOF'
+[Service]
+ExecStart=
+ExecStart=/usr/local/bin/inlets-pro tcp server --auto-tls=false --tls-cert=/etc/inlets-pro/tls.crt --tls-key=/etc/inlets-pro/tls.key --token="${AUTHTOKEN}"
+EOF
+systemctl daemon-reload && \
+  systemctl restart inlets-pro
+`
+}
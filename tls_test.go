@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+func Test_certAuthority_issue(t *testing.T) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	serverName := "nginx-tunnel.default." + serverNameSuffix
+	certPEM, keyPEM, err := ca.issue(serverName)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(keyPEM), "EC PRIVATE KEY") {
+		t.Fatalf("want a PEM encoded key, but got %s", keyPEM)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.certPEM)
+
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: serverName, Roots: roots}); err != nil {
+		t.Fatalf("want certificate to verify against the CA, but got: %s", err)
+	}
+
+	other, err := newCertAuthority()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	otherRoots := x509.NewCertPool()
+	otherRoots.AppendCertsFromPEM(other.certPEM)
+
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: serverName, Roots: otherRoots}); err == nil {
+		t.Fatalf("want certificate to be rejected by another CA")
+	}
+}
+
+func Test_parseCertAuthority_RoundTrip(t *testing.T) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	keyPEM, err := encodeKey(ca.key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := parseCertAuthority(ca.certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !got.cert.Equal(ca.cert) {
+		t.Fatalf("want the same CA certificate after parsing")
+	}
+}
+
+func Test_makeClientDeployment_PinsCA(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", Namespace: "default"},
+		Spec: inletsv1alpha1.TunnelSpec{
+			ServiceRef: &inletsv1alpha1.ResourceRef{Name: "nginx", Namespace: "default"},
+		},
+		Status: inletsv1alpha1.TunnelStatus{
+			HostIP: "203.0.113.10",
+			TLS: &inletsv1alpha1.TLSStatus{
+				ServerName:  "nginx-tunnel.default." + serverNameSuffix,
+				CASecretRef: &inletsv1alpha1.ResourceRef{Name: "nginx-tunnel-ca", Namespace: "default"},
+			},
+		},
+	}
+
+	dep := makeClientDeployment(tunnel, "ghcr.io/inlets/inlets-pro:0.9.40", "80", "license", "128Mi")
+	spec := dep.Spec.Template.Spec
+	args := strings.Join(spec.Containers[0].Args, " ")
+
+	for _, want := range []string{
+		"--url=wss://nginx-tunnel.default." + serverNameSuffix + ":8123/connect",
+		"--tls-ca=/var/inlets/ca/ca.crt",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("want args to contain %s, but got %s", want, args)
+		}
+	}
+
+	if len(spec.HostAliases) != 1 || spec.HostAliases[0].IP != "203.0.113.10" ||
+		spec.HostAliases[0].Hostnames[0] != tunnel.Status.TLS.ServerName {
+		t.Errorf("want host alias for %s to 203.0.113.10, but got %v", tunnel.Status.TLS.ServerName, spec.HostAliases)
+	}
+
+	found := false
+	for _, v := range spec.Volumes {
+		if v.Secret != nil && v.Secret.SecretName == "nginx-tunnel-ca" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("want the CA secret to be mounted, but got %v", spec.Volumes)
+	}
+}
+
+func Test_makeClientDeployment_NoTLS(t *testing.T) {
+	tunnel := &inletsv1alpha1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel", Namespace: "default"},
+		Spec: inletsv1alpha1.TunnelSpec{
+			ServiceRef: &inletsv1alpha1.ResourceRef{Name: "nginx", Namespace: "default"},
+		},
+		Status: inletsv1alpha1.TunnelStatus{HostIP: "203.0.113.10"},
+	}
+
+	dep := makeClientDeployment(tunnel, "ghcr.io/inlets/inlets-pro:0.9.40", "80", "license", "128Mi")
+	args := strings.Join(dep.Spec.Template.Spec.Containers[0].Args, " ")
+
+	want := "--url=wss://203.0.113.10:8123/connect"
+	if !strings.Contains(args, want) {
+		t.Errorf("want args to contain %s, but got %s", want, args)
+	}
+	if strings.Contains(args, "--tls-ca") {
+		t.Errorf("want no --tls-ca, but got %s", args)
+	}
+}
+
+func Test_createTunnelCASecret_NotOwned(t *testing.T) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "")
+	tunnel.UID = "tunnel-uid"
+
+	other := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-tunnel-ca", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		kubeclientset: kubefake.NewSimpleClientset(other),
+		recorder:      recorder,
+		ca:            ca,
+	}
+
+	if _, err := createTunnelCASecret(tunnel, c); err == nil {
+		t.Fatalf("want an error for a Secret not owned by the Tunnel")
+	}
+
+	got, err := c.kubeclientset.CoreV1().Secrets("default").Get(context.Background(), "nginx-tunnel-ca", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(got.Data["password"]) != "secret" || len(got.Data[caCertKey]) > 0 {
+		t.Errorf("want the Secret to be left alone, but got %v", got.Data)
+	}
+
+	events := readEvents(recorder)
+	if len(events) != 1 || !strings.Contains(events[0], ErrResourceExists) {
+		t.Errorf("want an %s Event, but got %v", ErrResourceExists, events)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+)
+
+// userDataParams are the values available to a user-data template.
+type userDataParams struct {
+	// Token is the inlets-pro server's auth token
+	Token string
+	// Version is the inlets-pro release to install
+	Version string
+	// DownloadURL is the base URL for the inlets-pro release files
+	DownloadURL string
+	// Checksums are the SHA256 checksums of the release files, which
+	// may be empty if none are configured
+	Checksums ReleaseChecksums
+	// ControlPort is the port the tunnel client connects to
+	ControlPort int
+	// Ports are the ports of the Service
+	Ports []int32
+	// PortsString is Ports as a comma-separated list, i.e. "80,443"
+	PortsString string
+
+	Provider string
+	Region   string
+
+	Tunnel  userDataTunnel
+	Service string
+
+	// Firewall is a script to restrict the Service's ports to its source
+	// ranges with iptables, it is empty for providers with cloud firewalls,
+	// or when no ranges are set.
+	Firewall string
+
+	// TLS is a script to install a certificate issued by the operator's
+	// CA on the exit server, it is empty unless -managed-tls is set.
+	TLS string
+
+	// Default is the user-data which is used when no template is given,
+	// including Firewall and TLS, so that a template can add to it.
+	Default string
+}
+
+// userDataTunnel is the metadata of the Tunnel the exit server is for.
+type userDataTunnel struct {
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// getUserDataTemplate returns the template given inline in the config or
+// via a file, or nil to use the default user-data. The file is read each
+// time, so that a mounted ConfigMap can be updated in place.
+func getUserDataTemplate(c InfraConfig) (*template.Template, error) {
+	text := c.UserDataTemplate
+	if len(text) == 0 {
+		if len(c.UserDataTemplateFile) == 0 {
+			return nil, nil
+		}
+
+		data, err := os.ReadFile(c.UserDataTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading user-data template: %s", err.Error())
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New("user-data").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing user-data template: %s", err.Error())
+	}
+
+	return tmpl, nil
+}
+
+// makeUserData renders the user-data for a Tunnel's exit server.
+func makeUserData(c *Controller, tunnel *inletsv1alpha1.Tunnel, service *corev1.Service, token, version string) (string, error) {
+	rules, err := getFirewallRules(tunnel, service)
+	if err != nil {
+		return "", err
+	}
+
+	firewall := ""
+	if hostFirewallRequired(c, tunnel) {
+		firewall = makeHostFirewallUserdata(rules)
+	}
+
+	checksums, err := getReleaseChecksums(c.config().ProConfig, version)
+	if err != nil {
+		return "", err
+	}
+	downloadURL := c.config().GetDownloadURL()
+
+	serviceName := ""
+	if tunnel.Spec.ServiceRef != nil {
+		serviceName = tunnel.Spec.ServiceRef.Name
+	}
+
+	tls := ""
+	if c.ca != nil {
+		certPEM, keyPEM, err := c.ca.issue(getServerName(tunnel))
+		if err != nil {
+			return "", err
+		}
+		tls = makeServerTLSUserdata(certPEM, keyPEM)
+	}
+
+	params := userDataParams{
+		Token:       token,
+		Version:     version,
+		DownloadURL: downloadURL,
+		Checksums:   checksums,
+		ControlPort: inletsPROControlPort,
+		Ports:       rules.Ports,
+		PortsString: getPortsString(tunnel, service),
+		Provider:    c.config().Provider,
+		Region:      getTunnelRegion(c, tunnel),
+		Tunnel: userDataTunnel{
+			Name:        tunnel.Name,
+			Namespace:   tunnel.Namespace,
+			Labels:      tunnel.Labels,
+			Annotations: tunnel.Annotations,
+		},
+		Service:  serviceName,
+		Firewall: firewall,
+		TLS:      tls,
+		Default:  makeExitServerUserdata(token, version, downloadURL, checksums) + firewall + tls,
+	}
+
+	tmpl, err := getUserDataTemplate(*c.config())
+	if err != nil {
+		return "", err
+	}
+
+	return renderUserData(tmpl, params)
+}
+
+func renderUserData(tmpl *template.Template, params userDataParams) (string, error) {
+	if tmpl == nil {
+		return params.Default, nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", fmt.Errorf("error rendering user-data template: %s", err.Error())
+	}
+
+	return buf.String(), nil
+}
+
+var sha256Pattern = regexp.MustCompile("^[a-f0-9]{64}$")
+
+// getReleaseChecksums returns the checksums for a release, when checksums
+// are required, both files must have one.
+func getReleaseChecksums(c InletsProConfig, release string) (ReleaseChecksums, error) {
+	sums := c.Checksums[release]
+	if !c.SkipChecksums && (len(sums.Server) == 0 || len(sums.Service) == 0) {
+		return ReleaseChecksums{}, fmt.Errorf("checksums are required, but not given for inlets-pro release: %s, give them or set skip-checksums", release)
+	}
+	return sums, nil
+}
+
+// validateChecksums checks that each checksum is a SHA256 hash, and that
+// the configured release has checksums when they are required.
+func validateChecksums(c InfraConfig) error {
+	for release, sums := range c.ProConfig.Checksums {
+		for _, sum := range []string{sums.Server, sums.Service} {
+			if len(sum) > 0 && !sha256Pattern.MatchString(sum) {
+				return fmt.Errorf("invalid SHA256 checksum for inlets-pro release %s: %q", release, sum)
+			}
+		}
+	}
+
+	_, err := getReleaseChecksums(c.ProConfig, c.GetInletsRelease())
+	return err
+}
+
+// makeExitServerUserdata installs the inlets-pro server as a systemd
+// service. The files are checked against their checksums, when given,
+// and nothing is installed if they do not match.
+func makeExitServerUserdata(authToken, version, downloadURL string, sums ReleaseChecksums) string {
+	verify := func(sum, path string) string {
+		if len(sum) == 0 {
+			return ""
+		}
+		return `  echo "` + sum + `  ` + path + `" | sha256sum --check --status && \
+`
+	}
+
+	base := strings.TrimSuffix(downloadURL, "/") + "/" + version
+
+	return `#!/bin/bash
+export AUTHTOKEN="` + authToken + `"
+export IP=$(curl -sfSL https://checkip.amazonaws.com)
+
+curl -SLsf ` + base + `/inlets-pro -o /tmp/inlets-pro && \
+` + verify(sums.Server, "/tmp/inlets-pro") + `  chmod +x /tmp/inlets-pro  && \
+  mv /tmp/inlets-pro /usr/local/bin/inlets-pro
+
+curl -SLsf ` + base + `/inlets-pro.service -o inlets-pro.service && \
+` + verify(sums.Service, "inlets-pro.service") + `  mv inlets-pro.service /etc/systemd/system/inlets-pro.service && \
+  echo "AUTHTOKEN=$AUTHTOKEN" >> /etc/default/inlets-pro && \
+  echo "IP=$IP" >> /etc/default/inlets-pro && \
+  systemctl daemon-reload && \
+  systemctl start inlets-pro && \
+  systemctl enable inlets-pro
+`
+}
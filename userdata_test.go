@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	provision "github.com/inlets/cloud-provision/provision"
+)
+
+func Test_renderUserData_Default(t *testing.T) {
+	params := userDataParams{Default: "#!/bin/bash\necho default\n"}
+
+	got, err := renderUserData(nil, params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got != params.Default {
+		t.Fatalf("want %q, but got %q", params.Default, got)
+	}
+}
+
+func Test_renderUserData_Template(t *testing.T) {
+	tmpl, err := getUserDataTemplate(InfraConfig{
+		UserDataTemplate: `#!/bin/bash
+# {{ .Tunnel.Namespace }}/{{ .Tunnel.Name }} team={{ index .Tunnel.Labels "team" }} owner={{ index .Tunnel.Labels "owner" }}
+export AUTHTOKEN="{{ .Token }}"
+export PORTS="{{ .PortsString }}"
+{{ range .Ports }}echo {{ . }}
+{{ end }}{{ .Default }}`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := renderUserData(tmpl, userDataParams{
+		Token:       "secret",
+		Version:     "0.9.40",
+		Ports:       []int32{80, 443},
+		PortsString: "80,443",
+		Tunnel: userDataTunnel{
+			Name:      "nginx-tunnel",
+			Namespace: "default",
+			Labels:    map[string]string{"team": "web"},
+		},
+		Default: "echo default\n",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := `#!/bin/bash
+# default/nginx-tunnel team=web owner=
+export AUTHTOKEN="secret"
+export PORTS="80,443"
+echo 80
+echo 443
+echo default
+`
+	if got != want {
+		t.Fatalf("want:\n%s\nbut got:\n%s", want, got)
+	}
+}
+
+func Test_getUserDataTemplate_None(t *testing.T) {
+	tmpl, err := getUserDataTemplate(InfraConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tmpl != nil {
+		t.Fatalf("want no template, but got one")
+	}
+}
+
+func Test_getUserDataTemplate_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user-data.tmpl")
+	if err := os.WriteFile(path, []byte("#!/bin/bash\necho {{ .Version }}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := getUserDataTemplate(InfraConfig{UserDataTemplateFile: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := renderUserData(tmpl, userDataParams{Version: "0.9.40"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := "#!/bin/bash\necho 0.9.40\n"
+	if got != want {
+		t.Fatalf("want %q, but got %q", want, got)
+	}
+}
+
+func Test_getUserDataTemplate_Invalid(t *testing.T) {
+	_, err := getUserDataTemplate(InfraConfig{UserDataTemplate: "{{ .Token "})
+	if err == nil {
+		t.Fatalf("want error for invalid template")
+	}
+
+	want := "error parsing user-data template"
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("want error containing %q, but got %q", want, err.Error())
+	}
+}
+
+func Test_makeExitServerUserdata_MatchesProvision(t *testing.T) {
+	got := makeExitServerUserdata("secret", "0.9.40", defaultDownloadURL, ReleaseChecksums{})
+	want := provision.MakeExitServerUserdata("secret", "0.9.40")
+
+	if got != want {
+		t.Fatalf("want:\n%s\nbut got:\n%s", want, got)
+	}
+}
+
+func Test_makeExitServerUserdata_MirrorAndChecksums(t *testing.T) {
+	server := strings.Repeat("a", 64)
+	service := strings.Repeat("b", 64)
+
+	got := makeExitServerUserdata("secret", "0.9.40", "https://mirror.example.com/inlets-pro/", ReleaseChecksums{
+		Server:  server,
+		Service: service,
+	})
+
+	for _, want := range []string{
+		"curl -SLsf https://mirror.example.com/inlets-pro/0.9.40/inlets-pro -o /tmp/inlets-pro && \\\n" +
+			"  echo \"" + server + "  /tmp/inlets-pro\" | sha256sum --check --status && \\\n" +
+			"  chmod +x /tmp/inlets-pro",
+		"curl -SLsf https://mirror.example.com/inlets-pro/0.9.40/inlets-pro.service -o inlets-pro.service && \\\n" +
+			"  echo \"" + service + "  inlets-pro.service\" | sha256sum --check --status && \\\n" +
+			"  mv inlets-pro.service",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want user-data to contain:\n%s\nbut got:\n%s", want, got)
+		}
+	}
+
+	if strings.Contains(got, "github.com") {
+		t.Errorf("want no downloads from github.com, but got:\n%s", got)
+	}
+}
+
+func Test_getReleaseChecksums_Required(t *testing.T) {
+	c := InletsProConfig{
+		Checksums: map[string]ReleaseChecksums{
+			"0.9.40": {Server: strings.Repeat("a", 64), Service: strings.Repeat("b", 64)},
+		},
+	}
+
+	if _, err := getReleaseChecksums(c, "0.9.40"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err := getReleaseChecksums(c, "0.9.39")
+	want := "checksums are required, but not given for inlets-pro release: 0.9.39, give them or set skip-checksums"
+	if err == nil || err.Error() != want {
+		t.Fatalf("want error %q, but got %v", want, err)
+	}
+}
+
+func Test_getReleaseChecksums_Skipped(t *testing.T) {
+	c := InletsProConfig{SkipChecksums: true}
+
+	got, err := getReleaseChecksums(c, "0.9.40")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != (ReleaseChecksums{}) {
+		t.Fatalf("want no checksums, but got %v", got)
+	}
+}
+
+func Test_validateChecksums_Invalid(t *testing.T) {
+	c := InfraConfig{
+		ProConfig: InletsProConfig{
+			Checksums: map[string]ReleaseChecksums{
+				"0.9.40": {Server: "not-a-checksum"},
+			},
+		},
+	}
+
+	err := validateChecksums(c)
+	want := `invalid SHA256 checksum for inlets-pro release 0.9.40: "not-a-checksum"`
+	if err == nil || err.Error() != want {
+		t.Fatalf("want error %q, but got %v", want, err)
+	}
+}
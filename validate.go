@@ -47,5 +47,29 @@ func validateFlags(c InfraConfig) error {
 		return fmt.Errorf("access-key or access-key-file must be given")
 	}
 
+	if err := validateNamespaces(c); err != nil {
+		return err
+	}
+
+	if len(c.UserDataTemplate) > 0 && len(c.UserDataTemplateFile) > 0 {
+		return fmt.Errorf("only one of userDataTemplate or user-data-template-file can be given")
+	}
+
+	if _, err := getUserDataTemplate(c); err != nil {
+		return err
+	}
+
+	if err := validateChecksums(c); err != nil {
+		return err
+	}
+
+	if err := validatePrices(c); err != nil {
+		return err
+	}
+
+	if err := validateQuotas(c.Quotas); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -21,6 +22,7 @@ func Test_validateFlags_DO(t *testing.T) {
 		Provider:  "digitalocean",
 		Region:    "lon1",
 		AccessKey: "set",
+		ProConfig: InletsProConfig{SkipChecksums: true},
 	}
 	err := validateFlags(c)
 
@@ -112,6 +114,7 @@ func Test_validateFlags_Azure_SubscriptionID_GoodValue(t *testing.T) {
 		Region:         "eastus",
 		SubscriptionID: "7136bb17-a334-41e1-9543-284f4af96420",
 		AccessKeyFile:  "key.json",
+		ProConfig:      InletsProConfig{SkipChecksums: true},
 	}
 	err := validateFlags(c)
 	if err != nil {
@@ -142,6 +145,7 @@ func Test_validateFlags_GoodMemoryValue(t *testing.T) {
 		Provider:        "digitalocean",
 		MaxClientMemory: "100Mi",
 		AccessKeyFile:   "key.json",
+		ProConfig:       InletsProConfig{SkipChecksums: true},
 	}
 
 	err := validateFlags(c)
@@ -155,6 +159,7 @@ func Test_validateFlags_EmptyMemoryValue(t *testing.T) {
 		Provider:        "digitalocean",
 		MaxClientMemory: "",
 		AccessKeyFile:   "key.json",
+		ProConfig:       InletsProConfig{SkipChecksums: true},
 	}
 
 	err := validateFlags(c)
@@ -163,11 +168,26 @@ func Test_validateFlags_EmptyMemoryValue(t *testing.T) {
 	}
 }
 
+func Test_validateFlags_ChecksumsRequired(t *testing.T) {
+	c := InfraConfig{
+		Provider:  "hetzner",
+		Region:    "fsn1",
+		AccessKey: "set",
+	}
+	err := validateFlags(c)
+
+	want := "checksums are required, but not given for inlets-pro release: 0.9.40, give them or set skip-checksums"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected error: %q, got: %v", want, err)
+	}
+}
+
 func Test_validateFlags_Hetzner(t *testing.T) {
 	c := InfraConfig{
 		Provider:  "hetzner",
 		Region:    "fsn1",
 		AccessKey: "set",
+		ProConfig: InletsProConfig{SkipChecksums: true},
 	}
 	err := validateFlags(c)
 
@@ -188,3 +208,56 @@ func Test_validateFlags_Region_Hetzner(t *testing.T) {
 		t.Errorf("expected error: %s, got: %s", want, err)
 	}
 }
+
+func Test_validateFlags_WatchNamespaces(t *testing.T) {
+	c := InfraConfig{
+		Provider:        "digitalocean",
+		AccessKey:       "set",
+		WatchNamespaces: []string{"team-a", "Team_B"},
+	}
+	err := validateFlags(c)
+	want := `invalid namespace in watch-namespaces: "Team_B"`
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error: %s, got: %s", want, err)
+	}
+}
+
+func Test_validateFlags_NamespaceSelector(t *testing.T) {
+	c := InfraConfig{
+		Provider:          "digitalocean",
+		AccessKey:         "set",
+		NamespaceSelector: "inlets.dev/tunnels in (enabled",
+	}
+	err := validateFlags(c)
+	want := "invalid namespace-selector"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error: %s, got: %s", want, err)
+	}
+}
+
+func Test_validateFlags_NamespaceSelectorWithWatchNamespaces(t *testing.T) {
+	c := InfraConfig{
+		Provider:          "digitalocean",
+		AccessKey:         "set",
+		WatchNamespaces:   []string{"team-a"},
+		NamespaceSelector: "inlets.dev/tunnels=enabled",
+	}
+	err := validateFlags(c)
+	want := "namespace-selector cannot be used with watch-namespaces"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error: %s, got: %s", want, err)
+	}
+}
+
+func Test_validateFlags_UserDataTemplate(t *testing.T) {
+	c := InfraConfig{
+		Provider:         "digitalocean",
+		AccessKey:        "set",
+		UserDataTemplate: "#!/bin/bash\n{{ .Default ",
+	}
+	err := validateFlags(c)
+	want := "error parsing user-data template"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error: %s, got: %s", want, err)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	clientset "github.com/inlets/inlets-operator/pkg/generated/clientset/versioned"
+)
+
+const (
+	// validateTunnelPath is the path of the validating webhook for Tunnels
+	validateTunnelPath = "/validate-tunnel"
+	// mutateTunnelPath is the path of the defaulting webhook for Tunnels
+	mutateTunnelPath = "/mutate-tunnel"
+)
+
+// maxAdmissionReviewSize limits the size of AdmissionReview requests
+const maxAdmissionReviewSize = 1 << 20
+
+// tunnelWebhook rejects invalid Tunnels when they are created or updated,
+// rather than when they are synced, and fills in defaults.
+type tunnelWebhook struct {
+	kubeclientset kubernetes.Interface
+	// operatorclientset reads the TunnelGrants for Services in other
+	// namespaces, and the ExitServerPools
+	operatorclientset clientset.Interface
+	// provider is the operator's infrastructure provider, for the fields
+	// it does not support
+	provider string
+}
+
+// jsonPatchOp is one operation of a JSON patch, returned by the
+// defaulting webhook.
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// defaultTunnel returns a patch to set the namespace of the Tunnel's
+// references, which are resolved in the Tunnel's own namespace unless
+// another is given.
+func defaultTunnel(tunnel *inletsv1alpha1.Tunnel, namespace string) []jsonPatchOp {
+	patch := []jsonPatchOp{}
+
+	if ref := tunnel.Spec.ServiceRef; ref != nil && len(ref.Namespace) == 0 {
+		patch = append(patch, jsonPatchOp{Op: "add", Path: "/spec/serviceRef/namespace", Value: namespace})
+	}
+	if ref := tunnel.Spec.AuthTokenRef; ref != nil && len(ref.Namespace) == 0 {
+		patch = append(patch, jsonPatchOp{Op: "add", Path: "/spec/authTokenRef/namespace", Value: namespace})
+	}
+
+	return patch
+}
+
+// validateTunnelSpec checks the fields of the spec which do not depend on
+// other resources.
+func validateTunnelSpec(tunnel *inletsv1alpha1.Tunnel, namespace, provider string) field.ErrorList {
+	errs := field.ErrorList{}
+	spec := field.NewPath("spec")
+
+	upstream := tunnel.Spec.Upstream
+	if ref := tunnel.Spec.ServiceRef; ref == nil || len(ref.Name) == 0 {
+		if len(upstream) == 0 {
+			errs = append(errs, field.Required(spec.Child("serviceRef", "name"), "the Service or upstream to expose must be given"))
+		}
+	} else if len(upstream) > 0 {
+		errs = append(errs, field.Forbidden(spec.Child("upstream"), "cannot be set with serviceRef"))
+	}
+
+	if len(upstream) > 0 {
+		if net.ParseIP(upstream) == nil {
+			for _, msg := range validation.IsDNS1123Subdomain(upstream) {
+				errs = append(errs, field.Invalid(spec.Child("upstream"), upstream, msg))
+			}
+		}
+		if len(tunnel.Spec.Ports) == 0 {
+			errs = append(errs, field.Required(spec.Child("ports"), "the ports to forward to the upstream must be given"))
+		}
+	}
+
+	if ref := tunnel.Spec.AuthTokenRef; ref != nil {
+		if len(ref.Name) == 0 {
+			errs = append(errs, field.Required(spec.Child("authTokenRef", "name"), "the Secret with the token must be given"))
+		}
+		if len(ref.Namespace) > 0 && ref.Namespace != namespace {
+			errs = append(errs, field.Invalid(spec.Child("authTokenRef", "namespace"), ref.Namespace,
+				"must be the Tunnel's namespace"))
+		}
+	}
+
+	if len(tunnel.Spec.Region) > 0 {
+		for _, msg := range validation.IsDNS1123Label(tunnel.Spec.Region) {
+			errs = append(errs, field.Invalid(spec.Child("region"), tunnel.Spec.Region, msg))
+		}
+		if providerIgnoresRegion(provider) {
+			errs = append(errs, field.Forbidden(spec.Child("region"),
+				fmt.Sprintf("is not supported by provider %s, exit servers are created in the operator's zone", provider)))
+		}
+	}
+
+	seen := map[int32]bool{}
+	for i, p := range tunnel.Spec.Ports {
+		path := spec.Child("ports").Index(i)
+		switch {
+		case p < 1 || p > 65535:
+			errs = append(errs, field.Invalid(path, p, "must be between 1 and 65535"))
+		case p == inletsPROControlPort:
+			errs = append(errs, field.Invalid(path, p, "is used by the exit server for the tunnel's control plane"))
+		case seen[p]:
+			errs = append(errs, field.Duplicate(path, p))
+		}
+		seen[p] = true
+	}
+
+	if len(tunnel.Spec.ExitServerPool) > 0 && tunnel.Spec.AuthTokenRef != nil {
+		errs = append(errs, field.Forbidden(spec.Child("authTokenRef"),
+			"cannot be set with exitServerPool, the token of the pool's server is used"))
+	}
+
+	return errs
+}
+
+// validateTunnelService checks that the Service's ports can be tunnelled,
+// inlets-pro forwards TCP, and the exit server's control port is taken.
+func validateTunnelService(service *corev1.Service) field.ErrorList {
+	errs := field.ErrorList{}
+	path := field.NewPath("spec", "serviceRef", "name")
+
+	if len(service.Spec.Ports) == 0 {
+		errs = append(errs, field.Invalid(path, service.Name, "the Service has no ports"))
+	}
+
+	for _, p := range service.Spec.Ports {
+		if len(p.Protocol) > 0 && p.Protocol != corev1.ProtocolTCP {
+			errs = append(errs, field.Invalid(path, service.Name,
+				fmt.Sprintf("port %d uses %s, only TCP can be tunnelled", p.Port, p.Protocol)))
+		}
+		if p.Port == inletsPROControlPort {
+			errs = append(errs, field.Invalid(path, service.Name,
+				fmt.Sprintf("port %d is used by the exit server for the tunnel's control plane", p.Port)))
+		}
+	}
+
+	return errs
+}
+
+// validateTunnelUpdate blocks changes to the spec which cannot be applied
+// to an exit server which has already been created, the Tunnel must be
+// deleted and recreated instead.
+func validateTunnelUpdate(old, tunnel *inletsv1alpha1.Tunnel) field.ErrorList {
+	errs := field.ErrorList{}
+	if len(old.Status.HostStatus) == 0 {
+		return errs
+	}
+
+	spec := field.NewPath("spec")
+	msg := "cannot be changed once the exit server has been created, delete and recreate the Tunnel instead"
+
+	refName := func(ref *inletsv1alpha1.ResourceRef) string {
+		if ref == nil {
+			return ""
+		}
+		return ref.Name
+	}
+
+	if refName(old.Spec.ServiceRef) != refName(tunnel.Spec.ServiceRef) ||
+		getServiceNamespace(old) != getServiceNamespace(tunnel) {
+		errs = append(errs, field.Forbidden(spec.Child("serviceRef"), msg))
+	}
+	if old.Spec.Upstream != tunnel.Spec.Upstream {
+		errs = append(errs, field.Forbidden(spec.Child("upstream"), msg))
+	}
+	if refName(old.Spec.AuthTokenRef) != refName(tunnel.Spec.AuthTokenRef) {
+		errs = append(errs, field.Forbidden(spec.Child("authTokenRef"), msg))
+	}
+	if old.Spec.Region != tunnel.Spec.Region {
+		errs = append(errs, field.Forbidden(spec.Child("region"), msg))
+	}
+	if old.Spec.ExitServerPool != tunnel.Spec.ExitServerPool {
+		errs = append(errs, field.Forbidden(spec.Child("exitServerPool"), msg))
+	}
+	if old.Spec.ShareExitServer != tunnel.Spec.ShareExitServer {
+		errs = append(errs, field.Forbidden(spec.Child("shareExitServer"), msg))
+	}
+
+	return errs
+}
+
+// validate checks the Tunnel, and the Service and Secret it references.
+// old is nil when the Tunnel is being created.
+func (w *tunnelWebhook) validate(ctx context.Context, tunnel, old *inletsv1alpha1.Tunnel, namespace string) field.ErrorList {
+	// Updates to metadata, i.e. by the garbage collector, are allowed
+	// even when the Service has since been deleted
+	if old != nil && (tunnel.DeletionTimestamp != nil || apiequality.Semantic.DeepEqual(old.Spec, tunnel.Spec)) {
+		return nil
+	}
+
+	errs := validateTunnelSpec(tunnel, namespace, w.provider)
+	if old != nil {
+		errs = append(errs, validateTunnelUpdate(old, tunnel)...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	spec := field.NewPath("spec")
+
+	if ref := tunnel.Spec.ServiceRef; ref != nil {
+		serviceNamespace := namespace
+		if len(ref.Namespace) > 0 {
+			serviceNamespace = ref.Namespace
+		}
+
+		if serviceNamespace != namespace && w.operatorclientset != nil {
+			granted, err := serviceGranted(ctx, w.operatorclientset, serviceNamespace, namespace, ref.Name)
+			if err != nil {
+				return append(errs, field.InternalError(spec.Child("serviceRef"), err))
+			}
+			if !granted {
+				return append(errs, field.Forbidden(spec.Child("serviceRef", "namespace"),
+					fmt.Sprintf("no TunnelGrant in namespace %s allows Tunnels from %s to reference Service %s", serviceNamespace, namespace, ref.Name)))
+			}
+		}
+
+		service, err := w.kubeclientset.CoreV1().
+			Services(serviceNamespace).
+			Get(ctx, ref.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			errs = append(errs, field.NotFound(spec.Child("serviceRef", "name"), ref.Name))
+		} else if err != nil {
+			errs = append(errs, field.InternalError(spec.Child("serviceRef"), err))
+		} else if len(tunnel.Spec.Ports) == 0 {
+			// The Tunnel's own ports are used instead of the Service's,
+			// i.e. for an ExternalName Service, which has none
+			errs = append(errs, validateTunnelService(service)...)
+		}
+	}
+
+	if service, serviceNamespace, ok := getUpstreamService(tunnel.Spec.Upstream); ok &&
+		serviceNamespace != namespace && w.operatorclientset != nil {
+		granted, err := serviceGranted(ctx, w.operatorclientset, serviceNamespace, namespace, service)
+		if err != nil {
+			return append(errs, field.InternalError(spec.Child("upstream"), err))
+		}
+		if !granted {
+			return append(errs, field.Forbidden(spec.Child("upstream"),
+				fmt.Sprintf("no TunnelGrant in namespace %s allows Tunnels from %s to reference Service %s", serviceNamespace, namespace, service)))
+		}
+	}
+
+	if pool := tunnel.Spec.ExitServerPool; len(pool) > 0 && w.operatorclientset != nil {
+		path := spec.Child("exitServerPool")
+
+		_, err := w.operatorclientset.OperatorV1alpha1().
+			ExitServerPools(namespace).
+			Get(ctx, pool, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			errs = append(errs, field.NotFound(path, pool))
+		} else if err != nil {
+			errs = append(errs, field.InternalError(path, err))
+		}
+	}
+
+	if ref := tunnel.Spec.AuthTokenRef; ref != nil {
+		path := spec.Child("authTokenRef", "name")
+
+		secret, err := w.kubeclientset.CoreV1().
+			Secrets(namespace).
+			Get(ctx, ref.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			errs = append(errs, field.NotFound(path, ref.Name))
+		} else if err != nil {
+			errs = append(errs, field.InternalError(path, err))
+		} else if len(secret.Data["token"]) == 0 {
+			errs = append(errs, field.Invalid(path, ref.Name, "the Secret has no value for the key: token"))
+		}
+	}
+
+	return errs
+}
+
+// admitValidate is the handler for the validating webhook.
+func (w *tunnelWebhook) admitValidate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	tunnel := &inletsv1alpha1.Tunnel{}
+	if err := json.Unmarshal(req.Object.Raw, tunnel); err != nil {
+		return admissionError(fmt.Errorf("error decoding tunnel: %s", err))
+	}
+
+	var old *inletsv1alpha1.Tunnel
+	if req.Operation == admissionv1.Update {
+		old = &inletsv1alpha1.Tunnel{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return admissionError(fmt.Errorf("error decoding tunnel: %s", err))
+		}
+	}
+
+	if errs := w.validate(context.Background(), tunnel, old, req.Namespace); len(errs) > 0 {
+		slog.Info("Rejected Tunnel", logKeyTunnel, req.Name, logKeyNamespace, req.Namespace, logKeyError, errs.ToAggregate())
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+				Message: errs.ToAggregate().Error(),
+			},
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// admitMutate is the handler for the defaulting webhook.
+func (w *tunnelWebhook) admitMutate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	tunnel := &inletsv1alpha1.Tunnel{}
+	if err := json.Unmarshal(req.Object.Raw, tunnel); err != nil {
+		return admissionError(fmt.Errorf("error decoding tunnel: %s", err))
+	}
+
+	res := &admissionv1.AdmissionResponse{Allowed: true}
+
+	patch := defaultTunnel(tunnel, req.Namespace)
+	if len(patch) == 0 {
+		return res
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return admissionError(fmt.Errorf("error encoding patch: %s", err))
+	}
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	res.Patch = data
+	res.PatchType = &patchType
+	return res
+}
+
+func admissionError(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonBadRequest,
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		},
+	}
+}
+
+// serveAdmission decodes an AdmissionReview, and replies with the
+// response from admit.
+func serveAdmission(admit func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			http.Error(w, fmt.Sprintf("unsupported content type: %s", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAdmissionReviewSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		review := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+			http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+			return
+		}
+
+		res := admit(review.Request)
+		res.UID = review.Request.UID
+
+		out, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Response: res,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// serveWebhook serves the admission and conversion webhooks over TLS on addr, it blocks
+// until the server exits.
+func serveWebhook(addr, certFile, keyFile, provider string, kubeclientset kubernetes.Interface, operatorclientset clientset.Interface) {
+	hook := &tunnelWebhook{kubeclientset: kubeclientset, operatorclientset: operatorclientset, provider: provider}
+
+	mux := http.NewServeMux()
+	mux.Handle(validateTunnelPath, serveAdmission(hook.admitValidate))
+	mux.Handle(mutateTunnelPath, serveAdmission(hook.admitMutate))
+	mux.HandleFunc(convertTunnelPath, serveConversion)
+
+	slog.Info("Serving admission webhooks", "addr", addr)
+	if err := http.ListenAndServeTLS(addr, certFile, keyFile, mux); err != nil {
+		fatal("Error serving admission webhooks", logKeyError, err)
+	}
+}
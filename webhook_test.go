@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	inletsv1alpha1 "github.com/inlets/inlets-operator/pkg/apis/inletsoperator/v1alpha1"
+	"github.com/inlets/inlets-operator/pkg/generated/clientset/versioned/fake"
+)
+
+func makeWebhook() *tunnelWebhook {
+	return &tunnelWebhook{
+		kubeclientset: kubefake.NewSimpleClientset(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					Ports: []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+				},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					Type:         corev1.ServiceTypeExternalName,
+					ExternalName: "db.example.com",
+				},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx-token", Namespace: "default"},
+				Data:       map[string][]byte{"token": []byte("secret")},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "empty-token", Namespace: "default"},
+			},
+		),
+		operatorclientset: fake.NewSimpleClientset(testPool.DeepCopy()),
+		provider:          "digitalocean",
+	}
+}
+
+func Test_defaultTunnel_SetsNamespaces(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.AuthTokenRef = &inletsv1alpha1.ResourceRef{Name: "nginx-token"}
+
+	patch := defaultTunnel(tunnel, "default")
+	if len(patch) != 2 {
+		t.Fatalf("want 2 patch operations, but got %d", len(patch))
+	}
+	if patch[0].Path != "/spec/serviceRef/namespace" || patch[0].Value != "default" {
+		t.Errorf("want serviceRef namespace defaulted, but got %+v", patch[0])
+	}
+	if patch[1].Path != "/spec/authTokenRef/namespace" || patch[1].Value != "default" {
+		t.Errorf("want authTokenRef namespace defaulted, but got %+v", patch[1])
+	}
+}
+
+func Test_defaultTunnel_NoChanges(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "default"
+
+	if patch := defaultTunnel(tunnel, "default"); len(patch) != 0 {
+		t.Fatalf("want no patch, but got %+v", patch)
+	}
+}
+
+func Test_validateTunnelSpec_MissingServiceRef(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.ServiceRef = nil
+
+	errs := validateTunnelSpec(tunnel, "default", "digitalocean")
+	if len(errs) != 1 || errs[0].Field != "spec.serviceRef.name" {
+		t.Fatalf("want error for spec.serviceRef.name, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelSpec_OtherNamespace(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "kube-system"
+
+	if errs := validateTunnelSpec(tunnel, "default", "digitalocean"); len(errs) != 0 {
+		t.Fatalf("want no errors, the TunnelGrant is checked with the Service, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelSpec_InvalidRegion(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.Region = "LON_1"
+
+	errs := validateTunnelSpec(tunnel, "default", "digitalocean")
+	if len(errs) == 0 || errs[0].Field != "spec.region" {
+		t.Fatalf("want error for spec.region, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelSpec_RegionNotSupported(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.Region = "europe-west1"
+
+	errs := validateTunnelSpec(tunnel, "default", "gce")
+	if len(errs) != 1 || errs[0].Field != "spec.region" || errs[0].Type != field.ErrorTypeForbidden {
+		t.Fatalf("want region to be forbidden for gce, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelSpec_RegionEC2(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.Region = "eu-west-1"
+
+	if errs := validateTunnelSpec(tunnel, "default", "ec2"); len(errs) != 0 {
+		t.Fatalf("want region to be allowed for ec2, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelSpec_Ports(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.Ports = []int32{80, 443, 80, 8123, 0}
+
+	errs := validateTunnelSpec(tunnel, "default", "digitalocean")
+	if len(errs) != 3 {
+		t.Fatalf("want 3 errors for the duplicate, control and zero ports, but got %v", errs)
+	}
+	if errs[0].Field != "spec.ports[2]" {
+		t.Errorf("want error for spec.ports[2], but got %s", errs[0].Field)
+	}
+}
+
+func Test_validateTunnelSpec_Upstream(t *testing.T) {
+	cases := []struct {
+		name     string
+		upstream string
+		ports    []int32
+		field    string
+	}{
+		{name: "ip", upstream: "192.168.0.10", ports: []int32{5432}},
+		{name: "dns name", upstream: "postgres.db.svc.cluster.local", ports: []int32{5432}},
+		{name: "invalid name", upstream: "db_1:5432", ports: []int32{5432}, field: "spec.upstream"},
+		{name: "no ports", upstream: "192.168.0.10", field: "spec.ports"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tunnel := makeTestTunnel("postgres-tunnel", "default", "postgres")
+			tunnel.Spec.ServiceRef = nil
+			tunnel.Spec.Upstream = tc.upstream
+			tunnel.Spec.Ports = tc.ports
+
+			errs := validateTunnelSpec(tunnel, "default", "digitalocean")
+			if tc.field == "" && len(errs) != 0 {
+				t.Fatalf("want no errors, but got %v", errs)
+			}
+			if tc.field != "" && (len(errs) == 0 || errs[0].Field != tc.field) {
+				t.Fatalf("want error for %s, but got %v", tc.field, errs)
+			}
+		})
+	}
+}
+
+func Test_validateTunnelSpec_UpstreamAndServiceRef(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.Upstream = "192.168.0.10"
+	tunnel.Spec.Ports = []int32{80}
+
+	errs := validateTunnelSpec(tunnel, "default", "digitalocean")
+	if len(errs) != 1 || errs[0].Field != "spec.upstream" {
+		t.Fatalf("want error for spec.upstream, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelService_Ports(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Port: 53, Protocol: corev1.ProtocolUDP},
+				{Port: 8123, Protocol: corev1.ProtocolTCP},
+				{Port: 443},
+			},
+		},
+	}
+
+	if errs := validateTunnelService(service); len(errs) != 2 {
+		t.Fatalf("want 2 errors for the UDP and control ports, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelUpdate_BeforeProvisioning(t *testing.T) {
+	old := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.Region = "lon1"
+
+	if errs := validateTunnelUpdate(old, tunnel); len(errs) != 0 {
+		t.Fatalf("want no errors before the exit server is created, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelUpdate_AfterProvisioning(t *testing.T) {
+	old := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	old.Status.HostStatus = "active"
+
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.Region = "lon1"
+	tunnel.Spec.UpdateServiceIP = true
+
+	errs := validateTunnelUpdate(old, tunnel)
+	if len(errs) != 1 || errs[0].Field != "spec.region" {
+		t.Fatalf("want only spec.region to be rejected, but got %v", errs)
+	}
+}
+
+func Test_validateTunnelUpdate_ServiceNamespace(t *testing.T) {
+	old := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+	old.Spec.ServiceRef.Namespace = "default"
+	old.Status.HostStatus = "active"
+
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "nginx")
+	tunnel.Spec.ServiceRef.Namespace = "staging"
+
+	errs := validateTunnelUpdate(old, tunnel)
+	if len(errs) != 1 || errs[0].Field != "spec.serviceRef" {
+		t.Fatalf("want spec.serviceRef to be rejected, but got %v", errs)
+	}
+
+	// Defaulting the namespace to the Tunnel's own is not a change
+	old.Spec.ServiceRef.Namespace = ""
+	tunnel.Spec.ServiceRef.Namespace = "edge"
+	if errs := validateTunnelUpdate(old, tunnel); len(errs) != 0 {
+		t.Fatalf("want no errors, but got %v", errs)
+	}
+}
+
+func Test_validate_ServiceNotFound(t *testing.T) {
+	errs := makeWebhook().validate(context.Background(), makeTestTunnel("missing-tunnel", "default", "missing"), nil, "default")
+	if len(errs) != 1 || errs[0].Field != "spec.serviceRef.name" {
+		t.Fatalf("want error for a missing Service, but got %v", errs)
+	}
+}
+
+func Test_validate_ExitServerPool(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Spec.ExitServerPool = "site-b"
+
+	errs := makeWebhook().validate(context.Background(), tunnel, nil, "default")
+	if len(errs) != 1 || errs[0].Field != "spec.exitServerPool" || errs[0].Type != field.ErrorTypeNotFound {
+		t.Fatalf("want error for a missing ExitServerPool, but got %v", errs)
+	}
+
+	tunnel.Spec.ExitServerPool = "site-a"
+	if errs := makeWebhook().validate(context.Background(), tunnel, nil, "default"); len(errs) != 0 {
+		t.Fatalf("want no errors for an existing ExitServerPool, but got %v", errs)
+	}
+}
+
+func Test_validate_ExternalNameService(t *testing.T) {
+	tunnel := makeTestTunnel("postgres-tunnel", "default", "postgres")
+
+	errs := makeWebhook().validate(context.Background(), tunnel, nil, "default")
+	if len(errs) != 1 || errs[0].Field != "spec.serviceRef.name" {
+		t.Fatalf("want error for a Service without ports, but got %v", errs)
+	}
+
+	tunnel.Spec.Ports = []int32{5432}
+	if errs := makeWebhook().validate(context.Background(), tunnel, nil, "default"); len(errs) != 0 {
+		t.Fatalf("want no errors with spec.ports, but got %v", errs)
+	}
+}
+
+func Test_validate_OtherNamespace(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+	tunnel.Namespace = "edge"
+	tunnel.Spec.ServiceRef.Namespace = "default"
+
+	hook := makeWebhook()
+	hook.operatorclientset = fake.NewSimpleClientset()
+	errs := hook.validate(context.Background(), tunnel, nil, "edge")
+	if len(errs) != 1 || errs[0].Field != "spec.serviceRef.namespace" {
+		t.Fatalf("want error for spec.serviceRef.namespace without a TunnelGrant, but got %v", errs)
+	}
+
+	hook.operatorclientset = fake.NewSimpleClientset(makeTunnelGrant("edge", "nginx"))
+	if errs := hook.validate(context.Background(), tunnel, nil, "edge"); len(errs) != 0 {
+		t.Fatalf("want no errors with a TunnelGrant, but got %v", errs)
+	}
+}
+
+func Test_validate_UpstreamOtherNamespace(t *testing.T) {
+	tunnel := makeTestTunnel("nginx-tunnel", "edge", "")
+	tunnel.Spec.Upstream = "nginx.default.svc.cluster.local"
+	tunnel.Spec.Ports = []int32{80}
+
+	hook := makeWebhook()
+	hook.operatorclientset = fake.NewSimpleClientset()
+	errs := hook.validate(context.Background(), tunnel, nil, "edge")
+	if len(errs) != 1 || errs[0].Field != "spec.upstream" {
+		t.Fatalf("want error for spec.upstream without a TunnelGrant, but got %v", errs)
+	}
+
+	hook.operatorclientset = fake.NewSimpleClientset(makeTunnelGrant("edge", "nginx"))
+	if errs := hook.validate(context.Background(), tunnel, nil, "edge"); len(errs) != 0 {
+		t.Fatalf("want no errors with a TunnelGrant, but got %v", errs)
+	}
+}
+
+func Test_validate_AuthTokenRef(t *testing.T) {
+	cases := []struct {
+		secret string
+		errs   int
+	}{
+		{secret: "nginx-token", errs: 0},
+		{secret: "empty-token", errs: 1},
+		{secret: "missing-token", errs: 1},
+	}
+
+	for _, c := range cases {
+		tunnel := makeTestTunnel("nginx-tunnel", "default", "nginx")
+		tunnel.Spec.AuthTokenRef = &inletsv1alpha1.ResourceRef{Name: c.secret}
+
+		if errs := makeWebhook().validate(context.Background(), tunnel, nil, "default"); len(errs) != c.errs {
+			t.Errorf("%s: want %d errors, but got %v", c.secret, c.errs, errs)
+		}
+	}
+}
+
+func Test_validate_MetadataUpdateAllowed(t *testing.T) {
+	old := makeTestTunnel("deleted-tunnel", "default", "deleted")
+	tunnel := makeTestTunnel("deleted-tunnel", "default", "deleted")
+	tunnel.Labels = map[string]string{"team": "a"}
+
+	if errs := makeWebhook().validate(context.Background(), tunnel, old, "default"); len(errs) != 0 {
+		t.Fatalf("want metadata update to be allowed, but got %v", errs)
+	}
+}
+
+func Test_serveAdmission_Validate(t *testing.T) {
+	hook := makeWebhook()
+	server := httptest.NewServer(serveAdmission(hook.admitValidate))
+	defer server.Close()
+
+	raw, _ := json.Marshal(makeTestTunnel("missing-tunnel", "default", "missing"))
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "1234",
+			Name:      "missing-tunnel",
+			Namespace: "default",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, _ := json.Marshal(review)
+
+	res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	got := admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Response == nil || got.Response.UID != "1234" {
+		t.Fatalf("want response for UID 1234, but got %+v", got.Response)
+	}
+	if got.Response.Allowed {
+		t.Fatalf("want Tunnel for a missing Service to be rejected")
+	}
+}